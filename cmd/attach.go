@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
@@ -10,8 +13,10 @@ import (
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
 )
 
@@ -19,8 +24,18 @@ var (
 	exe string
 
 	dryRun bool
+
+	stdin = bufio.NewReader(os.Stdin)
 )
 
+// attachedJob is a cron job that monitoring was attached to, along with the name and description the user gave it.
+type attachedJob struct {
+	job *cron.Job
+	jobConfig
+	// orig is the job's line in the crontab before monitoring was attached.
+	orig string
+}
+
 func init() {
 	var err error
 	exe, err = os.Executable()
@@ -41,9 +56,16 @@ func init() {
 			if err != nil {
 				return fmt.Errorf("could not determine current user: %w", err)
 			}
+			// Attaching doesn't need to connect to MQTT, so it's fine if cron2mqtt hasn't been configured yet, but the config might point to other crontabs.
+			if _, err := loadConfig(); err != nil {
+				if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+					return err
+				}
+			}
 			cts := cron.TabsForUser(u)
 
 			var updates []func()
+			attached := make(map[string]attachedJob)
 			for _, ct := range cts {
 				fmt.Printf("Checking %s\n", ct)
 				tc, err := ct.Load()
@@ -52,7 +74,7 @@ func init() {
 					continue
 				}
 
-				if attachTo(tc) {
+				if js := attachTo(tc); len(js) > 0 {
 					ct := ct
 					updates = append(updates, func() {
 						fmt.Println()
@@ -60,8 +82,20 @@ func init() {
 						if dryRun {
 							fmt.Print(tc)
 						} else {
-							if err := ct.Update(tc); err != nil {
+							err := ct.Update(tc)
+							if errors.Is(err, cron.ErrConflict) {
+								fmt.Printf("%s was changed in the meantime, attaching monitoring again...\n", ct)
+								var tc *cron.TabConfig
+								if tc, err = reattachTo(ct, js); err == nil {
+									err = ct.Update(tc)
+								}
+							}
+							if err != nil {
 								fmt.Fprintf(os.Stderr, "Could not update %s: %s\n", ct, err)
+								return
+							}
+							for id, j := range js {
+								attached[id] = j
 							}
 						}
 					})
@@ -72,6 +106,10 @@ func init() {
 				u()
 			}
 
+			if len(attached) > 0 {
+				publishAttached(cmd.Context(), attached)
+			}
+
 			return nil
 		},
 	}
@@ -79,7 +117,9 @@ func init() {
 	rootCmd.AddCommand(cmd)
 }
 
-func attachTo(c *cron.TabConfig) (updated bool) {
+// attachTo prompts the user to attach monitoring to each of the jobs in c, and returns the jobs that were attached by ID.
+func attachTo(c *cron.TabConfig) (attached map[string]attachedJob) {
+	attached = make(map[string]attachedJob)
 	for i, j := range c.Jobs() {
 		if j.Command.IsCron2Mqtt() {
 			fmt.Printf("  Skipping job #%d: It already appears to be monitored.\n", i+1)
@@ -90,23 +130,96 @@ func attachTo(c *cron.TabConfig) (updated bool) {
 		fmt.Println()
 		fmt.Printf("  $ %s\n", j.Command.String())
 		fmt.Println()
-		fmt.Printf("  Do you want to attach monitoring to this cron job? [yN] ")
-		var sel string
-		fmt.Scanln(&sel)
-		if strings.ToLower(sel) != "y" {
+		if sel := prompt("  Do you want to attach monitoring to this cron job? [yN] "); strings.ToLower(sel) != "y" {
 			continue
 		}
 
 		id := promptID()
+		jc := jobConfig{
+			Name:        prompt("  Enter a name [default: guessed from the command]: "),
+			Description: prompt("  Enter a description [optional]: "),
+		}
 
+		orig := j.String()
 		updateCommand(id, j.Command)
-		// TODO: Also update MQTT with the configuration of this cron job, even though it hasn't run yet.
-		updated = true
+		attached[id] = attachedJob{j, jc, orig}
 	}
 
 	return
 }
 
+// reattachTo reloads ct after someone else changed it, and attaches monitoring to the same jobs as before. It fails if any of those jobs were changed too.
+func reattachTo(ct cron.Tab, js map[string]attachedJob) (*cron.TabConfig, error) {
+	tc, err := ct.Load()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[*cron.Job]bool)
+	for id, aj := range js {
+		var found *cron.Job
+		for _, j := range tc.Jobs() {
+			if !used[j] && j.String() == aj.orig {
+				found = j
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("cron job was changed or removed: %s", aj.orig)
+		}
+		used[found] = true
+		updateCommand(id, found.Command)
+		aj.job = found
+		js[id] = aj
+	}
+	return tc, nil
+}
+
+// publishAttached saves the names of newly attached cron jobs, and publishes their configuration to MQTT, even though they haven't run yet.
+func publishAttached(ctx context.Context, js map[string]attachedJob) {
+	fmt.Println()
+	fmt.Println("Publishing attached cron jobs to MQTT...")
+	c, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load config, cron jobs will be published when they first run: %s\n", err)
+		return
+	}
+	for id, j := range js {
+		if j.jobConfig == (jobConfig{}) {
+			continue
+		}
+		if err := saveJobConfig(id, j.jobConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not save the name of %s: %s\n", id, err)
+		}
+	}
+	fs, err := pluginFactories()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load config, cron jobs will be published when they first run: %s\n", err)
+		return
+	}
+	cl, err := mqtt.NewClient(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not initialize MQTT, cron jobs will be published when they first run: %s\n", err)
+		return
+	}
+	defer cl.Close(250)
+
+	if expiry, err := expiryConfig("availability_expiry"); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not publish availability: %s\n", err)
+	} else if err := mqttcron.PublishOnline(ctx, cl, expiry); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not publish availability: %s\n", err)
+	}
+	for id, j := range js {
+		opts, err := jobOptions(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not publish %s: %s\n", id, err)
+			continue
+		}
+		if _, err := mqttcron.NewCronJob(id, cl, append(opts, mqttcron.CronJobConfig(j.job), mqttcron.CronJobPlugins(mqttcron.NewPlugins(fs)...), mqttcron.CronJobContext(ctx), mqttcron.CronJobClearStaleTopics())...); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not publish %s: %s\n", id, err)
+		}
+	}
+}
+
 func updateCommand(id string, cmd *cron.Command) {
 	pre := fmt.Sprintf("%s exec %s", exe, id)
 
@@ -131,11 +244,8 @@ func updateCommand(id string, cmd *cron.Command) {
 func promptID() string {
 	id := randomID(8)
 	for {
-		fmt.Printf("  Enter a job ID [default: %s]: ", id)
-		var sel string
-		fmt.Scanln(&sel)
-		if strings.TrimSpace(sel) != "" {
-			if err := mqttcron.ValidateTopicComponent(sel); err != nil {
+		if sel := prompt(fmt.Sprintf("  Enter a job ID [default: %s]: ", id)); sel != "" {
+			if err := mqttcron.ValidateID(sel); err != nil {
 				fmt.Fprintf(os.Stderr, "  ID %q is invalid: %s\n\n", sel, err)
 				continue
 			}
@@ -146,6 +256,13 @@ func promptID() string {
 	}
 }
 
+// prompt prints p, and returns the line that the user enters in response without any surrounding whitespace.
+func prompt(p string) string {
+	fmt.Print(p)
+	l, _ := stdin.ReadString('\n')
+	return strings.TrimSpace(l)
+}
+
 func randomID(n int) string {
 	b := make([]byte, n)
 	rand.Read(b)
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+const defaultGracePeriod = 5 * time.Minute
+
+func init() {
+	var grace time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Checks whether any local cron jobs missed their scheduled executions, and publishes the results to MQTT.",
+		Long:  "You only need to run this periodically (e.g. from a systemd timer) if you aren't running the daemon. The daemon performs the same check every minute.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("could not determine current user: %w", err)
+			}
+
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			cl, err := mqtt.NewClient(c)
+			if err != nil {
+				return fmt.Errorf("could not initialize MQTT: %w", err)
+			}
+			defer cl.Close(250)
+
+			return checkMissedRuns(cmd.Context(), cl, u, grace)
+		},
+	}
+	cmd.Flags().DurationVar(&grace, "grace_period", defaultGracePeriod, "How late a cron job is allowed to be before it's considered missed.")
+	rootCmd.AddCommand(cmd)
+}
+
+// checkMissedRuns compares the local history of each of the user's cron jobs to their schedule, and publishes whether they missed their most recent execution.
+func checkMissedRuns(ctx context.Context, cl *mqtt.Client, u *user.User, grace time.Duration) error {
+	defer logutil.StartTimer(zerolog.InfoLevel, "Checking for missed runs").Stop()
+	js, err := mqttcron.DiscoverLocalCronJobs(cron.TabsForUser(u), u)
+	if err != nil {
+		return err
+	}
+	h, err := historyStore()
+	if err != nil {
+		return err
+	}
+	fs, err := pluginFactories()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for id := range js {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	now := time.Now()
+	var errs error
+	for _, id := range ids {
+		j := js[id]
+		// The history only has executions that are done, but one might still be running.
+		lastStart, err := h.LastStart(id)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if lastStart.IsZero() {
+			// We don't know when this cron job was added, so we can't tell if it's missed anything.
+			log.Debug().Str("id", id).Msg("Cron job has no history")
+			continue
+		}
+
+		m := mqttcron.MissedRun{LastStartTime: lastStart}
+		if err := j.Schedule.Err(); err != nil {
+			// E.g. 7 for Sunday, which cron accepts but we can't compute the next execution for.
+			log.Debug().Str("id", id).Err(err).Msg("Cron job's schedule can't be checked")
+			continue
+		}
+		if j.Enabled() {
+			m = mqttcron.CheckMissedRun(j.Schedule, lastStart, now, grace)
+		}
+		if m.Missed {
+			log.Warn().Str("id", id).Time("expected", m.ExpectedTime).Msg("Cron job missed its scheduled execution")
+		}
+
+		opts, err := jobOptions(id)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		cj, err := mqttcron.ExistingCronJob(id, cl, append(opts, mqttcron.CronJobConfig(j), mqttcron.CronJobPlugins(mqttcron.NewPlugins(fs)...), mqttcron.CronJobContext(ctx))...)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not create mqttcron.CronJob: %w", err))
+			continue
+		}
+		if err := cj.PublishMissedRun(m); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not publish missed run for %s: %w", id, err))
+		}
+	}
+	return errs
+}
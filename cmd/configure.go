@@ -4,15 +4,22 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/term"
 
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
 	"github.com/JeffreyFalgout/cron2mqtt/logutil"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
 )
 
 func init() {
@@ -22,15 +29,41 @@ func init() {
 
 	var broker, username, serverName string
 	var setPassword bool
+	var pwdSource mqtt.PasswordSource
+	var protocolVersion int
 	configure := &cobra.Command{
 		Use:   "configure",
 		Short: "Configures how this tool publishes to MQTT.",
 		Long:  "The configuration will be written to a per-user config file. This is to prevent passwords from being more visible than strictly necessary.",
 		Args:  cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if broker == "" && username == "" && serverName == "" && !setPassword {
+			// Each password source replaces all of the others.
+			pwdSources := map[string]string{
+				"password_file":       pwdSource.PasswordFile,
+				"password_command":    pwdSource.PasswordCommand,
+				"password_env":        pwdSource.PasswordEnv,
+				"password_credential": pwdSource.PasswordCredential,
+			}
+			var pwdSourceFlags []string
+			for n, s := range pwdSources {
+				if s != "" {
+					pwdSourceFlags = append(pwdSourceFlags, "--"+n)
+				}
+			}
+			if setPassword {
+				pwdSourceFlags = append(pwdSourceFlags, "--password")
+			}
+			if len(pwdSourceFlags) > 1 {
+				sort.Strings(pwdSourceFlags)
+				return fmt.Errorf("only one of %s can be used", strings.Join(pwdSourceFlags, ", "))
+			}
+
+			if broker == "" && username == "" && serverName == "" && protocolVersion == 0 && len(pwdSourceFlags) == 0 {
 				return fmt.Errorf("configure must be called with at least one of its flags")
 			}
+			if protocolVersion != 0 && protocolVersion != mqtt.ProtocolVersion311 && protocolVersion != mqtt.ProtocolVersion5 {
+				return fmt.Errorf("--protocol_version must be %d (MQTT 3.1.1) or %d (MQTT 5)", mqtt.ProtocolVersion311, mqtt.ProtocolVersion5)
+			}
 
 			if setPassword {
 				pwd, err := promptPassword()
@@ -39,6 +72,14 @@ func init() {
 				}
 				viper.Set("password", string(pwd))
 			}
+			if len(pwdSourceFlags) > 0 {
+				if !setPassword {
+					viper.Set("password", "")
+				}
+				for n, s := range pwdSources {
+					viper.Set(n, s)
+				}
+			}
 
 			_, err := loadConfig()
 			if err != nil {
@@ -77,11 +118,17 @@ func init() {
 	configure.Flags().StringVar(&broker, "broker", "", "The broker to connect to. Should be of the form scheme://host:port where scheme is one of tcp, ssl, ws.")
 	configure.Flags().StringVar(&username, "username", "", "The username to use when connecting to the broker.")
 	configure.Flags().BoolVar(&setPassword, "password", false, "Indicates that you want to configure the password used to connect to the broker. You will be prompted to enter the password through stdin.")
+	configure.Flags().StringVar(&pwdSource.PasswordFile, "password_file", "", "Read the password from this file whenever connecting to the broker, instead of storing it in the config file.")
+	configure.Flags().StringVar(&pwdSource.PasswordCommand, "password_command", "", "Run this shell command (e.g. \"pass show mqtt\") to get the password whenever connecting to the broker, instead of storing it in the config file.")
+	configure.Flags().StringVar(&pwdSource.PasswordEnv, "password_env", "", "Read the password from this environment variable whenever connecting to the broker, instead of storing it in the config file.")
+	configure.Flags().StringVar(&pwdSource.PasswordCredential, "password_credential", "", "Read the password from this systemd credential (i.e. from $CREDENTIALS_DIRECTORY) whenever connecting to the broker, instead of storing it in the config file.")
 	configure.Flags().StringVar(&serverName, "server_name", "", "Overrides the broker's host name when doing ssl verification.")
+	configure.Flags().IntVar(&protocolVersion, "protocol_version", 0, "The version of MQTT to connect with: 4 for MQTT 3.1.1 (the default) or 5 for MQTT 5.")
 
 	viper.BindPFlag("broker", configure.Flags().Lookup("broker"))
 	viper.BindPFlag("username", configure.Flags().Lookup("username"))
 	viper.BindPFlag("server_name", configure.Flags().Lookup("server_name"))
+	viper.BindPFlag("protocol_version", configure.Flags().Lookup("protocol_version"))
 
 	rootCmd.AddCommand(configure)
 }
@@ -119,10 +166,54 @@ func loadConfig() (mqtt.Config, error) {
 		}
 		return mqtt.Config{}, fmt.Errorf("error reading config %s: %w", viper.ConfigFileUsed(), err)
 	}
+	if hasSecrets() {
+		if err := checkConfigPermissions(viper.ConfigFileUsed()); err != nil {
+			log.Warn().Err(err).Msg("Config file is accessible by other users, but it contains credentials")
+		}
+	}
 
 	var c mqtt.Config
 	if err := viper.Unmarshal(&c); err != nil {
 		return mqtt.Config{}, err
 	}
+
+	var l mqttcron.TopicLayout
+	if err := viper.UnmarshalKey("topics", &l, func(c *mapstructure.DecoderConfig) { c.ErrorUnused = true }); err != nil {
+		return mqtt.Config{}, fmt.Errorf("could not load topic config: %w", err)
+	}
+	if err := mqttcron.SetTopicLayout(l); err != nil {
+		return mqtt.Config{}, err
+	}
+
+	var tc cron.TabsConfig
+	if err := viper.UnmarshalKey("crontabs", &tc, func(c *mapstructure.DecoderConfig) { c.ErrorUnused = true }); err != nil {
+		return mqtt.Config{}, fmt.Errorf("could not load crontabs config: %w", err)
+	}
+	for _, f := range tc.Files {
+		// cron2mqtt exec runs in whatever directory the cron daemon chooses.
+		if !filepath.IsAbs(f.Path) {
+			return mqtt.Config{}, fmt.Errorf("crontab file %q must be an absolute path", f.Path)
+		}
+	}
+	if !tc.IsDefault() {
+		cron.SetTabsForUser(tc.TabsForUser)
+	}
 	return c, nil
 }
+
+// hasSecrets reports whether the config file itself contains credentials, as opposed to pointing at their sources.
+func hasSecrets() bool {
+	return viper.InConfig("password") || viper.InConfig("signing.key")
+}
+
+// checkConfigPermissions returns an error if anyone other than the owner can access the config file f.
+func checkConfigPermissions(f string) error {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return err
+	}
+	if p := fi.Mode().Perm(); p&0077 != 0 {
+		return fmt.Errorf("%s has permissions %#o, but it should only be accessible by its owner (i.e. chmod 600 %s)", f, p, f)
+	}
+	return nil
+}
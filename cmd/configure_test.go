@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckConfigPermissions(t *testing.T) {
+	for _, tc := range []struct {
+		perm    os.FileMode
+		wantErr bool
+	}{
+		{perm: 0600},
+		{perm: 0400},
+		{perm: 0640, wantErr: true},
+		{perm: 0644, wantErr: true},
+		{perm: 0606, wantErr: true},
+	} {
+		f := filepath.Join(t.TempDir(), "cron2mqtt.json")
+		if err := os.WriteFile(f, []byte("{}"), tc.perm); err != nil {
+			t.Fatalf("Could not write config file: %s", err)
+		}
+		// WriteFile is subject to umask.
+		if err := os.Chmod(f, tc.perm); err != nil {
+			t.Fatalf("Could not chmod config file: %s", err)
+		}
+		if err := checkConfigPermissions(f); (err != nil) != tc.wantErr {
+			t.Errorf("checkConfigPermissions(%#o) = %v, want error: %t", tc.perm, err, tc.wantErr)
+		}
+	}
+}
+
+func TestHasSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		conf string
+		want bool
+	}{
+		{conf: `{"broker": "tcp://localhost:1883", "username": "user"}`},
+		{conf: `{"password": "secret"}`, want: true},
+		{conf: `{"password_file": "/run/secrets/mqtt"}`},
+		{conf: `{"signing": {"algorithm": "ed25519", "key": "c2VjcmV0"}}`, want: true},
+		{conf: `{"signing": {"algorithm": "ed25519", "key_file": "/run/secrets/signing"}}`},
+	} {
+		f := filepath.Join(t.TempDir(), "cron2mqtt.json")
+		if err := os.WriteFile(f, []byte(tc.conf), 0600); err != nil {
+			t.Fatalf("Could not write config file: %s", err)
+		}
+		viper.SetConfigFile(f)
+		if err := viper.ReadInConfig(); err != nil {
+			t.Fatalf("Could not read config %s: %s", tc.conf, err)
+		}
+		if got := hasSecrets(); got != tc.want {
+			t.Errorf("hasSecrets() with %s = %t, want %t", tc.conf, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"os/user"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+func init() {
+	var checkInterval, grace, republishJitter time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Stays connected to MQTT and responds to requests about your cron jobs.",
+		Long:  "The daemon lets you enable and disable your cron jobs remotely (e.g. through a Home Assistant switch). Cron jobs are disabled by commenting them out in their crontab. The daemon also periodically checks whether your cron jobs missed any of their scheduled executions, and republishes them whenever Home Assistant restarts.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if checkInterval <= 0 {
+				return fmt.Errorf("--check_interval must be positive, not %s", checkInterval)
+			}
+			ctx, canc := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer canc()
+
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("could not determine current user: %w", err)
+			}
+
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			// Fail fast instead of failing every time a cron job is updated.
+			fs, err := pluginFactories()
+			if err != nil {
+				return err
+			}
+
+			// Keep this host's availability up to date for as long as the daemon is running.
+			opts, err := mqttcron.AvailabilityClientOptions()
+			if err != nil {
+				return err
+			}
+			cl, err := mqtt.NewClient(c, opts...)
+			if err != nil {
+				return fmt.Errorf("could not initialize MQTT: %w", err)
+			}
+			defer cl.Close(250)
+			for _, p := range mqttcron.NewPlugins(fs) {
+				cp, ok := p.(mqttcron.ConnectionPlugin)
+				if !ok {
+					continue
+				}
+				opts, err := cp.ConnectionClientOptions()
+				if err != nil {
+					return err
+				}
+				// The client only needs to stay connected.
+				pcl, err := mqtt.NewClient(c, opts...)
+				if err != nil {
+					return fmt.Errorf("could not initialize MQTT for %T: %w", p, err)
+				}
+				defer pcl.Close(250)
+			}
+
+			reqs := make(chan mqttcron.EnableRequest)
+			if err := mqttcron.SubscribeEnableRequests(ctx, cl, reqs); err != nil {
+				return err
+			}
+			republishReqs := make(chan struct{})
+			if err := mqttcron.SubscribeRepublishRequests(ctx, cl, mqttcron.NewPlugins(fs), republishReqs); err != nil {
+				return err
+			}
+			// Republishing is delayed by a random amount so that every host doesn't flood the broker at the same time. Any requests received in the meantime are coalesced.
+			var republish <-chan time.Time
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+			check := func() {
+				if err := checkMissedRuns(ctx, cl, u, grace); err != nil {
+					log.Error().Err(err).Msg("Could not check for missed runs")
+				}
+			}
+			check()
+			t := time.NewTicker(checkInterval)
+			defer t.Stop()
+			for {
+				select {
+				case req, ok := <-reqs:
+					if !ok {
+						return nil
+					}
+					if err := setEnabled(ctx, cl, u, req); err != nil {
+						log.Error().Err(err).Str("id", req.ID).Bool("enable", req.Enable).Msg("Could not handle enable request")
+					}
+				case _, ok := <-republishReqs:
+					if !ok {
+						republishReqs = nil
+						continue
+					}
+					if republish == nil {
+						var d time.Duration
+						if republishJitter > 0 {
+							d = time.Duration(rnd.Int63n(int64(republishJitter)))
+						}
+						republish = time.After(d)
+					}
+				case <-republish:
+					republish = nil
+					if err := republishLocalCronJobs(ctx, cl, u); err != nil {
+						log.Error().Err(err).Msg("Could not republish cron jobs")
+					}
+				case <-t.C:
+					check()
+				}
+			}
+		},
+	}
+	cmd.Flags().DurationVar(&checkInterval, "check_interval", time.Minute, "How often to check whether cron jobs missed their scheduled executions.")
+	cmd.Flags().DurationVar(&grace, "grace_period", defaultGracePeriod, "How late a cron job is allowed to be before it's considered missed.")
+	cmd.Flags().DurationVar(&republishJitter, "republish_jitter", 10*time.Second, "The maximum amount of time to wait before republishing cron jobs after Home Assistant restarts.")
+	rootCmd.AddCommand(cmd)
+}
+
+func setEnabled(ctx context.Context, cl *mqtt.Client, u *user.User, req mqttcron.EnableRequest) error {
+	defer logutil.StartTimerLogger(log.With().Str("id", req.ID).Bool("enable", req.Enable).Logger(), zerolog.InfoLevel, "Updating cron job").Stop()
+	j, err := mqttcron.SetLocalCronJobEnabled(cron.TabsForUser(u), u, req.ID, req.Enable)
+	if err != nil {
+		return err
+	}
+
+	// Re-create the cron job so that its plugins publish its new state.
+	ps, err := plugins()
+	if err != nil {
+		return err
+	}
+	opts, err := jobOptions(req.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := mqttcron.NewCronJob(req.ID, cl, append(opts, mqttcron.CronJobConfig(j), mqttcron.CronJobPlugins(ps...), mqttcron.CronJobContext(ctx), mqttcron.CronJobClearStaleTopics())...); err != nil {
+		return fmt.Errorf("could not publish cron job state: %w", err)
+	}
+	return nil
+}
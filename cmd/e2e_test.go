@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/harness"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+// startE2E starts a broker and points the config at it, along with any other config. The config and history are kept in temporary directories.
+func startE2E(t *testing.T, conf map[string]interface{}) *harness.Broker {
+	t.Helper()
+	b := harness.StartBroker(t)
+
+	c := map[string]interface{}{"broker": b.URL()}
+	for k, v := range conf {
+		c[k] = v
+	}
+	j, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Could not marshal config: %s", err)
+	}
+	f := filepath.Join(t.TempDir(), "cron2mqtt.json")
+	if err := os.WriteFile(f, j, 0600); err != nil {
+		t.Fatalf("Could not write config file: %s", err)
+	}
+	viper.SetConfigFile(f)
+	// The config may change the crontabs.
+	t.Cleanup(func() { cron.SetTabsForUser(nil) })
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("SHELL", "/bin/sh")
+	exe = "cron2mqtt"
+	return b
+}
+
+// userTab creates a crontab for the current user.
+func userTab(t *testing.T, content string) *cron.MemTab {
+	t.Helper()
+	u, err := user.Current()
+	if err != nil {
+		t.Fatalf("Could not determine current user: %s", err)
+	}
+	return cron.NewMemTab(u.Username, u, content)
+}
+
+// execute runs cron2mqtt with args, answering its prompts with input.
+func execute(t *testing.T, input string, args ...string) {
+	t.Helper()
+	executeWithStdin(t, strings.NewReader(input), args...)
+}
+
+func executeWithStdin(t *testing.T, r io.Reader, args ...string) {
+	t.Helper()
+	stdin = bufio.NewReader(r)
+	t.Cleanup(func() { stdin = bufio.NewReader(os.Stdin) })
+
+	rootCmd.SetArgs(args)
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatalf("cron2mqtt %s failed: %s", strings.Join(args, " "), err)
+	}
+}
+
+// retainedFor returns the retained topics of the cron job id.
+func retainedFor(t *testing.T, b *harness.Broker, id string) []string {
+	t.Helper()
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice failed: %s", err)
+	}
+	var ts []string
+	for topic := range b.Retained() {
+		if strings.HasPrefix(topic, d.TopicPrefix()+"/"+id+"/") {
+			ts = append(ts, topic)
+		}
+	}
+	return ts
+}
+
+func TestAttachE2E(t *testing.T) {
+	b := startE2E(t, nil)
+	tab := userTab(t, "0 * * * * echo hello\n")
+	harness.UseTabs(t, tab)
+
+	execute(t, "y\nmyjob\nMy job\n\n", "attach")
+
+	if got, want := tab.Content(), "cron2mqtt exec myjob echo hello"; !strings.Contains(got, want) {
+		t.Errorf("Crontab after attach = %q, want it to contain %q", got, want)
+	}
+	if ts := retainedFor(t, b, "myjob"); len(ts) == 0 {
+		t.Errorf("attach didn't publish myjob: retained messages = %v", b.Retained())
+	}
+}
+
+func TestExecE2E(t *testing.T) {
+	b := startE2E(t, nil)
+	harness.UseTabs(t, userTab(t, "0 * * * * cron2mqtt exec myjob true\n"))
+
+	execute(t, "", "exec", "myjob", "true")
+
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice failed: %s", err)
+	}
+	if _, ok := b.Retained()[d.TopicPrefix()+"/myjob/last_success"]; !ok {
+		t.Errorf("exec didn't publish the last success of myjob: retained messages = %v", b.Retained())
+	}
+	if _, ok := b.Retained()[d.TopicPrefix()+"/myjob/started"]; !ok {
+		t.Errorf("exec didn't publish the start of myjob: retained messages = %v", b.Retained())
+	}
+}
+
+func TestExecInvalidIDE2E(t *testing.T) {
+	startE2E(t, nil)
+	state := os.Getenv("XDG_STATE_HOME")
+
+	rootCmd.SetArgs([]string{"exec", "../../escaped", "true"})
+	if err := rootCmd.ExecuteContext(context.Background()); err == nil {
+		t.Errorf("exec with an invalid ID succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(state, "escaped.jsonl")); err == nil {
+		t.Errorf("exec with an invalid ID wrote history outside of the history directory")
+	}
+}
+
+func TestExecWebhookE2E(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+	}))
+	defer s.Close()
+	startE2E(t, map[string]interface{}{
+		"transport": "http",
+		"http":      map[string]interface{}{"url": s.URL + "/ping/{{.ID}}"},
+	})
+	harness.UseTabs(t, userTab(t, "0 * * * * cron2mqtt exec myjob true\n"))
+
+	execute(t, "", "exec", "myjob", "true")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"/ping/myjob/start", "/ping/myjob"}, paths); diff != "" {
+		t.Errorf("Webhook pings mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckUncheckableScheduleE2E(t *testing.T) {
+	startE2E(t, nil)
+	tab := userTab(t, "0 * * * * cron2mqtt exec myjob true\n")
+	harness.UseTabs(t, tab)
+	execute(t, "", "exec", "myjob", "true")
+
+	// cron accepts 7 for Sunday, but the schedule parser doesn't, so the job can't be checked.
+	tab.SetContent("0 0 * * 7 cron2mqtt exec myjob true\n")
+	execute(t, "", "check")
+}
+
+func TestDaemonCheckIntervalE2E(t *testing.T) {
+	startE2E(t, nil)
+	daemon, _, err := rootCmd.Find([]string{"daemon"})
+	if err != nil {
+		t.Fatalf("Could not find daemon command: %s", err)
+	}
+	t.Cleanup(func() { daemon.Flags().Set("check_interval", time.Minute.String()) })
+
+	for _, interval := range []string{"0s", "-1m"} {
+		rootCmd.SetArgs([]string{"daemon", "--check_interval=" + interval})
+		if err := rootCmd.ExecuteContext(context.Background()); err == nil || !strings.Contains(err.Error(), "check_interval") {
+			t.Errorf("daemon --check_interval=%s = %v, want an error about the check interval", interval, err)
+		}
+	}
+}
+
+func TestPruneE2E(t *testing.T) {
+	b := startE2E(t, nil)
+	tab := userTab(t, "0 * * * * cron2mqtt exec kept true\n0 * * * * cron2mqtt exec removed true\n")
+	harness.UseTabs(t, tab)
+	execute(t, "", "exec", "kept", "true")
+	execute(t, "", "exec", "removed", "true")
+	if len(retainedFor(t, b, "removed")) == 0 {
+		t.Fatalf("exec didn't publish removed: retained messages = %v", b.Retained())
+	}
+
+	tab.SetContent("0 * * * * cron2mqtt exec kept true\n")
+	execute(t, "y\n", "prune")
+
+	if ts := retainedFor(t, b, "removed"); len(ts) > 0 {
+		t.Errorf("prune left retained messages for removed: %v", ts)
+	}
+	if ts := retainedFor(t, b, "kept"); len(ts) == 0 {
+		t.Errorf("prune deleted kept: retained messages = %v", b.Retained())
+	}
+}
+
+func TestAttachCrontabFileE2E(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "crontab")
+	if err := os.WriteFile(f, []byte("0 * * * * echo hello\n"), 0600); err != nil {
+		t.Fatalf("Could not write crontab: %s", err)
+	}
+	b := startE2E(t, map[string]interface{}{
+		"crontabs": map[string]interface{}{
+			"files":             []map[string]interface{}{{"path": f}},
+			"skip_user_crontab": true,
+		},
+	})
+
+	execute(t, "y\nmyjob\n\n\n", "attach")
+
+	got, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatalf("Could not read crontab: %s", err)
+	}
+	if want := "cron2mqtt exec myjob echo hello"; !strings.Contains(string(got), want) {
+		t.Errorf("Crontab after attach = %q, want it to contain %q", got, want)
+	}
+	if ts := retainedFor(t, b, "myjob"); len(ts) == 0 {
+		t.Errorf("attach didn't publish myjob: retained messages = %v", b.Retained())
+	}
+}
+
+// editingReader edits a crontab when the user starts answering prompts.
+type editingReader struct {
+	io.Reader
+	edit func()
+}
+
+func (r *editingReader) Read(p []byte) (int, error) {
+	if r.edit != nil {
+		r.edit()
+		r.edit = nil
+	}
+	return r.Reader.Read(p)
+}
+
+func TestAttachConflictE2E(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		edit string
+
+		want string
+	}{
+		{
+			name: "other job added",
+			edit: "0 * * * * echo other\n0 * * * * echo hello\n",
+			want: "0 * * * * echo other\n0 * * * * cron2mqtt exec myjob echo hello\n",
+		},
+		{
+			name: "attached job changed",
+			edit: "0 0 * * * echo hello\n",
+			want: "0 0 * * * echo hello\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			startE2E(t, nil)
+			tab := userTab(t, "0 * * * * echo hello\n")
+			harness.UseTabs(t, tab)
+
+			executeWithStdin(t, &editingReader{strings.NewReader("y\nmyjob\n\n\n"), func() { tab.SetContent(tc.edit) }}, "attach")
+
+			if diff := cmp.Diff(tc.want, tab.Content()); diff != "" {
+				t.Errorf("Crontab after attach mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUndoE2E(t *testing.T) {
+	startE2E(t, nil)
+	const orig = "0 * * * * echo hello\n"
+	tab := userTab(t, orig)
+	harness.UseTabs(t, tab)
+
+	execute(t, "y\nmyjob\n\n\n", "attach")
+	if tab.Content() == orig {
+		t.Fatalf("attach didn't update the crontab")
+	}
+
+	execute(t, "", "undo")
+	if diff := cmp.Diff(orig, tab.Content()); diff != "" {
+		t.Errorf("Crontab after undo mismatch (-want +got):\n%s", diff)
+	}
+}
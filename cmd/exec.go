@@ -6,27 +6,64 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/history"
 	"github.com/JeffreyFalgout/cron2mqtt/logutil"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
-	"github.com/JeffreyFalgout/cron2mqtt/mqtt/hass"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+	"github.com/JeffreyFalgout/cron2mqtt/webhook"
 )
 
 func init() {
-	rootCmd.AddCommand(&cobra.Command{
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
 		Use:   "exec",
 		Short: "Executes a command, and publishes its results to MQTT.",
 		Args:  cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx, canc := signal.NotifyContext(cmd.Context(), os.Interrupt)
 			defer canc()
+			if timeout > 0 {
+				ctx, canc = context.WithTimeout(ctx, timeout)
+				defer canc()
+			}
 			id := args[0]
 			args = args[1:]
+			// The ID names files in the state directory, so it's checked before anything is recorded.
+			if err := mqttcron.ValidateID(id); err != nil {
+				return fmt.Errorf("invalid cron job ID: %w", err)
+			}
+
+			// The config is loaded up front so that webhooks can be pinged when the command starts, but errors aren't reported until the command is done, so that they don't prevent it from running.
+			c, confErr := loadConfig()
+			start := time.Now()
+			var hook *webhook.Client
+			var hookStarted <-chan struct{}
+			var conn <-chan mqttConn
+			if confErr == nil {
+				switch t := viper.GetString("transport"); t {
+				case "", mqttTransport:
+					conn = connect(cmd.Context(), id, c, start)
+				case httpTransport:
+					if hook, confErr = webhookClient(id); confErr == nil {
+						hookStarted = startHook(cmd.Context(), hook)
+					}
+				default:
+					confErr = fmt.Errorf("unknown transport %q (must be %s or %s)", t, mqttTransport, httpTransport)
+				}
+			}
+
+			if err := markStart(id, start); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not record start time: %s\n", err)
+			}
 			res := run(ctx, args)
 
 			if len(res.Stderr) == 0 && res.Err != nil {
@@ -34,9 +71,17 @@ func init() {
 				res.Stderr = []byte(res.Err.Error())
 			}
 
-			if c, err := loadConfig(); err != nil {
-				fmt.Fprintln(os.Stderr, err)
-			} else if err := publish(id, c, res); err != nil {
+			if err := appendHistory(id, res); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not record history: %s\n", err)
+			}
+
+			if confErr != nil {
+				fmt.Fprintln(os.Stderr, confErr)
+			} else if hook != nil {
+				if err := ping(cmd.Context(), id, hook, hookStarted, res); err != nil {
+					fmt.Fprintf(os.Stderr, "Could not ping webhook: %s\n", err)
+				}
+			} else if err := publish(cmd.Context(), id, conn, res); err != nil {
 				fmt.Fprintf(os.Stderr, "Could not publish to MQTT: %s\n", err)
 			}
 
@@ -45,7 +90,9 @@ func init() {
 			}
 			return nil
 		},
-	})
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Kill the command if it runs for longer than this. Use --timeout=<duration> so that it isn't confused with the command.")
+	rootCmd.AddCommand(cmd)
 }
 
 func run(ctx context.Context, args []string) exec.Result {
@@ -54,16 +101,124 @@ func run(ctx context.Context, args []string) exec.Result {
 	return exec.Run(ctx, sh, "-c", strings.Join(args, " "))
 }
 
-func publish(id string, conf mqtt.Config, res exec.Result) error {
-	defer logutil.StartTimer(zerolog.InfoLevel, "Publishing to MQTT").Stop()
-	c, err := mqtt.NewClient(conf)
+// markStart records when the command started, so that check doesn't think that long-running commands missed their execution.
+func markStart(id string, t time.Time) error {
+	s, err := historyStore()
 	if err != nil {
-		return fmt.Errorf("could not initialize MQTT: %w", err)
+		return err
 	}
+	return s.MarkStart(id, t)
+}
+
+func appendHistory(id string, res exec.Result) error {
+	s, err := historyStore()
+	if err != nil {
+		return err
+	}
+	return s.Append(id, res)
+}
+
+// mqttConn is the outcome of connecting to MQTT with connect.
+type mqttConn struct {
+	c   *mqtt.Client
+	err error
+}
+
+// connect connects to MQTT and publishes that the cron job started in the background, so that the command doesn't have to wait for the broker.
+func connect(ctx context.Context, id string, conf mqtt.Config, start time.Time) <-chan mqttConn {
+	ch := make(chan mqttConn, 1)
+	// Errors in the config are reported when the result is published.
+	opts, optsErr := jobOptions(id)
+	go func() {
+		defer logutil.StartTimer(zerolog.InfoLevel, "Publishing start to MQTT").Stop()
+		c, err := mqtt.NewClient(conf)
+		if err != nil {
+			ch <- mqttConn{err: fmt.Errorf("could not initialize MQTT: %w", err)}
+			return
+		}
+		defer func() { ch <- mqttConn{c: c} }()
+		if optsErr != nil {
+			return
+		}
+
+		cj, err := mqttcron.ExistingCronJob(id, c, append(opts, mqttcron.CronJobCommand(os.Args), mqttcron.CronJobContext(ctx))...)
+		if err == nil {
+			err = cj.PublishStart(start)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not publish start to MQTT: %s\n", err)
+		}
+	}()
+	return ch
+}
+
+func publish(ctx context.Context, id string, conn <-chan mqttConn, res exec.Result) error {
+	defer logutil.StartTimer(zerolog.InfoLevel, "Publishing to MQTT").Stop()
+	mc := <-conn
+	if mc.err != nil {
+		return mc.err
+	}
+	c := mc.c
 	defer c.Close(250)
 
-	// TODO: Make the plugins configurable.
-	cj, err := mqttcron.NewCronJob(id, c, mqttcron.CronJobCommand(os.Args), mqttcron.CronJobPlugins(hass.NewPlugin()))
+	ps, err := plugins()
+	if err != nil {
+		return err
+	}
+	expiry, err := expiryConfig("availability_expiry")
+	if err != nil {
+		return err
+	}
+	if err := mqttcron.PublishOnline(ctx, c, expiry); err != nil {
+		return fmt.Errorf("could not publish availability: %w", err)
+	}
+	return publishResult(ctx, id, c, res, ps...)
+}
+
+// startHook pings the webhook that the cron job started in the background, so that the command doesn't have to wait for it. The returned channel is closed once the ping is done.
+func startHook(ctx context.Context, hook *webhook.Client) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer logutil.StartTimer(zerolog.InfoLevel, "Pinging webhook start").Stop()
+		if err := hook.Start(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not ping webhook: %s\n", err)
+		}
+	}()
+	return done
+}
+
+// ping publishes the result to a webhook once the start ping is done, so that they arrive in order. Plugins are skipped, since webhooks only receive the results themselves.
+func ping(ctx context.Context, id string, hook *webhook.Client, started <-chan struct{}, res exec.Result) error {
+	<-started
+	defer logutil.StartTimer(zerolog.InfoLevel, "Pinging webhook").Stop()
+	return publishResult(ctx, id, hook, res)
+}
+
+func publishResult(ctx context.Context, id string, c mqttcron.Client, res exec.Result, ps ...mqttcron.Plugin) error {
+	h, err := historyStore()
+	if err != nil {
+		return err
+	}
+	anomaly := history.DefaultAnomalyThreshold
+	if err := viper.UnmarshalKey("anomaly", &anomaly); err != nil {
+		return fmt.Errorf("could not load anomaly detection config: %w", err)
+	}
+	expiry, err := expiryConfig("results_expiry")
+	if err != nil {
+		return err
+	}
+	opts, err := jobOptions(id)
+	if err != nil {
+		return err
+	}
+	cj, err := mqttcron.NewCronJob(id, c, append(opts,
+		mqttcron.CronJobCommand(os.Args),
+		mqttcron.CronJobHistory(h),
+		mqttcron.CronJobAnomalyThreshold(anomaly),
+		mqttcron.CronJobResultsExpiry(expiry),
+		mqttcron.CronJobPlugins(ps...),
+		mqttcron.CronJobContext(ctx))...)
 	if err != nil {
 		return fmt.Errorf("could not create mqttcron.CronJob: %w", err)
 	}
@@ -71,6 +226,33 @@ func publish(id string, conf mqtt.Config, res exec.Result) error {
 	if err := cj.PublishResult(res); err != nil {
 		return fmt.Errorf("could not publish result to mqttcron.CronJob: %w", err)
 	}
-
 	return nil
 }
+
+// expiryConfig parses the duration at key in the config, which is zero if it isn't set.
+func expiryConfig(key string) (time.Duration, error) {
+	s := viper.GetString(key)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+const (
+	// The possible values of "transport" in the config, which determines how exec publishes results.
+	mqttTransport = "mqtt"
+	httpTransport = "http"
+)
+
+// webhookClient creates a client for the cron job's webhook from the "http" section of the config.
+func webhookClient(id string) (*webhook.Client, error) {
+	var wc webhook.Config
+	if err := viper.UnmarshalKey("http", &wc, func(c *mapstructure.DecoderConfig) { c.ErrorUnused = true }); err != nil {
+		return nil, fmt.Errorf("could not load webhook config: %w", err)
+	}
+	return webhook.NewClient(wc, id)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+
+	"github.com/JeffreyFalgout/cron2mqtt/history"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+func init() {
+	var failuresOnly bool
+	var since, until string
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "history <id>",
+		Short: "Shows past executions of a cron job on this host.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+			if err := mqttcron.ValidateTopicComponent(id); err != nil {
+				return fmt.Errorf("provided cron job ID is invalid: %w", err)
+			}
+
+			now := time.Now()
+			f := history.Filter{FailuresOnly: failuresOnly}
+			var err error
+			if f.Since, err = parseTime(since, now); err != nil {
+				return fmt.Errorf("invalid --since: %w", err)
+			}
+			if f.Until, err = parseTime(until, now); err != nil {
+				return fmt.Errorf("invalid --until: %w", err)
+			}
+
+			s, err := historyStore()
+			if err != nil {
+				return err
+			}
+			es, err := s.Entries(id, f)
+			if err != nil {
+				return err
+			}
+			if len(es) == 0 {
+				fmt.Printf("No history for %s.\n", id)
+				return nil
+			}
+			if limit > 0 && len(es) > limit {
+				es = es[len(es)-limit:]
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "START\tEXIT CODE\tDURATION\tOUTPUT")
+			for _, e := range es {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", e.Start.Local().Format(time.RFC3339), e.ExitCode, e.Duration().Round(time.Millisecond), summarizeOutput(e))
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&failuresOnly, "failures", false, "Only show executions that failed.")
+	cmd.Flags().StringVar(&since, "since", "", "Only show executions that started after this time. Either an RFC3339 timestamp or a duration (e.g. 24h) before now.")
+	cmd.Flags().StringVar(&until, "until", "", "Only show executions that started before this time. Either an RFC3339 timestamp or a duration (e.g. 24h) before now.")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "The maximum number of executions to show. Use 0 to show everything.")
+	rootCmd.AddCommand(cmd)
+}
+
+func historyStore() (*history.Store, error) {
+	d, err := history.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return history.NewStore(d), nil
+}
+
+// parseTime parses s as either an RFC3339 timestamp, or a duration before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// summarizeOutput returns a truncated version of the first line of output from e.
+func summarizeOutput(e history.Entry) string {
+	out := e.Stdout
+	if !e.Succeeded() && strings.TrimSpace(e.Stderr) != "" {
+		out = e.Stderr
+	} else if strings.TrimSpace(out) == "" {
+		out = e.Stderr
+	}
+	out = strings.TrimSpace(out)
+
+	const max = 60
+	truncated := e.Truncated
+	if i := strings.IndexByte(out, '\n'); i >= 0 {
+		out = out[:i]
+		truncated = true
+	}
+	if utf8.RuneCountInString(out) > max {
+		out = string([]rune(out)[:max])
+		truncated = true
+	}
+	if truncated {
+		out += "…"
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+	"github.com/JeffreyFalgout/cron2mqtt/signing"
+)
+
+// jobConfig is the user's configuration for a single cron job in the "jobs" section of the config.
+type jobConfig struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+}
+
+// jobOptions returns the options that are configured for the cron job, i.e. its extractors, its name, and how it should be named.
+// The config must already be loaded (see loadConfig).
+func jobOptions(id string) ([]mqttcron.CronJobOption, error) {
+	strict := func(c *mapstructure.DecoderConfig) { c.ErrorUnused = true }
+	// viper's keys are case insensitive.
+	key := strings.ToLower(id)
+
+	var es map[string][]mqttcron.Extractor
+	if err := viper.UnmarshalKey("extract", &es, strict); err != nil {
+		return nil, fmt.Errorf("could not load extractor config: %w", err)
+	}
+	var jcs map[string]jobConfig
+	if err := viper.UnmarshalKey("jobs", &jcs, strict); err != nil {
+		return nil, fmt.Errorf("could not load job config: %w", err)
+	}
+	opts := []mqttcron.CronJobOption{
+		mqttcron.CronJobExtractors(es[key]...),
+		mqttcron.CronJobName(jcs[key].Name, jcs[key].Description),
+	}
+
+	if s := viper.GetString("name_template"); s != "" {
+		t, err := mqttcron.ParseNameTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, mqttcron.CronJobNameTemplate(t))
+	}
+
+	sc, err := signingConfig()
+	if err != nil {
+		return nil, err
+	}
+	if sc.Enabled() {
+		s, err := sc.Signer()
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing config: %w", err)
+		}
+		opts = append(opts, mqttcron.CronJobSigner(s))
+	}
+	return opts, nil
+}
+
+// signingConfig returns the "signing" section of the config, which configures the key that this device signs its payloads with.
+func signingConfig() (signing.KeyConfig, error) {
+	var sc signing.KeyConfig
+	if err := viper.UnmarshalKey("signing", &sc, func(c *mapstructure.DecoderConfig) { c.ErrorUnused = true }); err != nil {
+		return sc, fmt.Errorf("could not load signing config: %w", err)
+	}
+	return sc, nil
+}
+
+// saveJobConfig writes the cron job's configuration to the "jobs" section of the config file.
+// The config must already be loaded (see loadConfig).
+func saveJobConfig(id string, jc jobConfig) error {
+	viper.Set("jobs."+strings.ToLower(id), map[string]interface{}{
+		"name":        jc.Name,
+		"description": jc.Description,
+	})
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
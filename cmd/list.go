@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists the cron jobs on this host that are being monitored.",
+		Long:  "Cron jobs are named the same way that they're named in Home Assistant. Names and descriptions are configured in the \"jobs\" section of the config file, and the format of names can be changed with \"name_template\".",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("could not determine current user: %w", err)
+			}
+
+			// Listing doesn't need to connect to MQTT, so it's fine if cron2mqtt hasn't been configured yet.
+			if _, err := loadConfig(); err != nil {
+				if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+					return err
+				}
+			}
+
+			js, err := mqttcron.DiscoverLocalCronJobs(cron.TabsForUser(u), u)
+			if err != nil {
+				return err
+			}
+			if len(js) == 0 {
+				fmt.Println("No cron jobs are being monitored. Use attach to start monitoring them.")
+				return nil
+			}
+			var ids []string
+			for id := range js {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tENABLED\tSCHEDULE\tNAME\tDESCRIPTION")
+			for _, id := range ids {
+				j := js[id]
+				opts, err := jobOptions(id)
+				if err != nil {
+					return err
+				}
+				// Nothing is published, so the cron job doesn't need a client.
+				cj, err := mqttcron.ExistingCronJob(id, nil, append(opts, mqttcron.CronJobConfig(j))...)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\n", id, j.Enabled(), j.Schedule, cj.Name(), cj.Description())
+			}
+			return w.Flush()
+		},
+	}
+	rootCmd.AddCommand(cmd)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os/user"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+func init() {
+	var from mqttcron.TopicLayout
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Moves this device's retained MQTT messages to the topics in the \"topics\" section of the config file.",
+		Long:  "By default, messages are moved from the default topics. Afterwards, all local cron jobs are republished (see sync) so that their configuration refers to the new topics.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("could not determine current user: %w", err)
+			}
+
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			src, err := mqttcron.DeviceWithLayout(from)
+			if err != nil {
+				return fmt.Errorf("invalid source topics: %w", err)
+			}
+			dst, err := mqttcron.CurrentDevice()
+			if err != nil {
+				return err
+			}
+			if src.TopicPrefix() == dst.TopicPrefix() {
+				fmt.Printf("Already using %s, nothing to migrate.\n", dst.TopicPrefix())
+				return nil
+			}
+
+			cl, err := mqtt.NewClient(c)
+			if err != nil {
+				return fmt.Errorf("could not initialize MQTT: %w", err)
+			}
+			defer cl.Close(250)
+
+			n, err := mqttcron.MigrateRetained(cmd.Context(), cl, src, dst, timeout)
+			fmt.Printf("Moved %d retained messages from %s to %s.\n", n, src.TopicPrefix(), dst.TopicPrefix())
+			if err != nil {
+				return err
+			}
+
+			return republishLocalCronJobs(cmd.Context(), cl, u)
+		},
+	}
+	cmd.Flags().StringVar(&from.Root, "from_root", mqttcron.DefaultTopicRoot, "The topic root to move messages from.")
+	cmd.Flags().StringVar(&from.Device, "from_device", mqttcron.DefaultDeviceTopic, "The device topic template to move messages from.")
+	cmd.Flags().DurationVarP(&timeout, "timeout", "t", 500*time.Millisecond, "The amount of time to spend discovering retained messages.")
+	rootCmd.AddCommand(cmd)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	// Registers the "hass" and "homie" plugins.
+	_ "github.com/JeffreyFalgout/cron2mqtt/mqtt/hass"
+	_ "github.com/JeffreyFalgout/cron2mqtt/mqtt/homie"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+// pluginFactories returns a function for each plugin that's enabled in the "plugins" section of the config.
+// The config must already be loaded (see loadConfig).
+func pluginFactories() ([]func() mqttcron.Plugin, error) {
+	var c mqttcron.PluginsConfig
+	if err := viper.UnmarshalKey("plugins", &c); err != nil {
+		return nil, fmt.Errorf("could not load plugin config: %w", err)
+	}
+	fs, err := mqttcron.PluginFactories(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin config: %w", err)
+	}
+	return fs, nil
+}
+
+// plugins creates new instances of each plugin that's enabled in the config for a single mqttcron.CronJob.
+func plugins() ([]mqttcron.Plugin, error) {
+	fs, err := pluginFactories()
+	if err != nil {
+		return nil, err
+	}
+	return mqttcron.NewPlugins(fs), nil
+}
@@ -16,7 +16,6 @@ import (
 	"github.com/JeffreyFalgout/cron2mqtt/cron"
 	"github.com/JeffreyFalgout/cron2mqtt/logutil"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
-	"github.com/JeffreyFalgout/cron2mqtt/mqtt/hass"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
 )
 
@@ -100,10 +99,7 @@ func init() {
 				}
 
 				fmt.Println()
-				fmt.Printf("Would you like to delete %s? [yN] ", cj.ID())
-				var sel string
-				fmt.Scanln(&sel)
-				if strings.ToLower(sel) != "y" {
+				if sel := prompt(fmt.Sprintf("Would you like to delete %s? [yN] ", cj.ID())); strings.ToLower(sel) != "y" {
 					continue
 				}
 
@@ -122,5 +118,9 @@ func init() {
 
 func discoverRemoteCronJobs(ctx context.Context, cl *mqtt.Client) ([]mqttcron.DiscoveredCronJob, error) {
 	defer logutil.StartTimer(zerolog.InfoLevel, "Discovering remote cron jobs").Stop()
-	return mqttcron.DiscoverRemoteCronJobs(ctx, cl, hass.NewPlugin)
+	fs, err := pluginFactories()
+	if err != nil {
+		return nil, err
+	}
+	return mqttcron.DiscoverRemoteCronJobs(ctx, cl, fs...)
 }
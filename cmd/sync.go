@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"sort"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Republishes the configuration of all local cron jobs to MQTT.",
+		Long:  "Cron jobs normally publish their configuration whenever they run. This is useful if your MQTT broker or Home Assistant lost track of them in the meantime. The daemon does this automatically whenever Home Assistant restarts.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("could not determine current user: %w", err)
+			}
+
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			cl, err := mqtt.NewClient(c)
+			if err != nil {
+				return fmt.Errorf("could not initialize MQTT: %w", err)
+			}
+			defer cl.Close(250)
+
+			return republishLocalCronJobs(cmd.Context(), cl, u)
+		},
+	}
+	rootCmd.AddCommand(cmd)
+}
+
+// republishLocalCronJobs re-creates each of the user's cron jobs so that their plugins republish their configuration.
+func republishLocalCronJobs(ctx context.Context, cl *mqtt.Client, u *user.User) error {
+	defer logutil.StartTimer(zerolog.InfoLevel, "Republishing cron jobs").Stop()
+	js, err := mqttcron.DiscoverLocalCronJobs(cron.TabsForUser(u), u)
+	if err != nil {
+		return err
+	}
+	fs, err := pluginFactories()
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for id := range js {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs error
+	for _, id := range ids {
+		opts, err := jobOptions(id)
+		if err != nil {
+			return err
+		}
+		if _, err := mqttcron.NewCronJob(id, cl, append(opts, mqttcron.CronJobConfig(js[id]), mqttcron.CronJobPlugins(mqttcron.NewPlugins(fs)...), mqttcron.CronJobContext(ctx), mqttcron.CronJobClearStaleTopics())...); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("could not republish %s: %w", id, err))
+		}
+	}
+	return errs
+}
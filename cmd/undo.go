@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Restores each crontab to its content from before cron2mqtt last changed it.",
+		Long:  "cron2mqtt backs up a crontab whenever it changes it (e.g. with attach, or when a cron job is enabled or disabled by the daemon). Running undo repeatedly restores older and older backups. Cron jobs that are no longer in any crontab can be removed from MQTT with prune.",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("could not determine current user: %w", err)
+			}
+
+			// Undoing doesn't need to connect to MQTT, so it's fine if cron2mqtt hasn't been configured yet, but the config might point to other crontabs.
+			if _, err := loadConfig(); err != nil {
+				if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+					return err
+				}
+			}
+
+			failed := 0
+			for _, ct := range cron.TabsForUser(u) {
+				taken, err := ct.Undo()
+				if errors.Is(err, cron.ErrNoBackup) {
+					fmt.Printf("Nothing to undo for %s\n", ct)
+					continue
+				} else if errors.Is(err, cron.ErrConflict) {
+					fmt.Fprintf(os.Stderr, "Not restoring %s, since it was changed after cron2mqtt last changed it\n", ct)
+					failed++
+					continue
+				} else if err != nil {
+					fmt.Fprintf(os.Stderr, "Could not restore %s: %s\n", ct, err)
+					failed++
+					continue
+				}
+				fmt.Printf("Restored %s to its content from %s\n", ct, taken.Local().Format(time.RFC3339))
+			}
+			if failed > 0 {
+				return fmt.Errorf("could not restore %d crontabs", failed)
+			}
+			return nil
+		},
+	}
+	rootCmd.AddCommand(cmd)
+}
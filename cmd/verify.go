@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+// verification is printed for every message that verify receives.
+type verification struct {
+	Topic string `json:"topic"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+	// Payload is the message without its signature. It's only set for valid messages.
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func init() {
+	var count int
+	var timeout time.Duration
+	var printPublicKey bool
+
+	cmd := &cobra.Command{
+		Use:   "verify topic...",
+		Short: "Subscribes to MQTT topics and checks the signatures of the messages that are published to them.",
+		Long:  "The key is configured in the \"signing\" section of the config file. Consumers of Ed25519 signatures only need the public key. Every message is printed as a line of JSON. verify exits unsuccessfully if any of the messages were unsigned or had invalid signatures.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if printPublicKey {
+				return cobra.ExactArgs(0)(cmd, args)
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			sc, err := signingConfig()
+			if err != nil {
+				return err
+			}
+			if !sc.Enabled() {
+				return errors.New("signing isn't configured")
+			}
+			if printPublicKey {
+				pub, err := sc.Ed25519PublicKey()
+				if err != nil {
+					return err
+				}
+				fmt.Println(base64.StdEncoding.EncodeToString(pub))
+				return nil
+			}
+			v, err := sc.Verifier()
+			if err != nil {
+				return fmt.Errorf("invalid signing config: %w", err)
+			}
+
+			ctx, canc := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer canc()
+			if timeout > 0 {
+				ctx, canc = context.WithTimeout(ctx, timeout)
+				defer canc()
+			}
+
+			cl, err := mqtt.NewClient(c)
+			if err != nil {
+				return fmt.Errorf("could not initialize MQTT: %w", err)
+			}
+			defer cl.Close(250)
+
+			msgs, err := subscribeAll(ctx, cl, args)
+			if err != nil {
+				return err
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			var n, invalid int
+			for m := range msgs {
+				res := verification{Topic: m.Topic(), Valid: true}
+				if p, err := v.Verify(m.Topic(), m.Payload()); err != nil {
+					res.Valid = false
+					res.Error = err.Error()
+					invalid++
+				} else {
+					res.Payload = p
+				}
+				if err := enc.Encode(res); err != nil {
+					return err
+				}
+
+				n++
+				if count > 0 && n >= count {
+					canc()
+					break
+				}
+			}
+			if invalid > 0 {
+				return fmt.Errorf("%d of %d messages could not be verified", invalid, n)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&count, "count", "n", 0, "Stop after this many messages. By default, verify runs until it's interrupted.")
+	cmd.Flags().DurationVarP(&timeout, "timeout", "t", 0, "Stop after this amount of time. By default, verify runs until it's interrupted.")
+	cmd.Flags().BoolVar(&printPublicKey, "print_public_key", false, "Print the Ed25519 public key that corresponds to the configured private key, so that it can be given to consumers.")
+	rootCmd.AddCommand(cmd)
+}
+
+// subscribeAll subscribes to all of the topics. The returned channel is closed once ctx is done.
+func subscribeAll(ctx context.Context, cl *mqtt.Client, topics []string) (<-chan mqtt.Message, error) {
+	// Subscriptions are cancelled if any of them fail.
+	ctx, canc := context.WithCancel(ctx)
+	out := make(chan mqtt.Message)
+	var wg sync.WaitGroup
+	for _, t := range topics {
+		ch := make(chan mqtt.Message)
+		if err := cl.Subscribe(ctx, t, mqtt.QoSExactlyOnce, ch); err != nil {
+			canc()
+			return nil, fmt.Errorf("could not subscribe to %s: %w", t, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range ch {
+				select {
+				case out <- m:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		canc()
+		close(out)
+	}()
+	return out, nil
+}
@@ -0,0 +1,267 @@
+package cron
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrConflict means that a crontab was changed by someone else between loading and updating it, or since the update that would be undone.
+	ErrConflict = errors.New("crontab was changed since it was loaded")
+	// ErrNoBackup means that there's nothing to undo.
+	ErrNoBackup = errors.New("crontab has no backups")
+)
+
+// MaxBackups is how many backups are kept for each crontab. Older backups are deleted.
+const MaxBackups = 20
+
+// backupTimeFormat sorts in chronological order.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// DefaultBackupDir is where crontabs are backed up before they're updated: $XDG_STATE_HOME/cron2mqtt/crontabs
+func DefaultBackupDir() (string, error) {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return filepath.Join(d, "cron2mqtt", "crontabs"), nil
+	}
+	h, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine state directory: %w", err)
+	}
+	return filepath.Join(h, ".local", "state", "cron2mqtt", "crontabs"), nil
+}
+
+func hash(crontab string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(crontab))
+}
+
+// backup is a previous content of a crontab.
+type backup struct {
+	content string
+	// written is the hash of what the update that replaced content wrote, so that undo can tell whether the crontab was changed since then.
+	written [sha256.Size]byte
+	// unverified means that written isn't known, e.g. because the backup was taken by an older version.
+	unverified bool
+	taken      time.Time
+	// path is where a dirBackups backup is stored.
+	path string
+}
+
+// backups keeps the previous contents of a crontab.
+type backups interface {
+	// push saves a backup of content, which is being replaced by content with the hash written.
+	push(content string, written [sha256.Size]byte) error
+	// last returns the most recent backup, or ErrNoBackup.
+	last() (backup, error)
+	// drop deletes a backup that was returned by last.
+	drop(backup) error
+}
+
+// update writes tc to a crontab with write, after checking that the crontab's current content (according to read) is the content tc was loaded from, and backing it up.
+func update(tc *TabConfig, read func() (string, error), write func(string) error, b backups) error {
+	cur, err := read()
+	if err != nil {
+		return err
+	}
+	if tc.loaded != nil && *tc.loaded != hash(cur) {
+		return ErrConflict
+	}
+	s := tc.String()
+	h := hash(s)
+	if err := b.push(cur, h); err != nil {
+		return fmt.Errorf("could not back up crontab: %w", err)
+	}
+	if err := write(s); err != nil {
+		// The backup's hash would never match the crontab, which would prevent older backups from being restored.
+		if bk, lErr := b.last(); lErr == nil {
+			if dErr := b.drop(bk); dErr != nil {
+				log.Warn().Err(dErr).Msg("Could not delete the backup of a crontab that couldn't be updated")
+			}
+		}
+		return err
+	}
+	// tc can be updated again, as long as nobody else changes the crontab in the meantime.
+	tc.loaded = &h
+	return nil
+}
+
+// undo restores a crontab's most recent backup with write, and deletes the backup. It fails with ErrConflict if the crontab's current content (according to read) isn't what the backed up update wrote, since restoring the backup would lose those changes.
+func undo(read func() (string, error), write func(string) error, bs backups) (time.Time, error) {
+	b, err := bs.last()
+	if err != nil {
+		return time.Time{}, err
+	}
+	cur, err := read()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if b.unverified {
+		return time.Time{}, fmt.Errorf("%w: the backup from %s doesn't record what replaced it, so it can't be restored safely", ErrConflict, b.taken.Local().Format(time.RFC3339))
+	}
+	if hash(cur) != b.written {
+		return time.Time{}, ErrConflict
+	}
+	if err := write(b.content); err != nil {
+		return time.Time{}, err
+	}
+	if err := bs.drop(b); err != nil {
+		return time.Time{}, fmt.Errorf("could not delete restored backup: %w", err)
+	}
+	return b.taken, nil
+}
+
+// dirBackups keeps backups as files in a directory. Each backup's written hash is kept next to it in a file with a .sha256 suffix.
+type dirBackups struct {
+	// key identifies the crontab within the backup directory.
+	key string
+}
+
+func (b dirBackups) dir() (string, error) {
+	d, err := DefaultBackupDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, b.key), nil
+}
+
+// list returns the paths of the backups, oldest first.
+func (b dirBackups) list() ([]string, error) {
+	d, err := b.dir()
+	if err != nil {
+		return nil, err
+	}
+	es, err := os.ReadDir(d)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ps []string
+	for _, e := range es {
+		if strings.HasSuffix(e.Name(), ".crontab") {
+			ps = append(ps, filepath.Join(d, e.Name()))
+		}
+	}
+	sort.Strings(ps)
+	return ps, nil
+}
+
+func (b dirBackups) push(content string, written [sha256.Size]byte) error {
+	d, err := b.dir()
+	if err != nil {
+		return err
+	}
+	// Crontabs might contain secrets.
+	if err := os.MkdirAll(d, 0700); err != nil {
+		return err
+	}
+	p := filepath.Join(d, time.Now().UTC().Format(backupTimeFormat)+".crontab")
+	// The hash is written first, so that a backup is never listed without it.
+	if err := os.WriteFile(p+".sha256", []byte(hex.EncodeToString(written[:])), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		return err
+	}
+
+	ps, err := b.list()
+	if err != nil {
+		return err
+	}
+	for len(ps) > MaxBackups {
+		if err := b.drop(backup{path: ps[0]}); err != nil {
+			return err
+		}
+		ps = ps[1:]
+	}
+	return nil
+}
+
+func (b dirBackups) last() (backup, error) {
+	ps, err := b.list()
+	if err != nil {
+		return backup{}, err
+	}
+	if len(ps) == 0 {
+		return backup{}, ErrNoBackup
+	}
+	p := ps[len(ps)-1]
+	taken, err := time.Parse(backupTimeFormat, strings.TrimSuffix(filepath.Base(p), ".crontab"))
+	if err != nil {
+		return backup{}, fmt.Errorf("invalid backup %s: %w", p, err)
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		return backup{}, err
+	}
+	bk := backup{content: string(content), taken: taken, path: p}
+	h, err := os.ReadFile(p + ".sha256")
+	if errors.Is(err, fs.ErrNotExist) {
+		// E.g. pushing the backup was interrupted.
+		bk.unverified = true
+		return bk, nil
+	} else if err != nil {
+		return backup{}, err
+	}
+	if n, err := hex.Decode(bk.written[:], h); err != nil || n != sha256.Size {
+		return backup{}, fmt.Errorf("invalid hash for backup %s", p)
+	}
+	return bk, nil
+}
+
+func (b dirBackups) drop(bk backup) error {
+	if err := os.Remove(bk.path); err != nil {
+		return err
+	}
+	if err := os.Remove(bk.path + ".sha256"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// memBackups keeps backups in memory.
+type memBackups struct {
+	mut sync.Mutex
+	bs  []backup
+}
+
+func (b *memBackups) push(content string, written [sha256.Size]byte) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.bs = append(b.bs, backup{content: content, written: written, taken: time.Now()})
+	if len(b.bs) > MaxBackups {
+		b.bs = b.bs[len(b.bs)-MaxBackups:]
+	}
+	return nil
+}
+
+func (b *memBackups) last() (backup, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if len(b.bs) == 0 {
+		return backup{}, ErrNoBackup
+	}
+	return b.bs[len(b.bs)-1], nil
+}
+
+func (b *memBackups) drop(bk backup) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for i := len(b.bs) - 1; i >= 0; i-- {
+		if b.bs[i] == bk {
+			b.bs = append(b.bs[:i], b.bs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
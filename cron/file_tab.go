@@ -0,0 +1,117 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+)
+
+type fileTab struct {
+	path string
+	u    *user.User
+}
+
+// FileTab provides a reference to a crontab file, e.g. a spool file that's read by a cron daemon that doesn't have a crontab command. Its jobs belong to u, or to the user in their sixth field if u is nil (i.e. a system crontab like /etc/crontab).
+// A file that doesn't exist is an empty crontab. Updates are written to a temporary file that's renamed over the crontab, so that the cron daemon never reads a partially written crontab.
+func FileTab(path string, u *user.User) Tab {
+	return &fileTab{path, u}
+}
+
+func (t *fileTab) Load() (*TabConfig, error) {
+	defer logutil.StartTimerLogger(log.With().Str("path", t.path).Logger(), zerolog.DebugLevel, "Loading crontab file").Stop()
+	s, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	return parseTabConfig(s, t.u)
+}
+
+func (t *fileTab) Update(tc *TabConfig) error {
+	defer logutil.StartTimerLogger(log.With().Str("path", t.path).Logger(), zerolog.DebugLevel, "Updating crontab file").Stop()
+	return update(tc, t.read, t.write, t.backups())
+}
+
+func (t *fileTab) Undo() (time.Time, error) {
+	defer logutil.StartTimerLogger(log.With().Str("path", t.path).Logger(), zerolog.DebugLevel, "Restoring crontab file").Stop()
+	return undo(t.read, t.write, t.backups())
+}
+
+func (t *fileTab) read() (string, error) {
+	b, err := os.ReadFile(t.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("could not load crontab %s: %w", t.path, err)
+	}
+	return string(b), nil
+}
+
+func (t *fileTab) write(s string) error {
+	if err := writeFileAtomic(t.path, []byte(s)); err != nil {
+		return fmt.Errorf("could not update crontab %s: %w", t.path, err)
+	}
+	return nil
+}
+
+func (t *fileTab) backups() backups {
+	// The path is escaped so that every file gets its own directory.
+	return dirBackups{filepath.Join("file", url.PathEscape(t.path))}
+}
+
+func (t *fileTab) String() string {
+	return fmt.Sprintf("crontab file %s", t.path)
+}
+
+// writeFileAtomic replaces the content of path by renaming a temporary file over it. The file's permissions and ownership are preserved. New files are only accessible by their owner, like the crontabs that the crontab command creates.
+func writeFileAtomic(path string, b []byte) (err error) {
+	perm := os.FileMode(0600)
+	uid, gid := -1, -1
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(st.Uid), int(st.Gid)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// The temporary file must be in the same directory, since renames can't cross file systems.
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if uid != -1 && (uid != os.Geteuid() || gid != os.Getegid()) {
+		if err := f.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
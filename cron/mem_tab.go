@@ -0,0 +1,83 @@
+package cron
+
+import (
+	"fmt"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// MemTab is a crontab that's only stored in memory, e.g. for tests, along with its backups. It's safe for concurrent use.
+type MemTab struct {
+	name string
+	u    *user.User
+
+	mut     sync.Mutex
+	content string
+	updates int
+	backups memBackups
+}
+
+var _ Tab = (*MemTab)(nil)
+
+// NewMemTab creates a crontab with the given content. Its jobs belong to u, or to the user in their sixth field if u is nil (i.e. a system crontab like /etc/crontab).
+func NewMemTab(name string, u *user.User, content string) *MemTab {
+	return &MemTab{name: name, u: u, content: content}
+}
+
+func (t *MemTab) Load() (*TabConfig, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return parseTabConfig(t.content, t.u)
+}
+
+func (t *MemTab) Update(tc *TabConfig) error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if err := update(tc, t.read, t.write, &t.backups); err != nil {
+		return err
+	}
+	t.updates++
+	return nil
+}
+
+func (t *MemTab) Undo() (time.Time, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return undo(t.read, t.write, &t.backups)
+}
+
+// read and write must be called with mut held.
+func (t *MemTab) read() (string, error) {
+	return t.content, nil
+}
+
+func (t *MemTab) write(s string) error {
+	t.content = s
+	return nil
+}
+
+// Content returns the current content of the crontab.
+func (t *MemTab) Content() string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.content
+}
+
+// SetContent replaces the content of the crontab, as if the user had edited it.
+func (t *MemTab) SetContent(content string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.content = content
+}
+
+// Updates returns the number of times that the crontab has been updated.
+func (t *MemTab) Updates() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.updates
+}
+
+func (t *MemTab) String() string {
+	return fmt.Sprintf("in-memory crontab %q", t.name)
+}
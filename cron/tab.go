@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -16,7 +18,10 @@ import (
 // Tab represents a crontab that exists somewhere.
 type Tab interface {
 	Load() (*TabConfig, error)
-	Update(*TabConfig) error
+	// Update replaces the crontab with tc, after backing up its previous content. It fails with ErrConflict if the crontab was changed since tc was loaded from it.
+	Update(tc *TabConfig) error
+	// Undo restores the content from before the most recent Update, and returns when that backup was taken. It fails with ErrNoBackup if there's nothing to undo, and with ErrConflict if the crontab was changed since that Update.
+	Undo() (time.Time, error)
 }
 
 type userTab struct {
@@ -28,35 +33,104 @@ func TabForUser(u *user.User) Tab {
 	return &userTab{u}
 }
 
-// TabsForUser provides references to all crontabs that might contain a job for the user.
+// TabsForUser provides references to all crontabs that might contain a job for the user. By default, that's just the user's crontab (see SetTabsForUser).
 func TabsForUser(u *user.User) []Tab {
+	return tabsForUser(u)
+}
+
+var tabsForUser = defaultTabsForUser
+
+func defaultTabsForUser(u *user.User) []Tab {
 	// TODO: Check for more crontabs than just the user's.
 	return []Tab{TabForUser(u)}
 }
 
+// SetTabsForUser changes the crontabs that TabsForUser provides. A nil f restores the default.
+func SetTabsForUser(f func(u *user.User) []Tab) {
+	if f == nil {
+		f = defaultTabsForUser
+	}
+	tabsForUser = f
+}
+
+// TabsConfig configures which crontabs TabsForUser provides, for cron daemons that don't keep crontabs where the crontab command does (e.g. busybox crond, or containers without a crontab command).
+type TabsConfig struct {
+	// Files are crontab files (see FileTab).
+	Files []FileConfig `mapstructure:"files,omitempty"`
+	// SkipUserCrontab excludes the crontab that's managed by the crontab command.
+	SkipUserCrontab bool `mapstructure:"skip_user_crontab,omitempty"`
+}
+
+// FileConfig configures a crontab file.
+type FileConfig struct {
+	Path string `mapstructure:"path"`
+	// System indicates that the crontab's jobs specify their user in their sixth field, like /etc/crontab. Otherwise, they belong to the user that cron2mqtt runs as.
+	System bool `mapstructure:"system,omitempty"`
+}
+
+// IsDefault reports whether c provides the same crontabs as the default TabsForUser.
+func (c TabsConfig) IsDefault() bool {
+	return len(c.Files) == 0 && !c.SkipUserCrontab
+}
+
+// TabsForUser provides references to the configured crontabs. It can be passed to SetTabsForUser.
+func (c TabsConfig) TabsForUser(u *user.User) []Tab {
+	var ts []Tab
+	if !c.SkipUserCrontab {
+		ts = append(ts, TabForUser(u))
+	}
+	for _, f := range c.Files {
+		fu := u
+		if f.System {
+			fu = nil
+		}
+		ts = append(ts, FileTab(f.Path, fu))
+	}
+	return ts
+}
+
 func (t *userTab) Load() (*TabConfig, error) {
 	defer logutil.StartTimerLogger(log.With().Str("user", t.u.Username).Logger(), zerolog.DebugLevel, "Loading crontab for user").Stop()
+	s, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	return parseTabConfig(s, t.u)
+}
+
+func (t *userTab) Update(tc *TabConfig) error {
+	defer logutil.StartTimerLogger(log.With().Str("user", t.u.Username).Logger(), zerolog.DebugLevel, "Updating crontab for user").Stop()
+	return update(tc, t.read, t.write, t.backups())
+}
+
+func (t *userTab) Undo() (time.Time, error) {
+	defer logutil.StartTimerLogger(log.With().Str("user", t.u.Username).Logger(), zerolog.DebugLevel, "Restoring crontab for user").Stop()
+	return undo(t.read, t.write, t.backups())
+}
+
+func (t *userTab) read() (string, error) {
 	var stdout bytes.Buffer
 	cmd := exec.Command("crontab", "-u", t.u.Username, "-l")
 	cmd.Stdout = &stdout
 	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("could not load crontab for %q: %w", t.u.Username, err)
+		return "", fmt.Errorf("could not load crontab for %q: %w", t.u.Username, err)
 	}
-
-	return parseTabConfig(string(stdout.Bytes()), t.u)
+	return stdout.String(), nil
 }
 
-func (t *userTab) Update(tc *TabConfig) error {
-	defer logutil.StartTimerLogger(log.With().Str("user", t.u.Username).Logger(), zerolog.DebugLevel, "Updating crontab for user").Stop()
-
+func (t *userTab) write(s string) error {
 	cmd := exec.Command("crontab", "-u", t.u.Username, "-")
-	cmd.Stdin = strings.NewReader(tc.String())
+	cmd.Stdin = strings.NewReader(s)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("could not update crontab for %q: %w", t.u.Username, err)
 	}
 	return nil
 }
 
+func (t *userTab) backups() backups {
+	return dirBackups{filepath.Join("user", t.u.Username)}
+}
+
 func (t *userTab) String() string {
 	return fmt.Sprintf("crontab for %q", t.u.Username)
 }
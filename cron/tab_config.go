@@ -1,6 +1,7 @@
 package cron
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os/user"
 	"path"
@@ -25,21 +26,33 @@ var (
 type TabConfig struct {
 	entries []entry
 	jobs    []*Job
+	// loaded is the hash of the crontab that the TabConfig was parsed from, so that updates can detect conflicts.
+	loaded *[sha256.Size]byte
+}
+
+// ParseTabConfig parses the content of a crontab, e.g. one that's stored somewhere other than the user's crontab. Jobs belong to u, or to the user in their sixth field if u is nil (i.e. a system crontab like /etc/crontab).
+func ParseTabConfig(crontab string, u *user.User) (*TabConfig, error) {
+	return parseTabConfig(crontab, u)
 }
 
 func parseTabConfig(crontab string, u *user.User) (*TabConfig, error) {
-	var tc TabConfig
+	h := hash(crontab)
+	tc := TabConfig{loaded: &h}
 	ls := strings.Split(crontab, "\n")
 	hasComments := false // Whether we've attempted to write anything to comments. Use this instead of len(comments) to avoid collapsing multiple empty lines together.
 	var comments strings.Builder
 	for _, l := range ls {
+		var j *Job
 		if isComment(l) || strings.TrimSpace(l) == "" {
-			if hasComments {
-				comments.WriteString("\n")
+			var ok bool
+			if j, ok = parseDisabledJob(l, u); !ok {
+				if hasComments {
+					comments.WriteString("\n")
+				}
+				hasComments = true
+				comments.WriteString(l)
+				continue
 			}
-			hasComments = true
-			comments.WriteString(l)
-			continue
 		}
 
 		if hasComments {
@@ -48,54 +61,82 @@ func parseTabConfig(crontab string, u *user.User) (*TabConfig, error) {
 			comments.Reset()
 		}
 
-		n := numScheduleFields + numCommandFields
-		if u == nil {
-			n += numUserFields
+		if j == nil {
+			var err error
+			if j, err = parseJob(l, u); err != nil {
+				return nil, err
+			}
 		}
-		seps, fs := fieldsN(l, n)
 
-		if len(fs) != n {
-			return nil, fmt.Errorf("crontab has a malformed line: %q", l)
-		}
+		tc.entries = append(tc.entries, j)
+		tc.jobs = append(tc.jobs, j)
+	}
 
-		var s strings.Builder
-		i := 0
-		for ; i < numScheduleFields; i++ {
-			s.WriteString(seps[i])
-			s.WriteString(fs[i])
-		}
-		sched, err := NewSchedule(s.String())
-		if err != nil {
-			return nil, fmt.Errorf("crontab has a malformed schedule %q: %w", s.String(), err)
-		}
+	if hasComments {
+		tc.entries = append(tc.entries, comment(comments.String()))
+	}
 
-		var j Job
-		j.Schedule = sched
-		j.sep1 = seps[i]
-		if u == nil {
-			j.user = &fs[i]
-			i++
-			j.sep2 = seps[i]
+	return &tc, nil
+}
 
-			if u, err := user.Lookup(*j.user); err != nil {
-				log.Warn().Err(err).Str("user", *j.user).Msg("Error looking up crontab user")
-			} else {
-				j.User = u
-			}
+func parseJob(l string, u *user.User) (*Job, error) {
+	n := numScheduleFields + numCommandFields
+	if u == nil {
+		n += numUserFields
+	}
+	seps, fs := fieldsN(l, n)
+
+	if len(fs) != n {
+		return nil, fmt.Errorf("crontab has a malformed line: %q", l)
+	}
+
+	var s strings.Builder
+	i := 0
+	for ; i < numScheduleFields; i++ {
+		s.WriteString(seps[i])
+		s.WriteString(fs[i])
+	}
+	// Cron daemons accept schedules that we can't parse (e.g. 7 for Sunday). The job is kept as-is, and only cron jobs that need to know their next execution care (see Schedule.Err).
+	sched, err := NewSchedule(s.String())
+	if err != nil {
+		log.Debug().Err(err).Str("schedule", s.String()).Msg("Could not parse cron schedule")
+	}
+
+	var j Job
+	j.Schedule = sched
+	j.sep1 = seps[i]
+	if u == nil {
+		j.user = &fs[i]
+		i++
+		j.sep2 = seps[i]
+
+		if u, err := user.Lookup(*j.user); err != nil {
+			log.Warn().Err(err).Str("user", *j.user).Msg("Error looking up crontab user")
 		} else {
 			j.User = u
 		}
-		j.Command = NewCommand(fs[i])
-
-		tc.entries = append(tc.entries, &j)
-		tc.jobs = append(tc.jobs, &j)
+	} else {
+		j.User = u
 	}
+	j.Command = NewCommand(fs[i])
 
-	if hasComments {
-		tc.entries = append(tc.entries, comment(comments.String()))
+	return &j, nil
+}
+
+// parseDisabledJob checks whether the comment l is actually a cron2mqtt job that has been commented out.
+// We only recognize cron2mqtt jobs so that we don't mistake arbitrary comments for jobs.
+func parseDisabledJob(l string, u *user.User) (*Job, bool) {
+	i := strings.Index(l, "#")
+	if i < 0 || strings.TrimSpace(l[:i]) != "" {
+		return nil, false
 	}
 
-	return &tc, nil
+	j, err := parseJob(l[i+1:], u)
+	if err != nil || !j.Command.IsCron2Mqtt() {
+		return nil, false
+	}
+	j.disabled = l[:i+1]
+	return j, true
 }
 
 func isComment(s string) bool {
@@ -127,6 +168,7 @@ func (c comment) String() string {
 }
 
 type Job struct {
+	disabled string // The comment prefix if this job has been commented out.
 	Schedule Schedule
 	sep1     string
 	user     *string // may or may not be present. Not all crontabs specify a user per job.
@@ -139,6 +181,7 @@ type Job struct {
 func (*Job) isEntry() {}
 func (j *Job) String() string {
 	var b strings.Builder
+	b.WriteString(j.disabled)
 	b.WriteString(j.Schedule.orig)
 	b.WriteString(j.sep1)
 	if j.user != nil {
@@ -149,15 +192,32 @@ func (j *Job) String() string {
 	return b.String()
 }
 
+// Enabled returns whether this job is active, i.e. it hasn't been commented out.
+func (j *Job) Enabled() bool {
+	return j.disabled == ""
+}
+
+// SetEnabled comments or uncomments this job. Only cron2mqtt jobs will be recognized as disabled jobs when the crontab is loaded again.
+func (j *Job) SetEnabled(enabled bool) {
+	if enabled {
+		j.disabled = ""
+	} else if j.disabled == "" {
+		j.disabled = "#"
+	}
+}
+
 type Schedule struct {
 	orig     string
-	schedule cron.Schedule
+	schedule cron.Schedule // nil if orig couldn't be parsed.
+	err      error
 }
 
+// NewSchedule parses s. If s can't be parsed, the returned Schedule still keeps s, so that it can be written back to its crontab unchanged.
 func NewSchedule(s string) (Schedule, error) {
 	sched, err := cron.ParseStandard(s)
 	if err != nil {
-		return Schedule{}, nil
+		err = fmt.Errorf("malformed schedule %q: %w", strings.TrimSpace(s), err)
+		return Schedule{orig: s, err: err}, err
 	}
 	return Schedule{
 		orig:     s,
@@ -165,8 +225,16 @@ func NewSchedule(s string) (Schedule, error) {
 	}, nil
 }
 
-// Next returns the estimated next exectuion time of this Schedule that happens strictly after t.
+// Err returns why the Schedule couldn't be parsed, or nil if it could.
+func (s Schedule) Err() error {
+	return s.err
+}
+
+// Next returns the estimated next exectuion time of this Schedule that happens strictly after t. It returns the zero time if the Schedule couldn't be parsed.
 func (s Schedule) Next(t time.Time) time.Time {
+	if s.schedule == nil {
+		return time.Time{}
+	}
 	return s.schedule.Next(t)
 }
 
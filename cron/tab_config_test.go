@@ -4,6 +4,7 @@ import (
 	"os/user"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -167,6 +168,24 @@ func TestTabConfigRoundtrip(t *testing.T) {
 			u:    currentUserOrDie(),
 			s: `
 * * * * * echo "foo bar"
+`,
+		},
+		{
+			name: "disabled cron2mqtt job",
+			u:    currentUserOrDie(),
+			s: `
+# comment
+#* * * * * cron2mqtt exec abcd echo foo
+  # * * * * * cron2mqtt exec efgh echo bar
+# * * * * * echo baz
+`,
+		},
+		{
+			name: "schedule that can't be parsed",
+			u:    currentUserOrDie(),
+			s: `
+0 0 * * 7 echo sunday
+* * * * * echo foo
 `,
 		},
 		{
@@ -229,6 +248,22 @@ func TestParseTabConfig(t *testing.T) {
 	}
 }
 
+func TestUnparsableSchedule(t *testing.T) {
+	tab, err := parseTabConfig("0 0 * * 7 echo sunday\n* * * * * echo foo", currentUserOrDie())
+	if err != nil {
+		t.Fatalf("parseTabConfig failed: %s", err)
+	}
+	if err := tab.Jobs()[0].Schedule.Err(); err == nil {
+		t.Errorf("Schedule.Err() = nil for %q, want an error", tab.Jobs()[0].Schedule)
+	}
+	if next := tab.Jobs()[0].Schedule.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Schedule.Next() = %s for %q, want the zero time", next, tab.Jobs()[0].Schedule)
+	}
+	if err := tab.Jobs()[1].Schedule.Err(); err != nil {
+		t.Errorf("Schedule.Err() = %v for %q, want nil", err, tab.Jobs()[1].Schedule)
+	}
+}
+
 func TestTransform(t *testing.T) {
 	cmd1 := "* * * * * foo echo 1 2 3"
 	cmd2 := "* * * * * bar echo 4 5 6"
@@ -248,3 +283,42 @@ func TestTransform(t *testing.T) {
 		t.Errorf("jobs diff (-want +got):\n%s", diff)
 	}
 }
+
+func TestDisabledJobs(t *testing.T) {
+	tab, err := parseTabConfig(`# comment
+#* * * * * cron2mqtt exec abcd echo 1 2 3
+# * * * * * echo 4 5 6
+* * * * * cron2mqtt exec efgh echo 7 8 9`, currentUserOrDie())
+	if err != nil {
+		t.Fatalf("Unexpected error generating cron.Tab: %s", err)
+	}
+
+	var enabled []bool
+	for _, j := range tab.Jobs() {
+		enabled = append(enabled, j.Enabled())
+	}
+	if diff := cmp.Diff([]bool{false, true}, enabled); diff != "" {
+		t.Errorf("enabled diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	cmd1 := "* * * * * cron2mqtt exec abcd echo 1 2 3"
+	cmd2 := "  # * * * * * cron2mqtt exec efgh echo 4 5 6"
+	tab, err := parseTabConfig(cmd1+"\n"+cmd2, currentUserOrDie())
+	if err != nil {
+		t.Fatalf("Unexpected error generating cron.Tab: %s", err)
+	}
+
+	tab.Jobs()[0].SetEnabled(false)
+	tab.Jobs()[1].SetEnabled(false)
+	if diff := cmp.Diff("#"+cmd1+"\n"+cmd2, tab.String()); diff != "" {
+		t.Errorf("disabled crontab diff (-want +got):\n%s", diff)
+	}
+
+	tab.Jobs()[0].SetEnabled(true)
+	tab.Jobs()[1].SetEnabled(true)
+	if diff := cmp.Diff(cmd1+"\n * * * * * cron2mqtt exec efgh echo 4 5 6", tab.String()); diff != "" {
+		t.Errorf("enabled crontab diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,408 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileTab(t *testing.T) {
+	u := &user.User{Uid: "1000", Username: "someone"}
+	for _, tc := range []struct {
+		name string
+
+		content *string // nil if the file doesn't exist.
+		perm    os.FileMode
+		u       *user.User
+
+		wantJobs int
+		wantPerm os.FileMode
+	}{
+		{
+			name:     "user crontab",
+			content:  ptr("# Comment\n0 * * * * echo hello\n"),
+			perm:     0644,
+			u:        u,
+			wantJobs: 1,
+			wantPerm: 0644,
+		},
+		{
+			name:     "system crontab",
+			content:  ptr("0 * * * * root echo hello\n0 * * * * root echo world\n"),
+			perm:     0600,
+			wantJobs: 2,
+			wantPerm: 0600,
+		},
+		{
+			name:     "missing file",
+			u:        u,
+			wantPerm: 0600,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("XDG_STATE_HOME", t.TempDir())
+			dir := t.TempDir()
+			path := filepath.Join(dir, "crontab")
+			if tc.content != nil {
+				if err := os.WriteFile(path, []byte(*tc.content), tc.perm); err != nil {
+					t.Fatalf("Could not write crontab: %s", err)
+				}
+				// WriteFile is subject to umask.
+				if err := os.Chmod(path, tc.perm); err != nil {
+					t.Fatalf("Could not chmod crontab: %s", err)
+				}
+			}
+
+			ct := FileTab(path, tc.u)
+			conf, err := ct.Load()
+			if err != nil {
+				t.Fatalf("Load failed: %s", err)
+			}
+			if got := len(conf.Jobs()); got != tc.wantJobs {
+				t.Errorf("Load found %d jobs, want %d", got, tc.wantJobs)
+			}
+			for _, j := range conf.Jobs() {
+				j.SetEnabled(false)
+			}
+			want := conf.String()
+			if err := ct.Update(conf); err != nil {
+				t.Fatalf("Update failed: %s", err)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Could not read crontab: %s", err)
+			}
+			if diff := cmp.Diff(want, string(b)); diff != "" {
+				t.Errorf("Updated crontab mismatch (-want +got):\n%s", diff)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Could not stat crontab: %s", err)
+			}
+			if got := fi.Mode().Perm(); got != tc.wantPerm {
+				t.Errorf("Updated crontab has permissions %#o, want %#o", got, tc.wantPerm)
+			}
+			if es, err := os.ReadDir(dir); err != nil {
+				t.Errorf("Could not read directory: %s", err)
+			} else if len(es) != 1 {
+				t.Errorf("Update left temporary files behind: %v", es)
+			}
+		})
+	}
+}
+
+func TestMemTab(t *testing.T) {
+	ct := NewMemTab("test", nil, "0 * * * * root echo hello\n")
+	conf, err := ct.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	conf.Jobs()[0].SetEnabled(false)
+	if err := ct.Update(conf); err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	if diff := cmp.Diff("#0 * * * * root echo hello\n", ct.Content()); diff != "" {
+		t.Errorf("Updated crontab mismatch (-want +got):\n%s", diff)
+	}
+	if got := ct.Updates(); got != 1 {
+		t.Errorf("Updates() = %d, want 1", got)
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "crontab")
+	for _, tc := range []struct {
+		name string
+		ct   Tab
+		edit func(content string)
+	}{
+		{
+			name: "MemTab",
+			ct:   NewMemTab("test", nil, "0 * * * * root echo hello\n"),
+		},
+		{
+			name: "FileTab",
+			ct:   FileTab(path, nil),
+			edit: func(content string) {
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatalf("Could not write crontab: %s", err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			edit := tc.edit
+			if edit == nil {
+				edit = tc.ct.(*MemTab).SetContent
+			}
+			edit("0 * * * * root echo hello\n")
+
+			conf, err := tc.ct.Load()
+			if err != nil {
+				t.Fatalf("Load failed: %s", err)
+			}
+			conf.Jobs()[0].SetEnabled(false)
+			if err := tc.ct.Update(conf); err != nil {
+				t.Fatalf("Update failed: %s", err)
+			}
+			// The same config can be updated again.
+			conf.Jobs()[0].SetEnabled(true)
+			if err := tc.ct.Update(conf); err != nil {
+				t.Fatalf("Second update failed: %s", err)
+			}
+
+			edit("0 * * * * root echo edited\n")
+			conf.Jobs()[0].SetEnabled(false)
+			if err := tc.ct.Update(conf); !errors.Is(err, ErrConflict) {
+				t.Errorf("Update after the crontab was edited = %v, want %v", err, ErrConflict)
+			}
+		})
+	}
+}
+
+func TestUndo(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	const content = "0 * * * * root cron2mqtt exec one true\n0 * * * * root cron2mqtt exec two true\n0 * * * * root cron2mqtt exec three true\n"
+	path := filepath.Join(t.TempDir(), "crontab")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Could not write crontab: %s", err)
+	}
+	for _, ct := range []Tab{NewMemTab("test", nil, content), FileTab(path, nil)} {
+		t.Run(fmt.Sprintf("%T", ct), func(t *testing.T) {
+			// Disable each job in turn, keeping track of the crontab before each update.
+			var want []string
+			for i := 0; i < 3; i++ {
+				conf, err := ct.Load()
+				if err != nil {
+					t.Fatalf("Load failed: %s", err)
+				}
+				want = append(want, conf.String())
+				conf.Jobs()[i].SetEnabled(false)
+				if err := ct.Update(conf); err != nil {
+					t.Fatalf("Update failed: %s", err)
+				}
+			}
+
+			for i := len(want) - 1; i >= 0; i-- {
+				if _, err := ct.Undo(); err != nil {
+					t.Fatalf("Undo failed: %s", err)
+				}
+				conf, err := ct.Load()
+				if err != nil {
+					t.Fatalf("Load failed: %s", err)
+				}
+				if diff := cmp.Diff(want[i], conf.String()); diff != "" {
+					t.Errorf("Crontab after undo #%d mismatch (-want +got):\n%s", len(want)-i, diff)
+				}
+			}
+			if _, err := ct.Undo(); !errors.Is(err, ErrNoBackup) {
+				t.Errorf("Undo without backups = %v, want %v", err, ErrNoBackup)
+			}
+		})
+	}
+}
+
+func TestUndoConflict(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	const content = "0 * * * * root cron2mqtt exec one true\n"
+	path := filepath.Join(t.TempDir(), "crontab")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Could not write crontab: %s", err)
+	}
+	for _, tc := range []struct {
+		ct   Tab
+		edit func(content string)
+	}{
+		{
+			ct: NewMemTab("test", nil, content),
+		},
+		{
+			ct: FileTab(path, nil),
+			edit: func(content string) {
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatalf("Could not write crontab: %s", err)
+				}
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("%T", tc.ct), func(t *testing.T) {
+			edit := tc.edit
+			if edit == nil {
+				edit = tc.ct.(*MemTab).SetContent
+			}
+			conf, err := tc.ct.Load()
+			if err != nil {
+				t.Fatalf("Load failed: %s", err)
+			}
+			conf.Jobs()[0].SetEnabled(false)
+			if err := tc.ct.Update(conf); err != nil {
+				t.Fatalf("Update failed: %s", err)
+			}
+			updated := conf.String()
+
+			const edited = "0 * * * * root cron2mqtt exec edited true\n"
+			edit(edited)
+			if _, err := tc.ct.Undo(); !errors.Is(err, ErrConflict) {
+				t.Errorf("Undo after the crontab was edited = %v, want %v", err, ErrConflict)
+			}
+			if conf, err := tc.ct.Load(); err != nil {
+				t.Fatalf("Load failed: %s", err)
+			} else if diff := cmp.Diff(edited, conf.String()); diff != "" {
+				t.Errorf("Crontab after conflicting undo mismatch (-want +got):\n%s", diff)
+			}
+
+			// The backup is kept, so the update can still be undone once the edit is reverted.
+			edit(updated)
+			if _, err := tc.ct.Undo(); err != nil {
+				t.Fatalf("Undo failed: %s", err)
+			}
+			if conf, err := tc.ct.Load(); err != nil {
+				t.Fatalf("Load failed: %s", err)
+			} else if diff := cmp.Diff(content, conf.String()); diff != "" {
+				t.Errorf("Crontab after undo mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMaxBackups(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	b := dirBackups{"test"}
+	for i := 0; i < MaxBackups+5; i++ {
+		if err := b.push(fmt.Sprint(i), hash(fmt.Sprint(i+1))); err != nil {
+			t.Fatalf("push failed: %s", err)
+		}
+	}
+	ps, err := b.list()
+	if err != nil {
+		t.Fatalf("list failed: %s", err)
+	}
+	if len(ps) != MaxBackups {
+		t.Errorf("Kept %d backups, want %d", len(ps), MaxBackups)
+	}
+	d, err := b.dir()
+	if err != nil {
+		t.Fatalf("dir failed: %s", err)
+	}
+	if es, err := os.ReadDir(d); err != nil {
+		t.Errorf("Could not read backup directory: %s", err)
+	} else if len(es) != 2*MaxBackups {
+		t.Errorf("Backup directory has %d files, want a backup and a hash for each of the %d backups", len(es), MaxBackups)
+	}
+	if bk, err := b.last(); err != nil {
+		t.Errorf("last failed: %s", err)
+	} else if want := fmt.Sprint(MaxBackups + 4); bk.content != want {
+		t.Errorf("Last backup = %q, want %q", bk.content, want)
+	}
+}
+
+func TestTabsConfig(t *testing.T) {
+	u := &user.User{Uid: "1000", Username: "someone"}
+	for _, tc := range []struct {
+		name string
+		conf TabsConfig
+		want []string
+	}{
+		{
+			name: "default",
+			want: []string{`crontab for "someone"`},
+		},
+		{
+			name: "files",
+			conf: TabsConfig{Files: []FileConfig{{Path: "/etc/crontabs/someone"}, {Path: "/etc/crontab", System: true}}},
+			want: []string{`crontab for "someone"`, "crontab file /etc/crontabs/someone", "crontab file /etc/crontab"},
+		},
+		{
+			name: "skip user crontab",
+			conf: TabsConfig{Files: []FileConfig{{Path: "/etc/crontabs/someone"}}, SkipUserCrontab: true},
+			want: []string{"crontab file /etc/crontabs/someone"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, ct := range tc.conf.TabsForUser(u) {
+				got = append(got, ct.(fmt.Stringer).String())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("TabsForUser mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestUndoAfterFailedUpdate(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	const orig = "0 * * * * root cron2mqtt exec one true\n"
+	for _, bs := range []backups{&memBackups{}, dirBackups{"test"}} {
+		t.Run(fmt.Sprintf("%T", bs), func(t *testing.T) {
+			content := orig
+			read := func() (string, error) { return content, nil }
+			write := func(s string) error { content = s; return nil }
+			errRejected := errors.New("crontab rejected")
+			rejecting := func(string) error { return errRejected }
+
+			tc, err := parseTabConfig(content, nil)
+			if err != nil {
+				t.Fatalf("parseTabConfig failed: %s", err)
+			}
+			tc.Jobs()[0].SetEnabled(false)
+			if err := update(tc, read, write, bs); err != nil {
+				t.Fatalf("update failed: %s", err)
+			}
+			tc.Jobs()[0].SetEnabled(true)
+			if err := update(tc, read, rejecting, bs); !errors.Is(err, errRejected) {
+				t.Fatalf("update with a failing write = %v, want %v", err, errRejected)
+			}
+
+			// Only the successful update is undone.
+			if _, err := undo(read, write, bs); err != nil {
+				t.Fatalf("undo failed: %s", err)
+			}
+			if diff := cmp.Diff(orig, content); diff != "" {
+				t.Errorf("Crontab after undo mismatch (-want +got):\n%s", diff)
+			}
+			if _, err := undo(read, write, bs); !errors.Is(err, ErrNoBackup) {
+				t.Errorf("Second undo = %v, want %v", err, ErrNoBackup)
+			}
+		})
+	}
+}
+
+func TestUndoWithoutHash(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	b := dirBackups{"test"}
+	const content = "0 * * * * root cron2mqtt exec one true\n"
+	if err := b.push("old", hash(content)); err != nil {
+		t.Fatalf("push failed: %s", err)
+	}
+	bk, err := b.last()
+	if err != nil {
+		t.Fatalf("last failed: %s", err)
+	}
+	// E.g. a backup from an older version.
+	if err := os.Remove(bk.path + ".sha256"); err != nil {
+		t.Fatalf("Could not remove hash: %s", err)
+	}
+
+	read := func() (string, error) { return content, nil }
+	write := func(string) error { t.Errorf("undo restored a backup without a hash"); return nil }
+	if _, err := undo(read, write, b); !errors.Is(err, ErrConflict) {
+		t.Errorf("undo without a hash = %v, want %v", err, ErrConflict)
+	}
+	if bk, err := b.last(); err != nil {
+		t.Fatalf("last failed: %s", err)
+	} else if err := b.drop(bk); err != nil {
+		t.Errorf("drop without a hash failed: %s", err)
+	}
+}
@@ -3,6 +3,7 @@ package exec
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
@@ -22,6 +23,8 @@ type Result struct {
 
 	ExitCode int
 	Err      error
+	// TimedOut is set if the command was killed because ctx's deadline was exceeded.
+	TimedOut bool
 }
 
 // Run the command immediately, and wait for it to complete.
@@ -45,6 +48,7 @@ func Run(ctx context.Context, name string, args ...string) Result {
 	res.Stderr = stderr.Bytes()
 	res.ExitCode = c.ProcessState.ExitCode()
 	res.Err = err
+	res.TimedOut = err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
 
 	return res
 }
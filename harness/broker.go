@@ -0,0 +1,278 @@
+// Package harness provides fakes for end-to-end tests of cron2mqtt: an in-memory MQTT 3.1.1 broker that listens on a loopback port, and substitute crontabs.
+package harness
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+// Broker is a minimal MQTT 3.1.1 broker. It supports wildcard subscriptions, retained messages and wills. Every subscription is granted QoS 0, and sessions aren't persisted.
+type Broker struct {
+	l net.Listener
+
+	mut      sync.Mutex
+	conns    map[*brokerConn]bool
+	retained map[string]*packets.PublishPacket
+	// username and password are required from every client, unless they're empty (see BrokerCredentials).
+	username, password string
+
+	wg sync.WaitGroup
+}
+
+// BrokerOption customizes a Broker.
+type BrokerOption func(*Broker)
+
+// BrokerCredentials makes the broker refuse clients that don't connect with username and password.
+func BrokerCredentials(username, password string) BrokerOption {
+	return func(b *Broker) {
+		b.username = username
+		b.password = password
+	}
+}
+
+// StartBroker starts a broker on a loopback port. It's closed when the test finishes.
+func StartBroker(t testing.TB, opts ...BrokerOption) *Broker {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start MQTT broker: %s", err)
+	}
+	b := &Broker{
+		l:        l,
+		conns:    make(map[*brokerConn]bool),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.wg.Add(1)
+	go b.serve()
+	t.Cleanup(b.Close)
+	return b
+}
+
+// URL is the address that clients should connect to.
+func (b *Broker) URL() string {
+	return "tcp://" + b.l.Addr().String()
+}
+
+// Close disconnects all clients and stops the broker.
+func (b *Broker) Close() {
+	b.l.Close()
+	b.mut.Lock()
+	for c := range b.conns {
+		c.conn.Close()
+	}
+	b.mut.Unlock()
+	b.wg.Wait()
+}
+
+// Retained returns the payloads of the retained messages, keyed by their topics.
+func (b *Broker) Retained() map[string]string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	r := make(map[string]string)
+	for t, p := range b.retained {
+		r[t] = string(p.Payload)
+	}
+	return r
+}
+
+// Publish publishes a message to the broker's subscribers, as if a client had published it.
+func (b *Broker) Publish(topic string, retain bool, payload string) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Retain = retain
+	p.Payload = []byte(payload)
+	b.publish(p)
+}
+
+func (b *Broker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &brokerConn{b: b, conn: conn, subs: make(map[string]bool)}
+		b.mut.Lock()
+		b.conns[c] = true
+		b.mut.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// publish retains p if necessary, and sends it to every client with a matching subscription.
+func (b *Broker) publish(p *packets.PublishPacket) {
+	b.mut.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(b.retained, p.TopicName)
+		} else {
+			r := p.Copy()
+			r.Retain = true
+			b.retained[p.TopicName] = r
+		}
+	}
+	var cs []*brokerConn
+	for c := range b.conns {
+		if c.subscribed(p.TopicName) {
+			cs = append(cs, c)
+		}
+	}
+	b.mut.Unlock()
+
+	// Only retained messages that are sent because of a new subscription have their retain flag set.
+	out := p.Copy()
+	out.Retain = false
+	for _, c := range cs {
+		c.write(out)
+	}
+}
+
+// brokerConn is a client's connection to a Broker.
+type brokerConn struct {
+	b    *Broker
+	conn net.Conn
+
+	writeMut sync.Mutex
+
+	// subs is guarded by b.mut.
+	subs map[string]bool
+	will *packets.PublishPacket
+}
+
+func (c *brokerConn) subscribed(topic string) bool {
+	for f := range c.subs {
+		if mqttfake.Match(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *brokerConn) write(p packets.ControlPacket) {
+	c.writeMut.Lock()
+	defer c.writeMut.Unlock()
+	// Write errors mean that the client disconnected, which serve will notice.
+	p.Write(c.conn)
+}
+
+func (c *brokerConn) serve() {
+	clean := false
+	defer func() {
+		c.conn.Close()
+		c.b.mut.Lock()
+		delete(c.b.conns, c)
+		c.b.mut.Unlock()
+		if !clean && c.will != nil {
+			c.b.publish(c.will)
+		}
+	}()
+
+	p, err := packets.ReadPacket(c.conn)
+	if err != nil {
+		return
+	}
+	connect, ok := p.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ack.ReturnCode = connect.Validate()
+	if ack.ReturnCode == packets.Accepted && c.b.username != "" && (connect.Username != c.b.username || string(connect.Password) != c.b.password) {
+		ack.ReturnCode = packets.ErrRefusedBadUsernameOrPassword
+	}
+	c.write(ack)
+	if ack.ReturnCode != packets.Accepted {
+		return
+	}
+	if connect.WillFlag {
+		c.will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		c.will.TopicName = connect.WillTopic
+		c.will.Payload = connect.WillMessage
+		c.will.Retain = connect.WillRetain
+	}
+
+	for {
+		p, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			// The client went away without disconnecting, so its will is published.
+			return
+		}
+
+		switch p := p.(type) {
+		case *packets.PublishPacket:
+			// Publish before acknowledging, so that the message is retained by the time the client's publish completes.
+			out := p.Copy()
+			out.Retain = p.Retain
+			c.b.publish(out)
+			switch p.Qos {
+			case 1:
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			case 2:
+				rec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				rec.MessageID = p.MessageID
+				c.write(rec)
+			}
+		case *packets.PubrelPacket:
+			comp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			comp.MessageID = p.MessageID
+			c.write(comp)
+		case *packets.SubscribePacket:
+			c.subscribe(p)
+		case *packets.UnsubscribePacket:
+			c.b.mut.Lock()
+			for _, t := range p.Topics {
+				delete(c.subs, t)
+			}
+			c.b.mut.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			clean = true
+			return
+		}
+	}
+}
+
+func (c *brokerConn) subscribe(p *packets.SubscribePacket) {
+	c.b.mut.Lock()
+	var retained []*packets.PublishPacket
+	for _, f := range p.Topics {
+		c.subs[f] = true
+		for t, m := range c.b.retained {
+			if mqttfake.Match(f, t) {
+				retained = append(retained, m)
+			}
+		}
+	}
+	c.b.mut.Unlock()
+
+	ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	ack.MessageID = p.MessageID
+	ack.ReturnCodes = make([]byte, len(p.Topics))
+	c.write(ack)
+
+	sort.Slice(retained, func(i, j int) bool { return retained[i].TopicName < retained[j].TopicName })
+	for _, m := range retained {
+		c.write(m)
+	}
+}
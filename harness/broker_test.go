@@ -0,0 +1,96 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+func TestBroker(t *testing.T) {
+	b := StartBroker(t)
+	c, err := mqtt.NewClient(mqtt.Config{Broker: b.URL()})
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	defer c.Close(0)
+
+	ctx := context.Background()
+	for _, topic := range []string{"foo/retained", "foo/cleared", "bar/retained"} {
+		if err := c.Publish(ctx, topic, mqtt.QoSExactlyOnce, mqtt.Retain, "hello"); err != nil {
+			t.Fatalf("Publish failed: %s", err)
+		}
+	}
+	if err := c.Publish(ctx, "foo/cleared", mqtt.QoSAtLeastOnce, mqtt.Retain, ""); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	want := map[string]string{"foo/retained": "hello", "bar/retained": "hello"}
+	if diff := cmp.Diff(want, b.Retained()); diff != "" {
+		t.Errorf("Retained messages diff (-want +got):\n%s", diff)
+	}
+
+	subCtx, canc := context.WithCancel(ctx)
+	defer canc()
+	ms := make(chan mqtt.Message, 10)
+	if err := c.Subscribe(subCtx, "foo/#", mqtt.QoSExactlyOnce, ms); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+	if m := receive(t, ms); m.Topic() != "foo/retained" || !m.Retained() {
+		t.Errorf("Received %s (retained: %t), want retained foo/retained", m.Topic(), m.Retained())
+	}
+
+	if err := c.Publish(ctx, "foo/live", mqtt.QoSAtMostOnce, mqtt.DoNotRetain, "world"); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	if m := receive(t, ms); m.Topic() != "foo/live" || m.Retained() || string(m.Payload()) != "world" {
+		t.Errorf("Received %s %q (retained: %t), want foo/live %q", m.Topic(), m.Payload(), m.Retained(), "world")
+	}
+}
+
+func TestBrokerWill(t *testing.T) {
+	b := StartBroker(t)
+	c, err := mqtt.NewClient(mqtt.Config{Broker: b.URL()}, mqtt.ClientWill(mqtt.Will{Topic: "will", Payload: "offline", Retain: mqtt.Retain}))
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	b.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for b.Retained()["will"] != "offline" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Will was not published after the connection was lost")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close(0)
+}
+
+func TestBrokerCredentials(t *testing.T) {
+	b := StartBroker(t, BrokerCredentials("user", "secret"))
+	conf := mqtt.Config{Broker: b.URL(), Username: "user", Password: "wrong", Retry: mqtt.RetryConfig{Attempts: 1}}
+	if _, err := mqtt.NewClient(conf); !errors.Is(err, mqtt.ErrNotAuthorized) {
+		t.Errorf("NewClient with the wrong password failed with %v, want %v", err, mqtt.ErrNotAuthorized)
+	}
+
+	conf.Password = "secret"
+	c, err := mqtt.NewClient(conf)
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	c.Close(0)
+}
+
+func receive(t *testing.T, ms <-chan mqtt.Message) mqtt.Message {
+	t.Helper()
+	select {
+	case m := <-ms:
+		return m
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive a message")
+		return nil
+	}
+}
@@ -0,0 +1,14 @@
+package harness
+
+import (
+	"os/user"
+	"testing"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+)
+
+// UseTabs makes cron.TabsForUser provide tabs for every user until the test finishes. cron.MemTab is a convenient fake crontab.
+func UseTabs(t testing.TB, tabs ...cron.Tab) {
+	cron.SetTabsForUser(func(*user.User) []cron.Tab { return tabs })
+	t.Cleanup(func() { cron.SetTabsForUser(nil) })
+}
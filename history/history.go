@@ -0,0 +1,428 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+)
+
+const (
+	defaultMaxFileSize   = 1 << 20 // 1 MiB
+	defaultMaxOutputSize = 4 << 10 // 4 KiB
+)
+
+// Entry is a single historical execution of a cron job.
+type Entry struct {
+	Start    time.Time `json:"start_time"`
+	End      time.Time `json:"end_time"`
+	ExitCode int       `json:"exit_code"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	// Truncated is set if Stdout or Stderr were too large to be stored in their entirety.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (e Entry) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+func (e Entry) Succeeded() bool {
+	return e.ExitCode == 0
+}
+
+// Store keeps a size-bounded history of executions for each cron job.
+//
+// Each cron job's history is stored as JSON lines in its own file. Once that file grows larger than the maximum file size, it's rotated so that we keep at most two files' worth of history per cron job.
+type Store struct {
+	dir           string
+	maxFileSize   int64
+	maxOutputSize int
+}
+
+type StoreOption func(*Store)
+
+// MaxFileSize limits how large a single history file can grow before it's rotated.
+func MaxFileSize(n int64) StoreOption {
+	return func(s *Store) {
+		s.maxFileSize = n
+	}
+}
+
+// MaxOutputSize limits how much of a command's stdout and stderr are stored.
+func MaxOutputSize(n int) StoreOption {
+	return func(s *Store) {
+		s.maxOutputSize = n
+	}
+}
+
+// NewStore creates a Store that keeps its history files in dir.
+func NewStore(dir string, opts ...StoreOption) *Store {
+	s := &Store{
+		dir:           dir,
+		maxFileSize:   defaultMaxFileSize,
+		maxOutputSize: defaultMaxOutputSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// DefaultDir is where history is stored unless otherwise specified: $XDG_STATE_HOME/cron2mqtt/history
+func DefaultDir() (string, error) {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return filepath.Join(d, "cron2mqtt", "history"), nil
+	}
+	h, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine state directory: %w", err)
+	}
+	return filepath.Join(h, ".local", "state", "cron2mqtt", "history"), nil
+}
+
+// ErrInvalidID is returned for cron job IDs that can't be used as the name of a history file, e.g. because they would refer to a file outside of the history directory.
+var ErrInvalidID = errors.New("invalid cron job ID for history")
+
+func checkID(id string) error {
+	if id == "" || id == "." || strings.Contains(id, "..") || strings.ContainsRune(id, '/') || strings.ContainsRune(id, filepath.Separator) {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".jsonl")
+}
+
+func (s *Store) rotatedPath(id string) string {
+	return s.path(id) + ".1"
+}
+
+func (s *Store) startPath(id string) string {
+	return s.path(id) + ".start"
+}
+
+// MarkStart records that the cron job identified by id started at t. Executions are only appended once they're done, so this lets LastStart account for executions that are still running.
+func (s *Store) MarkStart(id string, t time.Time) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	b, err := t.MarshalText()
+	if err != nil {
+		return fmt.Errorf("could not marshal start time: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("could not create history directory: %w", err)
+	}
+	if err := os.WriteFile(s.startPath(id), b, 0600); err != nil {
+		return fmt.Errorf("could not record start time: %w", err)
+	}
+	return nil
+}
+
+// LastStart returns when the cron job identified by id most recently started, whether or not it's done, or the zero value if it has never started.
+func (s *Store) LastStart(id string) (time.Time, error) {
+	if err := checkID(id); err != nil {
+		return time.Time{}, err
+	}
+	var last time.Time
+	b, err := os.ReadFile(s.startPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, fmt.Errorf("could not read start time: %w", err)
+	} else if err == nil {
+		if err := last.UnmarshalText(b); err != nil {
+			log.Warn().Err(err).Str("file", s.startPath(id)).Msg("Ignoring malformed start time")
+			last = time.Time{}
+		}
+	}
+
+	es, err := s.Entries(id, Filter{Since: last})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(es) > 0 && es[len(es)-1].Start.After(last) {
+		last = es[len(es)-1].Start
+	}
+	return last, nil
+}
+
+// Append adds res to the history of the cron job identified by id.
+func (s *Store) Append(id string, res exec.Result) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	defer logutil.StartTimerLogger(log.With().Str("id", id).Logger(), zerolog.DebugLevel, "Appending to history").Stop()
+	e := Entry{
+		Start:    res.Start,
+		End:      res.End,
+		ExitCode: res.ExitCode,
+	}
+	var t1, t2 bool
+	e.Stdout, t1 = truncate(res.Stdout, s.maxOutputSize)
+	e.Stderr, t2 = truncate(res.Stderr, s.maxOutputSize)
+	e.Truncated = t1 || t2
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal history entry: %w", err)
+	}
+	b = append(b, '\n')
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("could not create history directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open history: %w", err)
+	}
+	defer f.Close()
+	// Multiple instances of the same cron job might be running at once.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("could not lock history: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("could not write history: %w", err)
+	}
+
+	if fi, err := f.Stat(); err != nil {
+		return fmt.Errorf("could not check history size: %w", err)
+	} else if fi.Size() >= s.maxFileSize {
+		if err := os.Rename(s.path(id), s.rotatedPath(id)); err != nil {
+			return fmt.Errorf("could not rotate history: %w", err)
+		}
+	}
+	return nil
+}
+
+func truncate(b []byte, n int) (string, bool) {
+	if len(b) <= n {
+		return string(b), false
+	}
+	return string(b[:n]), true
+}
+
+// Filter restricts which entries are returned by Store.Entries.
+type Filter struct {
+	FailuresOnly bool
+	// Since and Until are ignored if they're the zero value.
+	Since, Until time.Time
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.FailuresOnly && e.Succeeded() {
+		return false
+	}
+	if !f.Since.IsZero() && e.Start.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Start.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Entries returns the history of the cron job identified by id that matches f, oldest first.
+func (s *Store) Entries(id string, f Filter) ([]Entry, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	var es []Entry
+	for _, p := range []string{s.rotatedPath(id), s.path(id)} {
+		var err error
+		es, err = readEntries(p, f, es)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return es, nil
+}
+
+func readEntries(path string, f Filter, es []Entry) ([]Entry, error) {
+	r, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return es, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not open history: %w", err)
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	for {
+		l, err := br.ReadBytes('\n')
+		if len(l) > 0 {
+			var e Entry
+			if err := json.Unmarshal(l, &e); err != nil {
+				log.Warn().Err(err).Str("file", path).Msg("Skipping malformed history entry")
+			} else if f.matches(e) {
+				es = append(es, e)
+			}
+		}
+		if err == io.EOF {
+			return es, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read history: %w", err)
+		}
+	}
+}
+
+// Summary summarizes the recent history of a cron job.
+type Summary struct {
+	// Runs and Successes only consider the executions within the window.
+	Runs      int
+	Successes int
+	// ConsecutiveFailures is the number of failed executions since the last successful one.
+	ConsecutiveFailures int
+	// LastSuccess is the end time of the most recent successful execution, or the zero value if there wasn't one.
+	LastSuccess time.Time
+
+	MinDuration, MeanDuration, P95Duration time.Duration
+}
+
+// SuccessRate is the percentage of Runs that succeeded.
+func (s Summary) SuccessRate() float64 {
+	if s.Runs == 0 {
+		return 0
+	}
+	return 100 * float64(s.Successes) / float64(s.Runs)
+}
+
+// Summarize summarizes the most recent window entries from es, which must be sorted oldest first.
+func Summarize(es []Entry, window int) Summary {
+	var s Summary
+	for i := len(es) - 1; i >= 0; i-- {
+		if es[i].Succeeded() {
+			s.LastSuccess = es[i].End
+			break
+		}
+		s.ConsecutiveFailures++
+	}
+
+	if window > 0 && len(es) > window {
+		es = es[len(es)-window:]
+	}
+	s.Runs = len(es)
+	if s.Runs == 0 {
+		return s
+	}
+
+	ds := durations(es)
+	var total time.Duration
+	for _, e := range es {
+		if e.Succeeded() {
+			s.Successes++
+		}
+	}
+	for _, d := range ds {
+		total += d
+	}
+	s.MinDuration = ds[0]
+	s.MeanDuration = total / time.Duration(len(ds))
+	s.P95Duration = percentile(ds, 95)
+	return s
+}
+
+// durations returns the durations of es in ascending order.
+func durations(es []Entry) []time.Duration {
+	ds := make([]time.Duration, len(es))
+	for i, e := range es {
+		ds[i] = e.Duration()
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds
+}
+
+// percentile uses the nearest-rank method to find the pth percentile of the sorted durations ds.
+func percentile(ds []time.Duration, p int) time.Duration {
+	i := (p*len(ds)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return ds[i]
+}
+
+// AnomalyThreshold configures when an execution's duration is considered anomalous compared to the cron job's typical duration.
+//
+// The typical duration is the median duration of recent successful executions. By default, an execution is anomalous if its duration is more than MADs median absolute deviations above the median. If Multiplier is set, it's anomalous if its duration is more than Multiplier times the median instead.
+type AnomalyThreshold struct {
+	// Window is the number of recent successful executions used to determine the typical duration.
+	Window int `mapstructure:"window"`
+	// MinSamples is the number of successful executions needed before anything is considered anomalous.
+	MinSamples int     `mapstructure:"min_samples"`
+	MADs       float64 `mapstructure:"mads"`
+	Multiplier float64 `mapstructure:"multiplier"`
+	// MinDeviation is how much longer than the median an execution must take before it's considered anomalous. This keeps very consistent cron jobs from being too sensitive.
+	MinDeviation time.Duration `mapstructure:"min_deviation"`
+}
+
+var DefaultAnomalyThreshold = AnomalyThreshold{
+	Window:       50,
+	MinSamples:   5,
+	MADs:         5,
+	MinDeviation: time.Second,
+}
+
+// Check determines whether an execution that took d is anomalous compared to the history es, which must be sorted oldest first.
+// If it's anomalous, a human readable reason is also returned.
+func (t AnomalyThreshold) Check(es []Entry, d time.Duration) (bool, string) {
+	var succ []Entry
+	for i := len(es) - 1; i >= 0 && (t.Window <= 0 || len(succ) < t.Window); i-- {
+		if es[i].Succeeded() {
+			succ = append(succ, es[i])
+		}
+	}
+	if len(succ) == 0 || len(succ) < t.MinSamples {
+		return false, ""
+	}
+
+	ds := durations(succ)
+	med := median(ds)
+	if d-med < t.MinDeviation {
+		return false, ""
+	}
+
+	if t.Multiplier > 0 {
+		if lim := time.Duration(t.Multiplier * float64(med)); d > lim {
+			return true, fmt.Sprintf("took %s, which is more than %g times the median of %s", d, t.Multiplier, med)
+		}
+		return false, ""
+	}
+
+	devs := make([]time.Duration, len(ds))
+	for i, x := range ds {
+		devs[i] = x - med
+		if devs[i] < 0 {
+			devs[i] = -devs[i]
+		}
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i] < devs[j] })
+	mad := median(devs)
+	if lim := med + time.Duration(t.MADs*float64(mad)); d > lim {
+		return true, fmt.Sprintf("took %s, which is more than %g median absolute deviations (%s) above the median of %s", d, t.MADs, mad, med)
+	}
+	return false, ""
+}
+
+// median finds the median of the sorted durations ds.
+func median(ds []time.Duration) time.Duration {
+	n := len(ds)
+	if n%2 == 1 {
+		return ds[n/2]
+	}
+	return (ds[n/2-1] + ds[n/2]) / 2
+}
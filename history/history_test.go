@@ -0,0 +1,333 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+)
+
+func TestEntries(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	s := NewStore(t.TempDir(), MaxOutputSize(3))
+
+	for i, exitCode := range []int{0, 1, 0, 2} {
+		res := exec.Result{
+			Start:    start.Add(time.Duration(i) * time.Hour),
+			End:      start.Add(time.Duration(i)*time.Hour + time.Minute),
+			Stdout:   []byte("foo"),
+			Stderr:   []byte("barbaz"),
+			ExitCode: exitCode,
+		}
+		if err := s.Append("id", res); err != nil {
+			t.Fatalf("Append(%d) failed: %s", i, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+
+		f Filter
+
+		wantExitCodes []int
+	}{
+		{
+			name: "everything",
+
+			wantExitCodes: []int{0, 1, 0, 2},
+		},
+		{
+			name: "failures only",
+
+			f: Filter{FailuresOnly: true},
+
+			wantExitCodes: []int{1, 2},
+		},
+		{
+			name: "time range",
+
+			f: Filter{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)},
+
+			wantExitCodes: []int{1, 0},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			es, err := s.Entries("id", tc.f)
+			if err != nil {
+				t.Fatalf("Entries failed: %s", err)
+			}
+
+			var got []int
+			for _, e := range es {
+				got = append(got, e.ExitCode)
+				if e.Duration() != time.Minute {
+					t.Errorf("Entry has duration %s, want %s", e.Duration(), time.Minute)
+				}
+				if e.Stdout != "foo" || e.Stderr != "bar" || !e.Truncated {
+					t.Errorf("Entry has unexpected output: %+v", e)
+				}
+			}
+			if diff := cmp.Diff(tc.wantExitCodes, got); diff != "" {
+				t.Errorf("Entries exit codes diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	s := NewStore(t.TempDir(), MaxFileSize(1))
+
+	for i := 0; i < 5; i++ {
+		if err := s.Append("id", exec.Result{ExitCode: i}); err != nil {
+			t.Fatalf("Append(%d) failed: %s", i, err)
+		}
+	}
+
+	es, err := s.Entries("id", Filter{})
+	if err != nil {
+		t.Fatalf("Entries failed: %s", err)
+	}
+	// Every append causes a rotation, so only the last entry should be kept.
+	var got []int
+	for _, e := range es {
+		got = append(got, e.ExitCode)
+	}
+	if diff := cmp.Diff([]int{4}, got); diff != "" {
+		t.Errorf("Entries exit codes diff (-want +got):\n%s", diff)
+	}
+
+	if es, err := s.Entries("other", Filter{}); err != nil {
+		t.Errorf("Entries for unknown cron job failed: %s", err)
+	} else if len(es) != 0 {
+		t.Errorf("Entries for unknown cron job = %v, want none", es)
+	}
+}
+
+func TestLastStart(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	s := NewStore(t.TempDir())
+
+	if got, err := s.LastStart("id"); err != nil {
+		t.Fatalf("LastStart failed: %s", err)
+	} else if !got.IsZero() {
+		t.Errorf("LastStart without history = %s, want the zero value", got)
+	}
+
+	// The execution is still running.
+	if err := s.MarkStart("id", start); err != nil {
+		t.Fatalf("MarkStart failed: %s", err)
+	}
+	if got, err := s.LastStart("id"); err != nil {
+		t.Fatalf("LastStart failed: %s", err)
+	} else if !got.Equal(start) {
+		t.Errorf("LastStart while running = %s, want %s", got, start)
+	}
+
+	// An execution that wasn't marked (e.g. from an older version) is done.
+	later := start.Add(time.Hour)
+	if err := s.Append("id", exec.Result{Start: later, End: later.Add(time.Minute)}); err != nil {
+		t.Fatalf("Append failed: %s", err)
+	}
+	if got, err := s.LastStart("id"); err != nil {
+		t.Fatalf("LastStart failed: %s", err)
+	} else if !got.Equal(later) {
+		t.Errorf("LastStart after Append = %s, want %s", got, later)
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	s := NewStore(dir)
+	for _, id := range []string{"", ".", "..", "../escaped", "a/b", "a..b"} {
+		if err := s.Append(id, exec.Result{}); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Append(%q) = %v, want %v", id, err, ErrInvalidID)
+		}
+		if err := s.MarkStart(id, time.Now()); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("MarkStart(%q) = %v, want %v", id, err, ErrInvalidID)
+		}
+		if _, err := s.LastStart(id); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("LastStart(%q) = %v, want %v", id, err, ErrInvalidID)
+		}
+		if _, err := s.Entries(id, Filter{}); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Entries(%q) = %v, want %v", id, err, ErrInvalidID)
+		}
+	}
+	if es, err := os.ReadDir(filepath.Dir(dir)); err != nil {
+		t.Errorf("Could not read directory: %s", err)
+	} else if len(es) != 0 {
+		t.Errorf("Invalid IDs created files: %v", es)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	entry := func(i int, exitCode int, d time.Duration) Entry {
+		s := start.Add(time.Duration(i) * time.Hour)
+		return Entry{Start: s, End: s.Add(d), ExitCode: exitCode}
+	}
+
+	for _, tc := range []struct {
+		name string
+
+		es     []Entry
+		window int
+
+		want Summary
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "simple",
+
+			es: []Entry{
+				entry(0, 0, 3*time.Second),
+				entry(1, 0, 1*time.Second),
+				entry(2, 1, 2*time.Second),
+				entry(3, 1, 6*time.Second),
+			},
+
+			want: Summary{
+				Runs:                4,
+				Successes:           2,
+				ConsecutiveFailures: 2,
+				LastSuccess:         start.Add(time.Hour + time.Second),
+				MinDuration:         time.Second,
+				MeanDuration:        3 * time.Second,
+				P95Duration:         6 * time.Second,
+			},
+		},
+		{
+			name: "window",
+
+			es: []Entry{
+				entry(0, 0, 100*time.Second),
+				entry(1, 1, 1*time.Second),
+				entry(2, 1, 3*time.Second),
+			},
+			window: 2,
+
+			want: Summary{
+				Runs:                2,
+				Successes:           0,
+				ConsecutiveFailures: 2,
+				LastSuccess:         start.Add(100 * time.Second),
+				MinDuration:         time.Second,
+				MeanDuration:        2 * time.Second,
+				P95Duration:         3 * time.Second,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, Summarize(tc.es, tc.window)); diff != "" {
+				t.Errorf("Summarize mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAnomalyThreshold(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	var es []Entry
+	for i, d := range []time.Duration{100, 110, 90, 105, 95, 2000} {
+		s := start.Add(time.Duration(i) * time.Hour)
+		exitCode := 0
+		if i == 5 {
+			// Failures shouldn't affect the typical duration.
+			exitCode = 1
+		}
+		es = append(es, Entry{Start: s, End: s.Add(d * time.Second), ExitCode: exitCode})
+	}
+
+	for _, tc := range []struct {
+		name string
+
+		t  AnomalyThreshold
+		es []Entry
+		d  time.Duration
+
+		want bool
+	}{
+		{
+			name: "normal",
+
+			t:  DefaultAnomalyThreshold,
+			es: es,
+			d:  120 * time.Second,
+
+			want: false,
+		},
+		{
+			name: "slow",
+
+			t:  DefaultAnomalyThreshold,
+			es: es,
+			d:  200 * time.Second,
+
+			want: true,
+		},
+		{
+			name: "not enough samples",
+
+			t:  DefaultAnomalyThreshold,
+			es: es[:4],
+			d:  200 * time.Second,
+
+			want: false,
+		},
+		{
+			name: "multiplier",
+
+			t:  AnomalyThreshold{Multiplier: 3},
+			es: es,
+			d:  200 * time.Second,
+
+			want: false,
+		},
+		{
+			name: "slow multiplier",
+
+			t:  AnomalyThreshold{Multiplier: 3},
+			es: es,
+			d:  301 * time.Second,
+
+			want: true,
+		},
+		{
+			name: "min deviation",
+
+			t:  AnomalyThreshold{MADs: 5, MinDeviation: 5 * time.Minute},
+			es: es,
+			d:  200 * time.Second,
+
+			want: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, reason := tc.t.Check(tc.es, tc.d)
+			if got != tc.want {
+				t.Errorf("Check(%s) = %t (%q), want %t", tc.d, got, reason, tc.want)
+			}
+			if got && reason == "" {
+				t.Errorf("Check(%s) is anomalous without a reason", tc.d)
+			}
+		})
+	}
+}
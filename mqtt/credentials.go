@@ -0,0 +1,107 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PasswordSource is where the password for the broker comes from. Only one of its fields may be set.
+type PasswordSource struct {
+	// PasswordFile is the path of a file that contains the password.
+	PasswordFile string `mapstructure:"password_file,omitempty"`
+	// PasswordCommand is a shell command that prints the password (e.g. "pass show mqtt").
+	PasswordCommand string `mapstructure:"password_command,omitempty"`
+	// PasswordEnv is the name of an environment variable that contains the password.
+	PasswordEnv string `mapstructure:"password_env,omitempty"`
+	// PasswordCredential is the name of a systemd credential (i.e. a file in $CREDENTIALS_DIRECTORY) that contains the password.
+	PasswordCredential string `mapstructure:"password_credential,omitempty"`
+}
+
+// resolvePassword determines the password for the broker. Trailing newlines are removed from passwords that are read from files or commands.
+func (c Config) resolvePassword(ctx context.Context) (string, error) {
+	var sources []string
+	for n, s := range map[string]string{
+		"password":            c.Password,
+		"password_file":       c.PasswordFile,
+		"password_command":    c.PasswordCommand,
+		"password_env":        c.PasswordEnv,
+		"password_credential": c.PasswordCredential,
+	} {
+		if s != "" {
+			sources = append(sources, n)
+		}
+	}
+	if len(sources) > 1 {
+		return "", fmt.Errorf("the password can only come from one source, but %s are all set", strings.Join(sources, ", "))
+	}
+
+	switch {
+	case c.PasswordFile != "":
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read password file: %w", err)
+		}
+		return trimNewlines(b), nil
+	case c.PasswordCommand != "":
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", c.PasswordCommand)
+		cmd.Stderr = &stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("password command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return trimNewlines(b), nil
+	case c.PasswordEnv != "":
+		p, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("password environment variable $%s is not set", c.PasswordEnv)
+		}
+		return p, nil
+	case c.PasswordCredential != "":
+		dir, ok := os.LookupEnv("CREDENTIALS_DIRECTORY")
+		if !ok {
+			return "", fmt.Errorf("can't read credential %q because $CREDENTIALS_DIRECTORY is not set (see systemd's LoadCredential=)", c.PasswordCredential)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, c.PasswordCredential))
+		if err != nil {
+			return "", fmt.Errorf("could not read credential: %w", err)
+		}
+		return trimNewlines(b), nil
+	}
+	return c.Password, nil
+}
+
+// credentialsProvider returns the username and password for each connection to the broker. The first connection uses pwd, which was already resolved, and later ones resolve the password again. If that fails, the last password that was resolved is used.
+func (c Config) credentialsProvider(pwd string) func() (string, string) {
+	var mut sync.Mutex
+	first := true
+	return func() (string, string) {
+		mut.Lock()
+		defer mut.Unlock()
+		if first {
+			first = false
+			return c.Username, pwd
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.ConnectTimeout)
+		defer cancel()
+		p, err := c.resolvePassword(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("Could not resolve the password for the broker, so the previous one will be used")
+			return c.Username, pwd
+		}
+		pwd = p
+		return c.Username, pwd
+	}
+}
+
+func trimNewlines(b []byte) string {
+	return strings.TrimRight(string(b), "\r\n")
+}
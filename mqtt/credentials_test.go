@@ -0,0 +1,132 @@
+package mqtt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestResolvePassword(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "password")
+	if err := os.WriteFile(f, []byte("from file\n"), 0600); err != nil {
+		t.Fatalf("Could not write password file: %s", err)
+	}
+	t.Setenv("CRON2MQTT_TEST_PASSWORD", "from env")
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	for _, tc := range []struct {
+		name string
+		conf Config
+
+		want    string
+		wantErr *regexp.Regexp
+	}{
+		{
+			name: "plain text",
+			conf: Config{Password: "plain"},
+			want: "plain",
+		},
+		{
+			name: "no password",
+			want: "",
+		},
+		{
+			name: "file",
+			conf: Config{PasswordSource: PasswordSource{PasswordFile: f}},
+			want: "from file",
+		},
+		{
+			name: "command",
+			conf: Config{PasswordSource: PasswordSource{PasswordCommand: "echo from command"}},
+			want: "from command",
+		},
+		{
+			name:    "failed command",
+			conf:    Config{PasswordSource: PasswordSource{PasswordCommand: "echo oops >&2; exit 1"}},
+			wantErr: regexp.MustCompile("password command failed: .*: oops"),
+		},
+		{
+			name: "environment variable",
+			conf: Config{PasswordSource: PasswordSource{PasswordEnv: "CRON2MQTT_TEST_PASSWORD"}},
+			want: "from env",
+		},
+		{
+			name:    "missing environment variable",
+			conf:    Config{PasswordSource: PasswordSource{PasswordEnv: "CRON2MQTT_TEST_MISSING"}},
+			wantErr: regexp.MustCompile(`\$CRON2MQTT_TEST_MISSING is not set`),
+		},
+		{
+			name: "systemd credential",
+			conf: Config{PasswordSource: PasswordSource{PasswordCredential: "password"}},
+			want: "from file",
+		},
+		{
+			name:    "multiple sources",
+			conf:    Config{Password: "plain", PasswordSource: PasswordSource{PasswordEnv: "CRON2MQTT_TEST_PASSWORD"}},
+			wantErr: regexp.MustCompile("only come from one source"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.conf.resolvePassword(context.Background())
+			if err != nil {
+				if tc.wantErr == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Fatalf("resolvePassword failed with %v, wanted to match %v", err, tc.wantErr)
+				}
+				return
+			} else if tc.wantErr != nil {
+				t.Fatalf("resolvePassword did not fail, but expected error message to match %q", tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("resolvePassword() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCredentialsProvider(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "password")
+	write := func(pwd string) {
+		if err := os.WriteFile(f, []byte(pwd+"\n"), 0600); err != nil {
+			t.Fatalf("Could not write password file: %s", err)
+		}
+	}
+	write("old")
+	conf := Config{Username: "user", ConnectTimeout: time.Second, PasswordSource: PasswordSource{PasswordFile: f}}
+	creds := conf.credentialsProvider("old")
+
+	for _, tc := range []struct {
+		name   string
+		change func()
+		want   string
+	}{
+		{
+			name: "first connection",
+			want: "old",
+		},
+		{
+			name:   "rotated",
+			change: func() { write("new") },
+			want:   "new",
+		},
+		{
+			name: "source is broken",
+			change: func() {
+				if err := os.Remove(f); err != nil {
+					t.Fatalf("Could not remove password file: %s", err)
+				}
+			},
+			want: "new",
+		},
+	} {
+		if tc.change != nil {
+			tc.change()
+		}
+		if u, p := creds(); u != "user" || p != tc.want {
+			t.Errorf("%s: credentials = %q, %q, want %q, %q", tc.name, u, p, "user", tc.want)
+		}
+	}
+}
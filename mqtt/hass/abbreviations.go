@@ -97,6 +97,7 @@ var full = map[string]string{
 	"fx_stat_t":           "effect_state_topic",
 	"fx_tpl":              "effect_template",
 	"fx_val_tpl":          "effect_value_template",
+	"evt_typ":             "event_types",
 	"exp_aft":             "expire_after",
 	"fan_mode_cmd_tpl":    "fan_mode_command_template",
 	"fan_mode_cmd_t":      "fan_mode_command_topic",
@@ -267,6 +268,7 @@ var deviceFull = map[string]string{
 	"mdl":  "model",
 	"sw":   "sw_version",
 	"sa":   "suggested_area",
+	// "via_device" doesn't have an abbreviation.
 }
 var deviceAbbr = invert(deviceFull)
 
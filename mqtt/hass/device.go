@@ -0,0 +1,103 @@
+package hass
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+var (
+	osReleasePath = "/etc/os-release"
+)
+
+// hostDevice describes the device that represents the host in home assistant.
+func (p *Plugin) hostDevice(d mqttcron.Device) deviceConfig {
+	mf, mdl := osInfo()
+	return deviceConfig{
+		Name:        d.Hostname,
+		Identifiers: []string{d.ID},
+
+		Manufacturer:     mf,
+		Model:            mdl,
+		SWVersion:        version(),
+		ConfigurationURL: p.opts.ConfigurationURL,
+		SuggestedArea:    p.opts.SuggestedArea,
+	}
+}
+
+// jobDevice describes a device that represents a single cron job in home assistant (see Options.DevicePerJob).
+func (p *Plugin) jobDevice(d mqttcron.Device, cj *mqttcron.CronJob, name string) deviceConfig {
+	host := p.hostDevice(d)
+	dev := host
+	dev.Name = name
+	dev.Identifiers = []string{d.ID + "_" + d.User.Uid + "_" + cj.ID()}
+	dev.ViaDevice = host.Identifiers[0]
+	return dev
+}
+
+// hostEntity is a connectivity sensor for the host, so that the host's device exists in home assistant for the cron jobs' devices to be connected through (see Options.DevicePerJob).
+func (p *Plugin) hostEntity(d mqttcron.Device, nodeID string) binarySensor {
+	return binarySensor{
+		DeviceClass: binarySensorDeviceClasses.connectivity,
+		common: common{
+			BaseTopic:  d.AvailabilityTopic(),
+			StateTopic: "~",
+
+			Device:   p.hostDevice(d),
+			UniqueID: nodeID + "_" + hostEntitySuffix,
+			ObjectID: nodeID + "_" + hostEntitySuffix,
+			Name:     "cron2mqtt on " + d.Hostname,
+
+			Icon: "mdi:server",
+		},
+		PayloadOn:  mqttcron.OnlinePayload,
+		PayloadOff: mqttcron.OfflinePayload,
+	}
+}
+
+// version returns the version of cron2mqtt that's running, if it's known.
+func version() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return bi.Main.Version
+}
+
+// osInfo returns the name of the host's OS (e.g. "Debian GNU/Linux") and a more detailed description (e.g. "Debian GNU/Linux 12 (bookworm)").
+func osInfo() (name string, prettyName string) {
+	name = runtime.GOOS
+	prettyName = runtime.GOOS + "/" + runtime.GOARCH
+
+	f, err := os.Open(osReleasePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			continue
+		}
+		// Values use shell quoting.
+		vs, err := shellquote.Split(v)
+		if err != nil || len(vs) != 1 {
+			continue
+		}
+		switch k {
+		case "NAME":
+			name = vs[0]
+		case "PRETTY_NAME":
+			prettyName = vs[0]
+		}
+	}
+	return
+}
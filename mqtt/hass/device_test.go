@@ -0,0 +1,156 @@
+package hass
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+func TestOSInfo(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		osRelease string // The file won't exist if this is empty.
+
+		wantName       string
+		wantPrettyName string
+	}{
+		{
+			name: "os-release",
+
+			osRelease: `PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+# A comment
+ID=debian
+`,
+
+			wantName:       "Debian GNU/Linux",
+			wantPrettyName: "Debian GNU/Linux 12 (bookworm)",
+		},
+		{
+			name: "missing os-release",
+
+			wantName:       runtime.GOOS,
+			wantPrettyName: runtime.GOOS + "/" + runtime.GOARCH,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "os-release")
+			if tc.osRelease != "" {
+				if err := os.WriteFile(p, []byte(tc.osRelease), 0644); err != nil {
+					t.Fatalf("Could not write os-release: %s", err)
+				}
+			}
+			old := osReleasePath
+			osReleasePath = p
+			defer func() { osReleasePath = old }()
+
+			name, prettyName := osInfo()
+			if name != tc.wantName || prettyName != tc.wantPrettyName {
+				t.Errorf("osInfo() = (%q, %q), want (%q, %q)", name, prettyName, tc.wantName, tc.wantPrettyName)
+			}
+		})
+	}
+}
+
+func TestJobDevice(t *testing.T) {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("mqttcron.CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	nodeID, err := nodeID(d)
+	if err != nil {
+		t.Fatalf("nodeID() yielded an unexpected error: %s", err)
+	}
+	topic := fmt.Sprintf("homeassistant/binary_sensor/%s/id/config", nodeID)
+	hostTopic := fmt.Sprintf("homeassistant/binary_sensor/%s/availability/config", nodeID)
+
+	for _, tc := range []struct {
+		name string
+
+		devicePerJob bool
+
+		wantIdentifiers []string
+		wantViaDevice   string
+	}{
+		{
+			name: "host device",
+
+			wantIdentifiers: []string{d.ID},
+		},
+		{
+			name: "device per job",
+
+			devicePerJob: true,
+
+			wantIdentifiers: []string{d.ID + "_" + d.User.Uid + "_id"},
+			wantViaDevice:   d.ID,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := mqttfake.NewClient()
+			var mut sync.Mutex
+			var payload, hostPayload []byte
+			c.Subscribe(topic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+				mut.Lock()
+				defer mut.Unlock()
+				payload = m.Payload()
+			})
+			c.Subscribe(hostTopic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+				mut.Lock()
+				defer mut.Unlock()
+				hostPayload = m.Payload()
+			})
+
+			opts := DefaultOptions
+			opts.SuggestedArea = "Basement"
+			opts.DevicePerJob = tc.devicePerJob
+			if _, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobPlugins(NewPluginWithOptions(opts))); err != nil {
+				t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+			}
+
+			mut.Lock()
+			defer mut.Unlock()
+			var conf binarySensor
+			if err := json.Unmarshal(payload, &conf); err != nil {
+				t.Fatalf("Could not unmarshal config: %s", err)
+			}
+			if diff := cmp.Diff(tc.wantIdentifiers, conf.Device.Identifiers); diff != "" {
+				t.Errorf("Device identifiers diff (-want +got)\n%s", diff)
+			}
+			if conf.Device.ViaDevice != tc.wantViaDevice {
+				t.Errorf("Device is connected via %q, want %q", conf.Device.ViaDevice, tc.wantViaDevice)
+			}
+			if conf.Device.SuggestedArea != "Basement" {
+				t.Errorf("Device has suggested area %q, want %q", conf.Device.SuggestedArea, "Basement")
+			}
+			if conf.Device.Manufacturer == "" || conf.Device.Model == "" {
+				t.Errorf("Device is missing manufacturer or model: %+v", conf.Device)
+			}
+
+			// The device that the cron job's device is connected through has to exist.
+			if conf.Device.ViaDevice == "" {
+				return
+			}
+			var host binarySensor
+			if err := json.Unmarshal(hostPayload, &host); err != nil {
+				t.Fatalf("Could not unmarshal host config: %s", err)
+			}
+			if diff := cmp.Diff([]string{conf.Device.ViaDevice}, host.Device.Identifiers); diff != "" {
+				t.Errorf("Host device identifiers diff (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -8,8 +8,8 @@ import (
 type common struct {
 	BaseTopic       string `json:"~"`
 	StateTopic      string `json:"state_topic"`
-	ValueTemplate   string `json:"value_template"`
-	AttributesTopic string `json:"json_attributes_topic"`
+	ValueTemplate   string `json:"value_template,omitempty"`
+	AttributesTopic string `json:"json_attributes_topic,omitempty"`
 
 	Device   deviceConfig `json:"device"`
 	UniqueID string       `json:"unique_id"`
@@ -20,6 +20,15 @@ type common struct {
 	Icon        string `json:"icon"`
 
 	ExpireAfter *seconds `json:"expire_after,omitempty"`
+
+	Availability     []availability   `json:"availability,omitempty"`
+	AvailabilityMode availabilityMode `json:"availability_mode,omitempty"`
+}
+
+type availability struct {
+	Topic               string `json:"topic"`
+	PayloadAvailable    string `json:"payload_available,omitempty"`
+	PayloadNotAvailable string `json:"payload_not_available,omitempty"`
 }
 
 // seconds is a time.Duration with only second granularity when marshalling to JSON.
@@ -40,6 +49,14 @@ func (s *seconds) UnmarshalJSON(b []byte) error {
 type deviceConfig struct {
 	Name        string   `json:"name"`
 	Identifiers []string `json:"identifiers"`
+
+	Manufacturer     string `json:"manufacturer,omitempty"`
+	Model            string `json:"model,omitempty"`
+	SWVersion        string `json:"sw_version,omitempty"`
+	ConfigurationURL string `json:"configuration_url,omitempty"`
+	SuggestedArea    string `json:"suggested_area,omitempty"`
+	// ViaDevice is an identifier of the device that this device is connected through.
+	ViaDevice string `json:"via_device,omitempty"`
 }
 
 type binarySensor struct {
@@ -60,11 +77,11 @@ func (s *binarySensor) UnmarshalJSON(b []byte) error {
 }
 
 type sensor struct {
-	DeviceClass sensorDeviceClass `json:"device_class"`
+	DeviceClass sensorDeviceClass `json:"device_class,omitempty"`
 	common
 
-	UnitOfMeasurement unit       `json:"unit_of_measurement"`
-	StateClass        stateClass `json:"state_class"`
+	UnitOfMeasurement unit       `json:"unit_of_measurement,omitempty"`
+	StateClass        stateClass `json:"state_class,omitempty"`
 }
 
 func (s sensor) MarshalJSON() ([]byte, error) {
@@ -76,12 +93,58 @@ func (s *sensor) UnmarshalJSON(b []byte) error {
 	return unmarshalAbbreviatedJSON(b, (*alias)(s))
 }
 
+type switchConfig struct {
+	DeviceClass switchDeviceClass `json:"device_class"`
+	common
+
+	CommandTopic string `json:"command_topic"`
+	PayloadOn    string `json:"payload_on"`
+	PayloadOff   string `json:"payload_off"`
+}
+
+func (s switchConfig) MarshalJSON() ([]byte, error) {
+	type alias switchConfig
+	return marshalAbbreviatedJSON(alias(s))
+}
+func (s *switchConfig) UnmarshalJSON(b []byte) error {
+	type alias switchConfig
+	return unmarshalAbbreviatedJSON(b, (*alias)(s))
+}
+
+type event struct {
+	DeviceClass eventDeviceClass `json:"device_class,omitempty"`
+	common
+
+	EventTypes []string `json:"event_types"`
+}
+
+func (e event) MarshalJSON() ([]byte, error) {
+	type alias event
+	return marshalAbbreviatedJSON(alias(e))
+}
+func (e *event) UnmarshalJSON(b []byte) error {
+	type alias event
+	return unmarshalAbbreviatedJSON(b, (*alias)(e))
+}
+
 type binarySensorDeviceClass string
 type sensorDeviceClass string
+type switchDeviceClass string
+type eventDeviceClass string
 type unit string
 type stateClass string
+type availabilityMode string
 
 var (
+	availabilityModes = struct {
+		all    availabilityMode
+		any    availabilityMode
+		latest availabilityMode
+	}{
+		all:    "all",
+		any:    "any",
+		latest: "latest",
+	}
 	binarySensorDeviceClasses = struct {
 		battery         binarySensorDeviceClass
 		batteryCharging binarySensorDeviceClass
@@ -141,6 +204,15 @@ var (
 		vibration:       "vibration",
 		window:          "window",
 	}
+	eventDeviceClasses = struct {
+		button   eventDeviceClass
+		doorbell eventDeviceClass
+		motion   eventDeviceClass
+	}{
+		button:   "button",
+		doorbell: "doorbell",
+		motion:   "motion",
+	}
 	sensorDeviceClasses = struct {
 		apparentPower            sensorDeviceClass
 		aqi                      sensorDeviceClass
@@ -148,6 +220,8 @@ var (
 		carbonDioxide            sensorDeviceClass
 		carbonMonoxide           sensorDeviceClass
 		current                  sensorDeviceClass
+		dataRate                 sensorDeviceClass
+		dataSize                 sensorDeviceClass
 		date                     sensorDeviceClass
 		duration                 sensorDeviceClass
 		energy                   sensorDeviceClass
@@ -180,6 +254,8 @@ var (
 		carbonDioxide:            "carbon_dioxide",
 		carbonMonoxide:           "carbon_monoxide",
 		current:                  "current",
+		dataRate:                 "data_rate",
+		dataSize:                 "data_size",
 		date:                     "date",
 		duration:                 "duration",
 		energy:                   "energy",
@@ -206,6 +282,13 @@ var (
 		volatileOrganicCompounds: "volatile_organic_compounds",
 		voltage:                  "voltage",
 	}
+	switchDeviceClasses = struct {
+		outlet  switchDeviceClass
+		switch_ switchDeviceClass
+	}{
+		outlet:  "outlet",
+		switch_: "switch",
+	}
 	units = struct {
 		watt         unit
 		kilowatt     unit
@@ -58,3 +58,55 @@ func TestBinarySensor(t *testing.T) {
 		t.Errorf("Expected ExpireAfter to be truncated to seconds, but it wasn't. got %s, want %s", (*time.Duration)(c2.ExpireAfter), (*time.Duration)(c.ExpireAfter))
 	}
 }
+
+func TestSwitchConfig(t *testing.T) {
+	c := switchConfig{
+		common: common{
+			BaseTopic:  "baseTopic",
+			StateTopic: "stateTopic",
+
+			Device: deviceConfig{
+				Name:        "deviceConfigName",
+				Identifiers: []string{"deviceConfigIdentifier"},
+			},
+			UniqueID: "uniqueID",
+			ObjectID: "objectID",
+			Name:     "name",
+
+			Icon: "icon",
+		},
+
+		DeviceClass: switchDeviceClasses.switch_,
+
+		CommandTopic: "commandTopic",
+		PayloadOn:    "payloadOn",
+		PayloadOff:   "payloadOff",
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Could not marshal config: %s", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Could not unmarshal config: %s", err)
+	}
+	for _, k := range []string{"value_template", "json_attributes_topic", "expire_after"} {
+		if _, ok := m[k]; ok {
+			t.Errorf("Config unexpectedly contains %q", k)
+		}
+		if _, ok := m[abbr[k]]; ok {
+			t.Errorf("Config unexpectedly contains %q", abbr[k])
+		}
+	}
+
+	var c2 switchConfig
+	if err := json.Unmarshal(b, &c2); err != nil {
+		t.Fatalf("Could not unmarshal config: %s", err)
+	}
+
+	if diff := cmp.Diff(c, c2, cmp.AllowUnexported(switchConfig{}, common{})); diff != "" {
+		t.Errorf("Config did not roundtrip (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,363 @@
+package hass
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+const (
+	failureState = "failure"
+	successState = "success"
+
+	slowState   = "slow"
+	normalState = "normal"
+
+	missedState     = "missed"
+	onScheduleState = "on_schedule"
+)
+
+// entity is a Home Assistant entity that's created for every cron job.
+type entity struct {
+	// key uniquely identifies the entity. It's used to toggle the entity in Options.Entities.
+	key       string
+	component string
+	// suffix is appended to the cron job's ID to create the entity's unique ID and object ID.
+	suffix string
+	// config generates the entity's discovery config. If ok is false, the entity isn't applicable to the cron job and won't be created.
+	config func(c entityContext) (conf interface{}, ok bool)
+}
+
+// entityContext is everything entities need to know about a cron job to generate their discovery configs.
+type entityContext struct {
+	cj    *mqttcron.CronJob
+	core  *mqttcron.CorePlugin
+	stats *mqttcron.StatsPlugin // nil if the cron job doesn't have a mqttcron.StatsPlugin.
+
+	// suffix is the suffix of the entity that's currently being configured.
+	suffix string
+	dev    deviceConfig
+	name   string
+	// expireAfter is set if we expect to hear from the cron job regularly.
+	expireAfter *seconds
+	// availabilityTopic is the host's mqttcron.Device.AvailabilityTopic.
+	availabilityTopic string
+	eventTopic        string
+}
+
+func (c entityContext) common(baseTopic string, name string, icon string) common {
+	return common{
+		BaseTopic:  baseTopic,
+		StateTopic: "~",
+
+		Device:   c.dev,
+		UniqueID: c.cj.ID() + c.suffix,
+		ObjectID: "cron_job_" + c.cj.ID() + c.suffix,
+		Name:     name,
+
+		Icon: icon,
+
+		Availability: []availability{{
+			Topic:               c.availabilityTopic,
+			PayloadAvailable:    mqttcron.OnlinePayload,
+			PayloadNotAvailable: mqttcron.OfflinePayload,
+		}},
+	}
+}
+
+// requireEnabled makes the entity unavailable while the cron job is disabled, in addition to while the host is offline.
+func (c entityContext) requireEnabled(conf *common) {
+	conf.Availability = append(conf.Availability, availability{
+		Topic:               c.core.AvailabilityTopic,
+		PayloadAvailable:    mqttcron.OnlinePayload,
+		PayloadNotAvailable: mqttcron.OfflinePayload,
+	})
+	conf.AvailabilityMode = availabilityModes.all
+}
+
+// runEntity fires an event every time the cron job runs.
+var runEntity = entity{
+	key:       "run",
+	component: "event",
+	suffix:    "_run",
+	config: func(c entityContext) (interface{}, bool) {
+		return event{
+			common:     c.common(c.eventTopic, "run of "+c.name, "mdi:play-circle"),
+			EventTypes: []string{mqttcron.SucceededState, mqttcron.FailedState, mqttcron.TimedOutState},
+		}, true
+	},
+}
+
+var entities = append([]entity{
+	{
+		key:       "problem",
+		component: "binary_sensor",
+		suffix:    "",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := binarySensor{
+				common:      c.common(c.core.ResultsTopic, c.name, "mdi:robot"),
+				DeviceClass: binarySensorDeviceClasses.problem,
+				// These are inverted on purpose thanks to "device_class": "problem"
+				PayloadOn:  failureState,
+				PayloadOff: successState,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{%% if value_json.%s == 0 %%}%s{%% else %%}%s{%% endif %%}", mqttcron.ExitCodeAttributeName, successState, failureState)
+			conf.AttributesTopic = "~"
+			conf.ExpireAfter = c.expireAfter
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "duration",
+		component: "sensor",
+		suffix:    "_duration",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common:            c.common(c.core.ResultsTopic, "duration of "+c.name, "mdi:timer"),
+				DeviceClass:       sensorDeviceClasses.duration,
+				UnitOfMeasurement: units.milliseconds,
+				StateClass:        stateClasses.measurement,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.DurationAttributeName)
+			conf.AttributesTopic = "~"
+			conf.ExpireAfter = c.expireAfter
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "slow",
+		component: "binary_sensor",
+		suffix:    "_slow",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := binarySensor{
+				common:      c.common(c.core.ResultsTopic, "slow run of "+c.name, "mdi:timer-alert"),
+				DeviceClass: binarySensorDeviceClasses.problem,
+				PayloadOn:   slowState,
+				PayloadOff:  normalState,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{%% if value_json.%s %%}%s{%% else %%}%s{%% endif %%}", mqttcron.AnomalousAttributeName, slowState, normalState)
+			conf.AttributesTopic = "~"
+			conf.ExpireAfter = c.expireAfter
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "missed",
+		component: "binary_sensor",
+		suffix:    "_missed",
+		config: func(c entityContext) (interface{}, bool) {
+			// This intentionally doesn't use expire_after: the missed state is kept up to date by "cron2mqtt check" or the daemon.
+			conf := binarySensor{
+				common:      c.common(c.core.MissedTopic, "missed run of "+c.name, "mdi:calendar-alert"),
+				DeviceClass: binarySensorDeviceClasses.problem,
+				PayloadOn:   missedState,
+				PayloadOff:  onScheduleState,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{%% if value_json.%s %%}%s{%% else %%}%s{%% endif %%}", mqttcron.MissedAttributeName, missedState, onScheduleState)
+			conf.AttributesTopic = "~"
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "enabled",
+		component: "switch",
+		suffix:    "_enabled",
+		config: func(c entityContext) (interface{}, bool) {
+			return switchConfig{
+				common:       c.common(c.core.EnabledTopic, "enable "+c.name, "mdi:calendar-clock"),
+				DeviceClass:  switchDeviceClasses.switch_,
+				CommandTopic: c.core.EnabledCommandTopic,
+				PayloadOn:    mqttcron.EnabledPayload,
+				PayloadOff:   mqttcron.DisabledPayload,
+			}, true
+		},
+	},
+	{
+		key:       "last_start",
+		component: "sensor",
+		suffix:    "_last_start",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common:      c.common(c.core.StartedTopic, "last start of "+c.name, "mdi:clock-start"),
+				DeviceClass: sensorDeviceClasses.timestamp,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.StartTimeAttributeName)
+			return conf, true
+		},
+	},
+	{
+		key:       "last_success",
+		component: "sensor",
+		suffix:    "_last_success",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common:      c.common(c.core.LastSuccessTopic, "last success of "+c.name, "mdi:clock-check"),
+				DeviceClass: sensorDeviceClasses.timestamp,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.EndTimeAttributeName)
+			return conf, true
+		},
+	},
+	{
+		key:       "next_run",
+		component: "sensor",
+		suffix:    "_next_run",
+		config: func(c entityContext) (interface{}, bool) {
+			// The metadata only contains the next execution time if the cron job has a schedule.
+			if c.cj.Schedule == nil {
+				return nil, false
+			}
+			conf := sensor{
+				common:      c.common(c.core.MetadataTopic, "next run of "+c.name, "mdi:clock-outline"),
+				DeviceClass: sensorDeviceClasses.timestamp,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.NextExecutionTimeAttributeName)
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "stdout_size",
+		component: "sensor",
+		suffix:    "_stdout_size",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common:            c.common(c.core.ResultsTopic, "stdout size of "+c.name, "mdi:console"),
+				DeviceClass:       sensorDeviceClasses.dataSize,
+				UnitOfMeasurement: units.bytes,
+				StateClass:        stateClasses.measurement,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.StdoutSizeAttributeName)
+			conf.ExpireAfter = c.expireAfter
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "stderr_size",
+		component: "sensor",
+		suffix:    "_stderr_size",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common:            c.common(c.core.ResultsTopic, "stderr size of "+c.name, "mdi:console-line"),
+				DeviceClass:       sensorDeviceClasses.dataSize,
+				UnitOfMeasurement: units.bytes,
+				StateClass:        stateClasses.measurement,
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.StderrSizeAttributeName)
+			conf.ExpireAfter = c.expireAfter
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	{
+		key:       "exit_code",
+		component: "sensor",
+		suffix:    "_exit_code",
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common: c.common(c.core.ResultsTopic, "exit code of "+c.name, "mdi:numeric"),
+			}
+			conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", mqttcron.ExitCodeAttributeName)
+			conf.ExpireAfter = c.expireAfter
+			c.requireEnabled(&conf.common)
+			return conf, true
+		},
+	},
+	runEntity,
+}, statsEntities()...)
+
+// statsSensor describes a sensor for one of the attributes published by mqttcron.StatsPlugin.
+type statsSensor struct {
+	key       string
+	name      string
+	attribute string
+	icon      string
+
+	deviceClass sensorDeviceClass
+	unit        unit
+}
+
+var statsSensors = []statsSensor{
+	{"success_rate", "success rate of ", mqttcron.SuccessRateAttributeName, "mdi:percent", "", units.percentage},
+	{"consecutive_failures", "consecutive failures of ", mqttcron.ConsecutiveFailuresAttributeName, "mdi:alert-circle", "", ""},
+	{"min_duration", "minimum duration of ", mqttcron.MinDurationAttributeName, "mdi:timer", sensorDeviceClasses.duration, units.milliseconds},
+	{"mean_duration", "mean duration of ", mqttcron.MeanDurationAttributeName, "mdi:timer", sensorDeviceClasses.duration, units.milliseconds},
+	{"p95_duration", "95th percentile duration of ", mqttcron.P95DurationAttributeName, "mdi:timer", sensorDeviceClasses.duration, units.milliseconds},
+	// This is a timestamp, so that Home Assistant shows how long ago it was, instead of the age when the stats were published.
+	{"since_last_success", "time since last success of ", mqttcron.LastSuccessTimeAttributeName, "mdi:timer-sand", sensorDeviceClasses.timestamp, ""},
+}
+
+func statsEntities() []entity {
+	var es []entity
+	for _, s := range statsSensors {
+		s := s
+		es = append(es, entity{
+			key:       s.key,
+			component: "sensor",
+			suffix:    "_" + s.key,
+			config: func(c entityContext) (interface{}, bool) {
+				if c.stats == nil {
+					return nil, false
+				}
+				conf := sensor{
+					common:            c.common(c.stats.StatsTopic, s.name+c.name, s.icon),
+					DeviceClass:       s.deviceClass,
+					UnitOfMeasurement: s.unit,
+				}
+				// Timestamps can't be measurements.
+				if s.deviceClass != sensorDeviceClasses.timestamp {
+					conf.StateClass = stateClasses.measurement
+				}
+				conf.ValueTemplate = fmt.Sprintf("{{value_json.%s}}", s.attribute)
+				return conf, true
+			},
+		})
+	}
+	return es
+}
+
+// valueEntityPrefix is the prefix of the keys of entities for values extracted by mqttcron.Extractors.
+const valueEntityPrefix = "value_"
+
+// valueEntity creates a sensor for a value that's extracted from the cron job's stdout.
+func valueEntity(e mqttcron.Extractor) (entity, error) {
+	if e.DeviceClass != "" && !enumContains(sensorDeviceClasses, e.DeviceClass) {
+		return entity{}, fmt.Errorf("extractor %q has an unknown device class %q", e.Name, e.DeviceClass)
+	}
+	if e.StateClass != "" && !enumContains(stateClasses, e.StateClass) {
+		return entity{}, fmt.Errorf("extractor %q has an unknown state class %q", e.Name, e.StateClass)
+	}
+	return entity{
+		key:       valueEntityPrefix + e.Name,
+		component: "sensor",
+		suffix:    "_" + valueEntityPrefix + e.Name,
+		config: func(c entityContext) (interface{}, bool) {
+			conf := sensor{
+				common:            c.common(c.core.ValuesTopic, e.Name+" of "+c.name, "mdi:text-search"),
+				DeviceClass:       sensorDeviceClass(e.DeviceClass),
+				UnitOfMeasurement: unit(e.Unit),
+				StateClass:        stateClass(e.StateClass),
+			}
+			// Extractor names can contain hyphens, which Jinja would treat as subtraction with dot notation.
+			conf.ValueTemplate = fmt.Sprintf("{{value_json['%s']}}", e.Name)
+			return conf, true
+		},
+	}, nil
+}
+
+// enumContains reports whether one of the fields of enum (e.g. sensorDeviceClasses) is v.
+func enumContains(enum interface{}, v string) bool {
+	ev := reflect.ValueOf(enum)
+	for i := 0; i < ev.NumField(); i++ {
+		if ev.Field(i).String() == v {
+			return true
+		}
+	}
+	return false
+}
@@ -6,38 +6,84 @@ import (
 	"strings"
 	"time"
 
-	"github.com/kballard/go-shellquote"
-
 	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
 )
 
-const (
-	failureState = "failure"
-	successState = "success"
-)
-
 var (
 	now = time.Now
 )
 
+// Options configures Plugin.
+type Options struct {
+	// DiscoveryPrefix is the prefix of home assistant's MQTT discovery topics.
+	DiscoveryPrefix string `mapstructure:"discovery_prefix"`
+	// Entities toggles individual entities by key (e.g. "duration" or "success_rate"). Entities are enabled unless they're set to false.
+	Entities map[string]bool `mapstructure:"entities"`
+
+	// ConfigurationURL and SuggestedArea are added to home assistant devices.
+	ConfigurationURL string `mapstructure:"configuration_url"`
+	SuggestedArea    string `mapstructure:"suggested_area"`
+	// DevicePerJob creates a separate device for each cron job, connected via the host's device, so that cron jobs can be assigned to different areas.
+	DevicePerJob bool `mapstructure:"device_per_job"`
+}
+
+// DefaultOptions are the options used by NewPlugin.
+var DefaultOptions = Options{
+	DiscoveryPrefix: "homeassistant",
+}
+
+func init() {
+	mqttcron.RegisterPlugin("hass", true, DefaultOptions, NewPluginWithOptions)
+}
+
 // Plugin provides home assistant specific funcionality to mqttcron.CronJob.
 type Plugin struct {
 	mqttcron.NopPlugin
-	discoveryPrefix string
+	opts Options
+
+	// entities are all of the entities for the cron job, including sensors for its mqttcron.Extractors.
+	entities     []entity
+	configTopics []string // Parallel to entities.
+	// eventTopic receives an event every time the cron job runs (see runEvent).
+	eventTopic string
+	// sensorTopics is a topic filter for the discovery topics of all sensors on the host, including ones for extractors that no longer exist.
+	sensorTopics string
+	// hostConfigTopic is the discovery topic of hostEntity, which is shared by all of the host's cron jobs. It's only set with Options.DevicePerJob.
+	hostConfigTopic string
+}
 
-	problemConfigTopic  string
-	durationConfigTopic string
+// hostEntitySuffix is the suffix of hostEntity's unique ID and object ID. Cron jobs can't be named after it, since mqttcron.ValidateID reserves it.
+const hostEntitySuffix = "availability"
+
+// runEvent is published to a home assistant event entity every time the cron job runs.
+type runEvent struct {
+	EventType string    `json:"event_type"`
+	ExitCode  int       `json:"exit_code"`
+	Duration  int64     `json:"duration_ms"`
+	StartTime time.Time `json:"start_time"`
 }
 
 func NewPlugin() mqttcron.Plugin {
-	return &Plugin{
-		discoveryPrefix: "homeassistant", // TODO: Make this configurable.
-	}
+	return NewPluginWithOptions(DefaultOptions)
+}
+
+func NewPluginWithOptions(opts Options) mqttcron.Plugin {
+	return &Plugin{opts: opts}
 }
 
 func (p *Plugin) Init(cj *mqttcron.CronJob, reg mqttcron.TopicRegister) error {
+	if err := mqttcron.ValidateTopicComponent(p.opts.DiscoveryPrefix); err != nil {
+		return fmt.Errorf("invalid discovery prefix: %w", err)
+	}
+	for k := range p.opts.Entities {
+		if !knownEntity(k) && !strings.HasPrefix(k, valueEntityPrefix) {
+			return fmt.Errorf("unknown home assistant entity %q", k)
+		}
+	}
+
 	d, err := mqttcron.CurrentDevice()
 	if err != nil {
 		return err
@@ -47,12 +93,28 @@ func (p *Plugin) Init(cj *mqttcron.CronJob, reg mqttcron.TopicRegister) error {
 		return err
 	}
 
-	// TODO: Add more sensors.
-	//   - stdout/stderr size?
-	p.problemConfigTopic = fmt.Sprintf("%s/binary_sensor/%s/%s/config", p.discoveryPrefix, nodeID, cj.ID())
-	p.durationConfigTopic = fmt.Sprintf("%s/sensor/%s/%s_duration/config", p.discoveryPrefix, nodeID, cj.ID())
-	reg.RegisterTopic(p.problemConfigTopic, mqtt.Retain)
-	reg.RegisterTopic(p.durationConfigTopic, mqtt.Retain)
+	p.eventTopic = reg.RegisterSuffix("events")
+	// Register every entity's topic, even if it's disabled, so that they're cleaned up by mqttcron.CronJob.Unpublish.
+	p.entities = append([]entity(nil), entities...)
+	for _, e := range cj.Extractors() {
+		ve, err := valueEntity(e)
+		if err != nil {
+			return err
+		}
+		p.entities = append(p.entities, ve)
+	}
+	p.sensorTopics = fmt.Sprintf("%s/sensor/%s/#", p.opts.DiscoveryPrefix, nodeID)
+	p.configTopics = nil
+	for _, e := range p.entities {
+		t := fmt.Sprintf("%s/%s/%s/%s%s/config", p.opts.DiscoveryPrefix, e.component, nodeID, cj.ID(), e.suffix)
+		reg.RegisterTopic(t, mqtt.Retain)
+		p.configTopics = append(p.configTopics, t)
+	}
+	p.hostConfigTopic = ""
+	if p.opts.DevicePerJob {
+		p.hostConfigTopic = fmt.Sprintf("%s/binary_sensor/%s/%s/config", p.opts.DiscoveryPrefix, nodeID, hostEntitySuffix)
+		reg.RegisterSharedTopic(p.hostConfigTopic)
+	}
 	return nil
 }
 
@@ -61,111 +123,112 @@ func (p *Plugin) OnCreate(cj *mqttcron.CronJob, pub mqttcron.Publisher) error {
 	if err != nil {
 		return err
 	}
-	var cp *mqttcron.CorePlugin
-	if !cj.Plugin(&cp) {
+	c := entityContext{
+		cj:                cj,
+		dev:               p.hostDevice(d),
+		name:              cj.Name(),
+		availabilityTopic: d.AvailabilityTopic(),
+		eventTopic:        p.eventTopic,
+	}
+	if p.opts.DevicePerJob {
+		c.dev = p.jobDevice(d, cj, c.name)
+	}
+	if !cj.Plugin(&c.core) {
 		return fmt.Errorf("could not retrieve mqttcron.CorePlugin")
 	}
-
-	dev := deviceConfig{
-		Name:        d.Hostname,
-		Identifiers: []string{d.ID},
+	cj.Plugin(&c.stats) // c.stats stays nil if there's no mqttcron.StatsPlugin.
+	// Disabled cron jobs won't run, so we shouldn't expect to hear from them.
+	if cj.Schedule != nil && (cj.Enabled == nil || *cj.Enabled) {
+		exp := seconds(expireAfter(cj.Schedule))
+		c.expireAfter = &exp
+	}
+
+	var fs []func() error
+	for i, e := range p.entities {
+		t := p.configTopics[i]
+		// An empty retained message deletes the entity in case it was previously enabled.
+		var b []byte
+		if p.enabled(e) {
+			c.suffix = e.suffix
+			if conf, ok := e.config(c); ok {
+				b, err = json.Marshal(conf)
+				if err != nil {
+					return fmt.Errorf("could not marshal discovery config: %w", err)
+				}
+			}
+		}
+		fs = append(fs, func() error { return pub.Publish(t, mqtt.QoSExactlyOnce, mqtt.Retain, b) })
 	}
-	name := fmt.Sprintf("[%s@%s] %s", d.User.Username, d.Hostname, commandName(cj.ID(), cj.Command))
-	problemConf := binarySensor{
-		common: common{
-			BaseTopic:       cp.ResultsTopic,
-			StateTopic:      "~",
-			ValueTemplate:   fmt.Sprintf("{%% if value_json.%s == 0 %%}%s{%% else %%}%s{%% endif %%}", mqttcron.ExitCodeAttributeName, successState, failureState),
-			AttributesTopic: "~",
-
-			Device:   dev,
-			UniqueID: cj.ID(),
-			ObjectID: "cron_job_" + cj.ID(),
-			Name:     name,
-
-			Icon: "mdi:robot",
-		},
-
-		DeviceClass: binarySensorDeviceClasses.problem,
-		// These are inverted on purpose thanks to "device_class": "problem"
-		PayloadOn:  failureState,
-		PayloadOff: successState,
+	if p.hostConfigTopic != "" {
+		nodeID, err := nodeID(d)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(p.hostEntity(d, nodeID))
+		if err != nil {
+			return fmt.Errorf("could not marshal discovery config: %w", err)
+		}
+		fs = append(fs, func() error { return pub.Publish(p.hostConfigTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b) })
 	}
-	durationConf := sensor{
-		common: common{
-			BaseTopic:       cp.ResultsTopic,
-			StateTopic:      "~",
-			ValueTemplate:   fmt.Sprintf("{{value_json.%s}}", mqttcron.DurationAttributeName),
-			AttributesTopic: "~",
-
-			Device:   dev,
-			UniqueID: cj.ID() + "_duration",
-			ObjectID: fmt.Sprintf("cron_job_%s_duration", cj.ID()),
-			Name:     "duration of " + name,
-
-			Icon: "mdi:timer",
-		},
+	return mqttcron.MultiPublish(fs...)
+}
 
-		DeviceClass:       sensorDeviceClasses.duration,
-		UnitOfMeasurement: units.milliseconds,
-		StateClass:        stateClasses.measurement,
-	}
-	if cj.Schedule != nil {
-		exp := seconds(expireAfter(cj.Schedule))
-		problemConf.ExpireAfter = &exp
-		durationConf.ExpireAfter = &exp
+func (p *Plugin) PublishResult(cj *mqttcron.CronJob, pub mqttcron.Publisher, res exec.Result) error {
+	if !p.enabled(runEntity) {
+		return nil
 	}
-	pc, err := json.Marshal(problemConf)
-	if err != nil {
-		return fmt.Errorf("could not marshal discovery config: %w", err)
+	e := runEvent{
+		EventType: mqttcron.ResultState(res),
+		ExitCode:  res.ExitCode,
+		Duration:  res.End.Sub(res.Start).Milliseconds(),
+		StartTime: res.Start,
 	}
-	dc, err := json.Marshal(durationConf)
+	b, err := json.Marshal(e)
 	if err != nil {
-		return fmt.Errorf("could not marshal discovery config: %w", err)
+		return fmt.Errorf("could not marshal event: %w", err)
 	}
-	return mqttcron.MultiPublish(
-		func() error { return pub.Publish(p.problemConfigTopic, mqtt.QoSExactlyOnce, mqtt.Retain, pc) },
-		func() error { return pub.Publish(p.durationConfigTopic, mqtt.QoSExactlyOnce, mqtt.Retain, dc) })
+	return pub.Publish(p.eventTopic, mqtt.QoSExactlyOnce, mqtt.DoNotRetain, b)
 }
 
-func nodeID(d mqttcron.Device) (string, error) {
-	id := fmt.Sprintf("cron2mqtt_%s_%s", d.ID, d.User.Uid)
-	if err := mqttcron.ValidateTopicComponent(id); err != nil {
-		return "", fmt.Errorf("calculated node ID is invalid: %w", err)
+// OwnedTopics implements mqttcron.TopicOwnerPlugin, so that the sensors of extractors that were removed are deleted.
+func (p *Plugin) OwnedTopics(cj *mqttcron.CronJob) (string, func(mqtt.Message) bool) {
+	var core *mqttcron.CorePlugin
+	cj.Plugin(&core)
+	return p.sensorTopics, func(m mqtt.Message) bool {
+		// Cron job IDs and extractor names can both contain underscores, so the topic alone doesn't say which cron job a sensor belongs to.
+		var conf sensor
+		if err := json.Unmarshal(m.Payload(), &conf); err != nil {
+			return false
+		}
+		return conf.BaseTopic == core.ValuesTopic
 	}
-	return id, nil
 }
 
-func commandName(id string, c *cron.Command) string {
-	if c == nil {
-		return id
-	}
-	args, ok := c.Args()
-	if !ok {
-		return c.String()
-	}
+// RepublishTopic implements mqttcron.RepublishPlugin. Home assistant publishes its birth message to this topic when it starts.
+func (p *Plugin) RepublishTopic() (string, string) {
+	return p.opts.DiscoveryPrefix + "/status", "online"
+}
 
-	var shArgs []string
-	for i, arg := range args {
-		if arg == "--" {
-			shArgs = args[i+1:]
-			break
-		}
-		if arg == id && i < len(args)-1 {
-			shArgs = []string{}
-			continue
-		}
-		if shArgs == nil || strings.HasPrefix(arg, "-") {
-			continue
+func (p *Plugin) enabled(e entity) bool {
+	enabled, ok := p.opts.Entities[e.key]
+	return !ok || enabled
+}
+
+func knownEntity(key string) bool {
+	for _, e := range entities {
+		if e.key == key {
+			return true
 		}
-		shArgs = append(shArgs, arg)
 	}
-	if len(shArgs) > 0 {
-		if sp, err := shellquote.Split(strings.Join(shArgs, " ")); err == nil {
-			return strings.Join(sp, " ")
-		}
+	return false
+}
+
+func nodeID(d mqttcron.Device) (string, error) {
+	id := fmt.Sprintf("cron2mqtt_%s_%s", d.ID, d.User.Uid)
+	if err := mqttcron.ValidateTopicComponent(id); err != nil {
+		return "", fmt.Errorf("calculated node ID is invalid: %w", err)
 	}
-	return strings.Join(args, " ")
+	return id, nil
 }
 
 func expireAfter(s *cron.Schedule) time.Duration {
@@ -1,144 +1,481 @@
 package hass
 
 import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
 )
 
-func TestCommandName(t *testing.T) {
+func TestExpireAfter(t *testing.T) {
+	topOfTheHour, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		now   time.Time
+		sched string
+		want  time.Duration
+	}{
+		{
+			name:  "simple",
+			now:   topOfTheHour,
+			sched: "0 * * * * ",
+			want:  time.Hour + 60*time.Second,
+		},
+		{
+			name:  "cron job more frequently than default delay",
+			now:   topOfTheHour,
+			sched: "* * * * * ",
+			want:  time.Minute + 30*time.Second,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := cron.NewSchedule(tc.sched)
+			if err != nil {
+				t.Fatalf("cron.NewSchedule(%q) yielded an unexpected error: %s", tc.sched, err)
+			}
+			now = func() time.Time { return tc.now }
+			if got := expireAfter(&s); got != tc.want {
+				t.Errorf("expireAfter(%q) = %s, want %s", tc.sched, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPluginOptions(t *testing.T) {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("mqttcron.CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	nodeID, err := nodeID(d)
+	if err != nil {
+		t.Fatalf("nodeID() yielded an unexpected error: %s", err)
+	}
+
 	for _, tc := range []struct {
 		name string
 
-		id  string
-		cmd string
+		opts    Options
+		plugins []mqttcron.Plugin
+		wantErr *regexp.Regexp
 
-		want string
+		wantCreated []string
+		wantDeleted []string
 	}{
 		{
-			name: "simple",
+			name: "defaults",
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd echo true",
+			opts: DefaultOptions,
 
-			want: "echo true",
+			wantCreated: []string{
+				"homeassistant/binary_sensor/%s/id/config",
+				"homeassistant/sensor/%s/id_duration/config",
+				"homeassistant/switch/%s/id_enabled/config",
+			},
+			wantDeleted: []string{
+				"homeassistant/sensor/%s/id_success_rate/config",
+			},
 		},
 		{
-			name: "simple with flags",
+			name: "discovery prefix",
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd -vvv echo true",
+			opts: Options{DiscoveryPrefix: "custom"},
 
-			want: "echo true",
+			wantCreated: []string{
+				"custom/binary_sensor/%s/id/config",
+				"custom/sensor/%s/id_duration/config",
+			},
 		},
 		{
-			name: "ambiguous flag",
+			name: "disabled entities",
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd echo true -vvv",
+			opts:    Options{DiscoveryPrefix: "homeassistant", Entities: map[string]bool{"duration": false, "enabled": true, "success_rate": false}},
+			plugins: []mqttcron.Plugin{mqttcron.NewStatsPlugin(30)},
 
-			want: "echo true",
+			wantCreated: []string{
+				"homeassistant/binary_sensor/%s/id/config",
+				"homeassistant/switch/%s/id_enabled/config",
+				"homeassistant/sensor/%s/id_p95_duration/config",
+			},
+			wantDeleted: []string{
+				"homeassistant/sensor/%s/id_duration/config",
+				"homeassistant/sensor/%s/id_success_rate/config",
+			},
 		},
 		{
-			name: "dashdash",
-
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd -- echo true",
+			name: "unknown entity",
 
-			want: "echo true",
+			opts:    Options{DiscoveryPrefix: "homeassistant", Entities: map[string]bool{"foo": false}},
+			wantErr: regexp.MustCompile(regexp.QuoteMeta(`unknown home assistant entity "foo"`)),
 		},
 		{
-			name: "dashdash with flags",
+			name: "invalid discovery prefix",
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd -vvv -- echo -n true",
-
-			want: "echo -n true",
+			opts:    Options{DiscoveryPrefix: "home/assistant"},
+			wantErr: regexp.MustCompile("invalid discovery prefix"),
 		},
-		{
-			name: "quoted command",
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := mqttfake.NewClient()
+			var mut sync.Mutex
+			payloads := make(map[string][]byte)
+			for _, ts := range [][]string{tc.wantCreated, tc.wantDeleted} {
+				for _, topic := range ts {
+					c.Subscribe(fmt.Sprintf(topic, nodeID), 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+						mut.Lock()
+						defer mut.Unlock()
+						payloads[m.Topic()] = m.Payload()
+					})
+				}
+			}
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd 'echo true'",
+			ps := append([]mqttcron.Plugin{NewPluginWithOptions(tc.opts)}, tc.plugins...)
+			_, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobPlugins(ps...))
+			if err != nil {
+				if tc.wantErr == nil {
+					t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+				} else if !tc.wantErr.MatchString(err.Error()) {
+					t.Fatalf("NewCronJob failed with %v, wanted to match %q", err, tc.wantErr)
+				}
+				return
+			} else if tc.wantErr != nil {
+				t.Fatalf("NewCronJob did not fail, but expected error message to match %q", tc.wantErr)
+			}
 
-			want: "echo true",
-		},
-		{
-			name: "quoted command with flags",
+			mut.Lock()
+			defer mut.Unlock()
+			for _, topic := range tc.wantCreated {
+				topic = fmt.Sprintf(topic, nodeID)
+				if p, ok := payloads[topic]; !ok || len(p) == 0 {
+					t.Errorf("Discovery config wasn't published to %s", topic)
+				}
+			}
+			for _, topic := range tc.wantDeleted {
+				topic = fmt.Sprintf(topic, nodeID)
+				if p, ok := payloads[topic]; !ok || len(p) != 0 {
+					t.Errorf("Discovery config at %s wasn't deleted, got %q", topic, p)
+				}
+			}
+		})
+	}
+}
+
+func TestPluginAvailability(t *testing.T) {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("mqttcron.CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	nodeID, err := nodeID(d)
+	if err != nil {
+		t.Fatalf("nodeID() yielded an unexpected error: %s", err)
+	}
+	problemTopic := fmt.Sprintf("homeassistant/binary_sensor/%s/id/config", nodeID)
+	enabledTopic := fmt.Sprintf("homeassistant/switch/%s/id_enabled/config", nodeID)
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd -vvv 'echo -n true'",
+	c := mqttfake.NewClient()
+	var mut sync.Mutex
+	payloads := make(map[string][]byte)
+	for _, topic := range []string{problemTopic, enabledTopic} {
+		c.Subscribe(topic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+			mut.Lock()
+			defer mut.Unlock()
+			payloads[m.Topic()] = m.Payload()
+		})
+	}
+	cj, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobPlugins(NewPlugin()))
+	if err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	var cp *mqttcron.CorePlugin
+	if !cj.Plugin(&cp) {
+		t.Fatalf("Could not find mqttcron.CorePlugin")
+	}
 
-			want: "echo -n true",
-		},
-		{
-			name: "unsplittable",
+	mut.Lock()
+	defer mut.Unlock()
+	host := availability{Topic: d.AvailabilityTopic(), PayloadAvailable: mqttcron.OnlinePayload, PayloadNotAvailable: mqttcron.OfflinePayload}
+	job := availability{Topic: cp.AvailabilityTopic, PayloadAvailable: mqttcron.OnlinePayload, PayloadNotAvailable: mqttcron.OfflinePayload}
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd 'echo true",
+	var problem binarySensor
+	if err := json.Unmarshal(payloads[problemTopic], &problem); err != nil {
+		t.Fatalf("Could not unmarshal problem config: %s", err)
+	}
+	if diff := cmp.Diff([]availability{host, job}, problem.Availability); diff != "" {
+		t.Errorf("Problem availability diff (-want +got)\n%s", diff)
+	}
+	if problem.AvailabilityMode != availabilityModes.all {
+		t.Errorf("Problem availability mode = %q, want %q", problem.AvailabilityMode, availabilityModes.all)
+	}
 
-			want: "cron2mqtt exec abcd 'echo true",
-		},
-		{
-			name: "missing command",
+	// The cron job can be enabled from home assistant while it's disabled.
+	var enabled switchConfig
+	if err := json.Unmarshal(payloads[enabledTopic], &enabled); err != nil {
+		t.Fatalf("Could not unmarshal switch config: %s", err)
+	}
+	if diff := cmp.Diff([]availability{host}, enabled.Availability); diff != "" {
+		t.Errorf("Switch availability diff (-want +got)\n%s", diff)
+	}
+}
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd",
+var valueJSONRegexp = regexp.MustCompile(`value_json(?:\.(\w+)|\['([\w-]+)'\])`)
 
-			want: "cron2mqtt exec abcd",
+// TestValueTemplates checks that the value templates of each entity refer to attributes that are actually published by mqttcron.CorePlugin.
+func TestValueTemplates(t *testing.T) {
+	s, err := cron.NewSchedule("0 * * * *")
+	if err != nil {
+		t.Fatalf("cron.NewSchedule yielded an unexpected error: %s", err)
+	}
+	opts := []mqttcron.CronJobOption{
+		func(cj *mqttcron.CronJob) {
+			cj.Schedule = &s
+			cj.Enabled = new.Ptr(true)
 		},
-		{
-			name: "dashdash missing command",
+		mqttcron.CronJobExtractors(mqttcron.Extractor{Name: "backed-up-files", Regex: `(\d+) files`, StateClass: string(stateClasses.measurement)}),
+		mqttcron.CronJobPlugins(NewPlugin()),
+	}
 
-			id:  "abcd",
-			cmd: "cron2mqtt exec abcd --",
+	c := mqttfake.NewClient()
+	cj, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), opts...)
+	if err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	var p *Plugin
+	if !cj.Plugin(&p) {
+		t.Fatalf("Could not find hass.Plugin")
+	}
 
-			want: "cron2mqtt exec abcd --",
-		},
-	} {
-		t.Run(tc.name, func(t *testing.T) {
-			cmd := cron.NewCommand(tc.cmd)
-			if got := commandName(tc.id, cmd); got != tc.want {
-				t.Errorf("commandName(%q, %q) = %q, want %q", tc.id, tc.cmd, got, tc.want)
+	var mut sync.Mutex
+	payloads := make(map[string][]byte)
+	record := func(_ mqttpaho.Client, m mqttpaho.Message) {
+		mut.Lock()
+		defer mut.Unlock()
+		payloads[m.Topic()] = m.Payload()
+	}
+	for _, topic := range p.configTopics {
+		c.Subscribe(topic, 0, record)
+	}
+	var cp *mqttcron.CorePlugin
+	if !cj.Plugin(&cp) {
+		t.Fatalf("Could not find mqttcron.CorePlugin")
+	}
+	for _, topic := range []string{cp.MetadataTopic, cp.ResultsTopic, cp.StartedTopic, cp.LastSuccessTopic, cp.EnabledTopic, cp.MissedTopic, cp.ValuesTopic, p.eventTopic} {
+		c.Subscribe(topic, 0, record)
+	}
+
+	// Re-create the cron job now that we're listening.
+	cj, err = mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), opts...)
+	if err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	start := time.Now()
+	if err := cj.PublishResult(exec.Result{Start: start, End: start.Add(time.Second), Stdout: []byte("Backed up 12 files"), Stderr: []byte("bar")}); err != nil {
+		t.Fatalf("PublishResult failed: %s", err)
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	for i, e := range p.entities {
+		t.Run(e.key, func(t *testing.T) {
+			b := payloads[p.configTopics[i]]
+			if len(b) == 0 {
+				t.Skip("Entity is not applicable")
+			}
+			var conf map[string]interface{}
+			if err := json.Unmarshal(b, &conf); err != nil {
+				t.Fatalf("Could not unmarshal config: %s", err)
+			}
+			expandConfig(conf)
+
+			stateTopic := strings.ReplaceAll(conf["state_topic"].(string), "~", conf["~"].(string))
+			state, ok := payloads[stateTopic]
+			if !ok {
+				t.Fatalf("Nothing was published to state topic %s", stateTopic)
+			}
+			tpl, _ := conf["value_template"].(string)
+			if ets, ok := conf["event_types"].([]interface{}); ok {
+				var ev map[string]interface{}
+				if err := json.Unmarshal(state, &ev); err != nil {
+					t.Fatalf("Could not unmarshal event %s: %s", state, err)
+				}
+				for _, et := range ets {
+					if et == ev["event_type"] {
+						return
+					}
+				}
+				t.Errorf("Event type %v isn't one of %v", ev["event_type"], ets)
+				return
+			}
+			if tpl == "" {
+				if _, ok := conf["command_topic"]; !ok && string(state) == "" {
+					t.Errorf("Empty state published to %s", stateTopic)
+				}
+				return
+			}
+
+			var v map[string]interface{}
+			if err := json.Unmarshal(state, &v); err != nil {
+				t.Fatalf("Could not unmarshal state %s: %s", state, err)
+			}
+			ms := valueJSONRegexp.FindAllStringSubmatch(tpl, -1)
+			if len(ms) == 0 {
+				t.Fatalf("Value template %q doesn't use value_json", tpl)
+			}
+			for _, m := range ms {
+				name := m[1] + m[2]
+				attr, ok := v[name]
+				if !ok {
+					t.Errorf("Value template %q refers to %q, which isn't in %s", tpl, name, state)
+					continue
+				}
+				switch {
+				case conf["device_class"] == string(sensorDeviceClasses.timestamp):
+					if s, ok := attr.(string); !ok {
+						t.Errorf("%q = %v, want a timestamp", name, attr)
+					} else if _, err := time.Parse(time.RFC3339, s); err != nil {
+						t.Errorf("%q = %q, want a timestamp: %s", name, s, err)
+					}
+				case conf["unit_of_measurement"] != nil || conf["state_class"] != nil:
+					if _, ok := attr.(float64); !ok {
+						t.Errorf("%q = %v, want a number", name, attr)
+					}
+				}
 			}
 		})
 	}
 }
 
-func TestExpireAfter(t *testing.T) {
-	topOfTheHour, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+func TestRemovedExtractor(t *testing.T) {
+	c := mqttfake.NewClient()
+	files := mqttcron.Extractor{Name: "files", Regex: `(\d+) files`}
+	cj, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobExtractors(files), mqttcron.CronJobPlugins(NewPlugin()))
+	if err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	var p *Plugin
+	if !cj.Plugin(&p) {
+		t.Fatalf("Could not find hass.Plugin")
+	}
+	topic := p.configTopics[len(p.configTopics)-1]
+	if _, ok := c.Retained()[topic]; !ok {
+		t.Fatalf("Sensor for extractor wasn't published to %s", topic)
+	}
+	// Another cron job's sensors shouldn't be affected.
+	if _, err := mqttcron.NewCronJob("other", mqtt.NewClientForTesting(c), mqttcron.CronJobExtractors(files), mqttcron.CronJobPlugins(NewPlugin())); err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	otherTopic := strings.Replace(topic, "/id_", "/other_", 1)
+
+	// Executions don't clear stale sensors, since that waits on the broker.
+	if _, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobPlugins(NewPlugin())); err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	if _, ok := c.Retained()[topic]; !ok {
+		t.Errorf("Sensor for removed extractor was deleted from %s without CronJobClearStaleTopics", topic)
+	}
+
+	if _, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobPlugins(NewPlugin()), mqttcron.CronJobClearStaleTopics()); err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	if _, ok := c.Retained()[topic]; ok {
+		t.Errorf("Sensor for removed extractor is still published to %s", topic)
+	}
+	if _, ok := c.Retained()[otherTopic]; !ok {
+		t.Errorf("Sensor of another cron job was deleted from %s", otherTopic)
+	}
+}
+
+func TestRunEvent(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
 	if err != nil {
 		t.Fatalf("Could not parse testing time: %s", err)
 	}
 
 	for _, tc := range []struct {
-		name  string
-		now   time.Time
-		sched string
-		want  time.Duration
+		name string
+
+		opts Options
+		res  exec.Result
+
+		want []runEvent
 	}{
 		{
-			name:  "simple",
-			now:   topOfTheHour,
-			sched: "0 * * * * ",
-			want:  time.Hour + 60*time.Second,
+			name: "succeeded",
+
+			opts: DefaultOptions,
+			res:  exec.Result{Start: start, End: start.Add(time.Second)},
+
+			want: []runEvent{{EventType: mqttcron.SucceededState, Duration: 1000, StartTime: start}},
 		},
 		{
-			name:  "cron job more frequently than default delay",
-			now:   topOfTheHour,
-			sched: "* * * * * ",
-			want:  time.Minute + 30*time.Second,
+			name: "failed",
+
+			opts: DefaultOptions,
+			res:  exec.Result{Start: start, End: start.Add(time.Second), ExitCode: 2},
+
+			want: []runEvent{{EventType: mqttcron.FailedState, ExitCode: 2, Duration: 1000, StartTime: start}},
+		},
+		{
+			name: "timed out",
+
+			opts: DefaultOptions,
+			res:  exec.Result{Start: start, End: start.Add(time.Second), ExitCode: -1, TimedOut: true},
+
+			want: []runEvent{{EventType: mqttcron.TimedOutState, ExitCode: -1, Duration: 1000, StartTime: start}},
+		},
+		{
+			name: "disabled",
+
+			opts: Options{DiscoveryPrefix: "homeassistant", Entities: map[string]bool{"run": false}},
+			res:  exec.Result{Start: start, End: start.Add(time.Second)},
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			s, err := cron.NewSchedule(tc.sched)
+			c := mqttfake.NewClient()
+			cj, err := mqttcron.NewCronJob("id", mqtt.NewClientForTesting(c), mqttcron.CronJobPlugins(NewPluginWithOptions(tc.opts)))
 			if err != nil {
-				t.Fatalf("cron.NewSchedule(%q) yielded an unexpected error: %s", tc.sched, err)
+				t.Fatalf("NewCronJob unexpectedly failed with %v", err)
 			}
-			now = func() time.Time { return tc.now }
-			if got := expireAfter(&s); got != tc.want {
-				t.Errorf("expireAfter(%q) = %s, want %s", tc.sched, got, tc.want)
+			var p *Plugin
+			if !cj.Plugin(&p) {
+				t.Fatalf("Could not find hass.Plugin")
+			}
+
+			var mut sync.Mutex
+			var got []runEvent
+			c.Subscribe(p.eventTopic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+				mut.Lock()
+				defer mut.Unlock()
+				var e runEvent
+				if err := json.Unmarshal(m.Payload(), &e); err != nil {
+					t.Errorf("Could not unmarshal event: %s", err)
+				}
+				got = append(got, e)
+			})
+
+			if err := cj.PublishResult(tc.res); err != nil {
+				t.Fatalf("PublishResult failed: %s", err)
+			}
+
+			mut.Lock()
+			defer mut.Unlock()
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Events diff (-want +got)\n%s", diff)
 			}
 		})
 	}
@@ -0,0 +1,290 @@
+// Package homie publishes cron jobs according to the Homie convention (https://homieiot.github.io/specification/spec-core-v4_0_0/).
+//
+// Each host (and user) is a Homie device, and each of its cron jobs is a node of that device.
+package homie
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+// version is the version of the Homie convention that's implemented.
+const version = "4.0"
+
+// The device's $state (see Plugin.ConnectionClientOptions).
+const (
+	initState  = "init"
+	readyState = "ready"
+	lostState  = "lost"
+)
+
+var (
+	now = time.Now
+	// localCronJobIDs returns the IDs of all of the cron jobs on the device, which become the device's nodes.
+	localCronJobIDs = func(d mqttcron.Device) ([]string, error) {
+		js, err := mqttcron.DiscoverLocalCronJobs(cron.TabsForUser(d.User), d.User)
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		for id := range js {
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+
+	invalidIDCharacters = regexp.MustCompile("[^a-z0-9-]+")
+)
+
+// Options configures Plugin.
+type Options struct {
+	// BaseTopic is the root of the Homie topics.
+	BaseTopic string `mapstructure:"base_topic"`
+}
+
+// DefaultOptions are the options used by NewPlugin.
+var DefaultOptions = Options{
+	BaseTopic: "homie",
+}
+
+func init() {
+	mqttcron.RegisterPlugin("homie", false, DefaultOptions, NewPluginWithOptions)
+}
+
+// Plugin publishes mqttcron.CronJob as a node of a Homie device.
+type Plugin struct {
+	mqttcron.NopPlugin
+	opts Options
+
+	deviceTopic string
+	nodeTopic   string
+}
+
+// property is a Homie property of each cron job's node.
+type property struct {
+	id       string
+	name     string
+	datatype string
+	unit     string
+	format   string
+}
+
+var (
+	stateProperty    = property{id: "state", name: "State", datatype: "enum", format: strings.Join([]string{mqttcron.SucceededState, mqttcron.FailedState, mqttcron.TimedOutState}, ",")}
+	exitCodeProperty = property{id: "exit-code", name: "Exit code", datatype: "integer"}
+	durationProperty = property{id: "duration", name: "Duration", datatype: "float", unit: "s"}
+	lastRunProperty  = property{id: "last-run", name: "Last run", datatype: "datetime"}
+	nextRunProperty  = property{id: "next-run", name: "Next run", datatype: "datetime"}
+
+	properties = []property{stateProperty, exitCodeProperty, durationProperty, lastRunProperty, nextRunProperty}
+)
+
+func NewPlugin() mqttcron.Plugin {
+	return NewPluginWithOptions(DefaultOptions)
+}
+
+func NewPluginWithOptions(opts Options) mqttcron.Plugin {
+	return &Plugin{opts: opts}
+}
+
+func (p *Plugin) Init(cj *mqttcron.CronJob, reg mqttcron.TopicRegister) error {
+	if err := validateBaseTopic(p.opts.BaseTopic); err != nil {
+		return fmt.Errorf("invalid base topic: %w", err)
+	}
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		return err
+	}
+
+	p.deviceTopic = p.opts.BaseTopic + "/" + deviceID(d)
+	for _, a := range []string{"$homie", "$name", "$nodes"} {
+		reg.RegisterSharedTopic(p.deviceTopic + "/" + a)
+	}
+
+	p.nodeTopic = p.deviceTopic + "/" + nodeID(cj.ID())
+	for _, a := range []string{"$name", "$type", "$properties"} {
+		reg.RegisterTopic(p.nodeTopic+"/"+a, mqtt.Retain)
+	}
+	for _, prop := range properties {
+		t := p.nodeTopic + "/" + prop.id
+		reg.RegisterTopic(t, mqtt.Retain)
+		for _, a := range []string{"$name", "$datatype", "$unit", "$format"} {
+			reg.RegisterTopic(t+"/"+a, mqtt.Retain)
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) OnCreate(cj *mqttcron.CronJob, pub mqttcron.Publisher) error {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		return err
+	}
+	nodes, err := p.nodes(d, cj.ID())
+	if err != nil {
+		return err
+	}
+
+	var propIDs []string
+	for _, prop := range properties {
+		propIDs = append(propIDs, prop.id)
+	}
+	fs := []func() error{
+		publish(pub, p.deviceTopic+"/$homie", version),
+		publish(pub, p.deviceTopic+"/$name", deviceName(d)),
+		publish(pub, p.deviceTopic+"/$nodes", nodes),
+		publish(pub, p.nodeTopic+"/$name", cj.Name()),
+		publish(pub, p.nodeTopic+"/$type", "cron job"),
+		publish(pub, p.nodeTopic+"/$properties", strings.Join(propIDs, ",")),
+	}
+	for _, prop := range properties {
+		t := p.nodeTopic + "/" + prop.id
+		fs = append(fs,
+			publish(pub, t+"/$name", prop.name),
+			publish(pub, t+"/$datatype", prop.datatype))
+		// An empty retained message would delete the attribute instead.
+		if prop.unit != "" {
+			fs = append(fs, publish(pub, t+"/$unit", prop.unit))
+		}
+		if prop.format != "" {
+			fs = append(fs, publish(pub, t+"/$format", prop.format))
+		}
+	}
+	if cj.Schedule != nil {
+		fs = append(fs, publish(pub, p.nodeTopic+"/"+nextRunProperty.id, formatTime(cj.Schedule.Next(now()))))
+	}
+	return mqttcron.MultiPublish(fs...)
+}
+
+func (p *Plugin) PublishResult(cj *mqttcron.CronJob, pub mqttcron.Publisher, res exec.Result) error {
+	fs := []func() error{
+		publish(pub, p.nodeTopic+"/"+stateProperty.id, mqttcron.ResultState(res)),
+		publish(pub, p.nodeTopic+"/"+exitCodeProperty.id, strconv.Itoa(res.ExitCode)),
+		publish(pub, p.nodeTopic+"/"+durationProperty.id, strconv.FormatFloat(res.End.Sub(res.Start).Seconds(), 'f', 3, 64)),
+		publish(pub, p.nodeTopic+"/"+lastRunProperty.id, formatTime(res.Start)),
+	}
+	if cj.Schedule != nil {
+		fs = append(fs, publish(pub, p.nodeTopic+"/"+nextRunProperty.id, formatTime(cj.Schedule.Next(now()))))
+	}
+	return mqttcron.MultiPublish(fs...)
+}
+
+// ConnectionClientOptions implements mqttcron.ConnectionPlugin. The device's $state goes through init to ready whenever the client connects, and the broker marks it as lost if the client disconnects unexpectedly.
+func (p *Plugin) ConnectionClientOptions() ([]mqtt.ClientOption, error) {
+	if err := validateBaseTopic(p.opts.BaseTopic); err != nil {
+		return nil, fmt.Errorf("invalid base topic: %w", err)
+	}
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		return nil, err
+	}
+	dev := p.opts.BaseTopic + "/" + deviceID(d)
+	return []mqtt.ClientOption{
+		mqtt.ClientWill(mqtt.Will{Topic: dev + "/$state", Payload: lostState, QoS: mqtt.QoSExactlyOnce, Retain: mqtt.Retain}),
+		mqtt.ClientOnConnect(func(c *mqtt.Client) {
+			pub := mqttcron.ContextPublisher(context.Background(), c)
+			// The other attributes have to be published while the device is initializing.
+			for _, f := range []func() error{
+				publish(pub, dev+"/$state", initState),
+				publish(pub, dev+"/$homie", version),
+				publish(pub, dev+"/$name", deviceName(d)),
+				publish(pub, dev+"/$state", readyState),
+			} {
+				if err := f(); err != nil {
+					log.Error().Err(err).Str("device", dev).Msg("Could not publish Homie device state")
+					return
+				}
+			}
+		}),
+	}, nil
+}
+
+// OnUnpublish implements mqttcron.UnpublishPlugin. The cron job's node topics are cleared by mqttcron.CronJob.Unpublish, but the device still needs to stop listing it as a node.
+func (p *Plugin) OnUnpublish(cj *mqttcron.CronJob, pub mqttcron.Publisher) error {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		return err
+	}
+	nodes, err := p.nodes(d, "")
+	if err != nil {
+		return err
+	}
+	return publish(pub, p.deviceTopic+"/$nodes", nodes)()
+}
+
+// nodes returns the device's $nodes attribute, which includes all of its local cron jobs, as well as the cron job identified by id (if it's non-empty).
+func (p *Plugin) nodes(d mqttcron.Device, id string) (string, error) {
+	ids, err := localCronJobIDs(d)
+	if err != nil {
+		return "", fmt.Errorf("could not determine the device's nodes: %w", err)
+	}
+	if id != "" {
+		ids = append(ids, id)
+	}
+
+	seen := make(map[string]bool)
+	var ns []string
+	for _, id := range ids {
+		n := nodeID(id)
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return strings.Join(ns, ","), nil
+}
+
+func publish(pub mqttcron.Publisher, topic, payload string) func() error {
+	return func() error { return pub.Publish(topic, mqtt.QoSExactlyOnce, mqtt.Retain, payload) }
+}
+
+// deviceName is the device's $name.
+func deviceName(d mqttcron.Device) string {
+	return fmt.Sprintf("%s@%s", d.User.Username, d.Hostname)
+}
+
+// deviceID converts the device into a Homie ID, which can only contain lowercase letters, numbers and hyphens.
+func deviceID(d mqttcron.Device) string {
+	return toID(fmt.Sprintf("cron2mqtt-%s-%s", d.ID, d.User.Uid))
+}
+
+// nodeID converts a cron job's ID into a Homie ID. Homie IDs are more restrictive than cron job IDs, so cron job IDs that only differ by case or by hyphens and underscores share a node.
+func nodeID(id string) string {
+	if n := toID(id); n != "" {
+		return n
+	}
+	return "cron-job"
+}
+
+func toID(s string) string {
+	s = invalidIDCharacters.ReplaceAllString(strings.ToLower(s), "-")
+	return strings.Trim(s, "-")
+}
+
+func validateBaseTopic(t string) error {
+	for _, c := range strings.Split(t, "/") {
+		if err := mqttcron.ValidateTopicComponent(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatTime formats t as an ISO 8601 datetime.
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
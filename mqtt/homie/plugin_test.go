@@ -0,0 +1,154 @@
+package homie
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/harness"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+func TestNodeID(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		want string
+	}{
+		{id: "backup", want: "backup"},
+		{id: "Nightly_Backup", want: "nightly-backup"},
+		{id: "-a__b-", want: "a-b"},
+		{id: "___", want: "cron-job"},
+	} {
+		if got := nodeID(tc.id); got != tc.want {
+			t.Errorf("nodeID(%q) = %q, want %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("mqttcron.CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	n, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	now = func() time.Time { return n }
+	defer func() { now = time.Now }()
+	// My_Job isn't in any local crontab, like after it's been removed.
+	origIDs := localCronJobIDs
+	localCronJobIDs = func(mqttcron.Device) ([]string, error) { return []string{"other"}, nil }
+	defer func() { localCronJobIDs = origIDs }()
+	s, err := cron.NewSchedule("0 * * * *")
+	if err != nil {
+		t.Fatalf("Could not parse testing schedule: %s", err)
+	}
+
+	c := mqttfake.NewClient()
+	var mut sync.Mutex
+	payloads := make(map[string]string)
+	dev := "homie/" + deviceID(d)
+	node := dev + "/my-job"
+	for _, topic := range []string{
+		dev + "/$homie", dev + "/$nodes", dev + "/$state",
+		node + "/$name", node + "/$properties", node + "/exit-code/$datatype", node + "/exit-code/$unit",
+		node + "/state", node + "/exit-code", node + "/duration", node + "/last-run", node + "/next-run",
+	} {
+		c.Subscribe(topic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+			mut.Lock()
+			defer mut.Unlock()
+			payloads[m.Topic()] = string(m.Payload())
+		})
+	}
+
+	p := NewPlugin()
+	cj, err := mqttcron.NewCronJob("My_Job", mqtt.NewClientForTesting(c),
+		mqttcron.CronJobConfig(&cron.Job{Schedule: s, Command: cron.NewCommand("cron2mqtt exec My_Job echo true")}),
+		mqttcron.CronJobName("My job", ""),
+		nameTemplate(t, "{{.Name}}"),
+		mqttcron.CronJobPlugins(p))
+	if err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	start := n.Add(-1500 * time.Millisecond)
+	if err := cj.PublishResult(exec.Result{Start: start, End: n, ExitCode: 2}); err != nil {
+		t.Fatalf("PublishResult failed: %s", err)
+	}
+
+	want := map[string]string{
+		dev + "/$homie":               "4.0",
+		dev + "/$nodes":               "my-job,other",
+		node + "/$name":               "My job",
+		node + "/$properties":         "state,exit-code,duration,last-run,next-run",
+		node + "/exit-code/$datatype": "integer",
+		node + "/state":               "failed",
+		node + "/exit-code":           "2",
+		node + "/duration":            "1.500",
+		node + "/last-run":            "1999-12-31T23:59:58Z",
+		node + "/next-run":            "2000-01-01T01:00:00Z",
+	}
+	mut.Lock()
+	if diff := cmp.Diff(want, payloads); diff != "" {
+		t.Errorf("Published payloads mismatch (-want +got):\n%s", diff)
+	}
+	mut.Unlock()
+
+	if err := p.(mqttcron.UnpublishPlugin).OnUnpublish(cj, mqttcron.ContextPublisher(context.Background(), mqtt.NewClientForTesting(c))); err != nil {
+		t.Fatalf("OnUnpublish failed: %s", err)
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	if got := payloads[dev+"/$nodes"]; got != "other" {
+		t.Errorf("$nodes = %q after OnUnpublish, want %q", got, "other")
+	}
+}
+
+func TestConnectionClientOptions(t *testing.T) {
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("mqttcron.CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	state := "homie/" + deviceID(d) + "/$state"
+
+	b := harness.StartBroker(t)
+	opts, err := NewPlugin().(mqttcron.ConnectionPlugin).ConnectionClientOptions()
+	if err != nil {
+		t.Fatalf("ConnectionClientOptions failed: %s", err)
+	}
+	// Closing the client after the broker is gone shouldn't take long.
+	conf := mqtt.Config{Broker: b.URL(), PublishTimeout: 100 * time.Millisecond, Retry: mqtt.RetryConfig{Attempts: 1}}
+	c, err := mqtt.NewClient(conf, opts...)
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	defer c.Close(0)
+	if got := b.Retained()[state]; got != readyState {
+		t.Errorf("$state = %q after connecting, want %q", got, readyState)
+	}
+
+	b.Close()
+	deadline := time.Now().Add(time.Second)
+	for b.Retained()[state] != lostState {
+		if time.Now().After(deadline) {
+			t.Fatalf("$state = %q after the connection was lost, want %q", b.Retained()[state], lostState)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func nameTemplate(t *testing.T, s string) mqttcron.CronJobOption {
+	nt, err := mqttcron.ParseNameTemplate(s)
+	if err != nil {
+		t.Fatalf("ParseNameTemplate(%q) yielded an unexpected error: %s", s, err)
+	}
+	return mqttcron.CronJobNameTemplate(nt)
+}
@@ -2,7 +2,9 @@ package mqtt
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -18,12 +20,38 @@ import (
 
 // Config configures how a Client connects to an MQTT broker.
 type Config struct {
-	Broker     string
-	Username   string
-	Password   string
-	ServerName string `mapstructure:"server_name,omitempty"`
+	Broker   string
+	Username string
+	// Password is stored in plain text. It's better to use one of the other sources in PasswordSource.
+	Password       string
+	PasswordSource `mapstructure:",squash"`
+	ServerName     string `mapstructure:"server_name,omitempty"`
+	// ProtocolVersion is either 4 (MQTT 3.1.1, the default) or 5 (MQTT 5).
+	ProtocolVersion int `mapstructure:"protocol_version,omitempty"`
+	// ConnectTimeout bounds each attempt to connect to the broker. Defaults to DefaultConnectTimeout.
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout,omitempty"`
+	// PublishTimeout bounds each attempt to publish a message. Defaults to DefaultPublishTimeout.
+	PublishTimeout time.Duration `mapstructure:"publish_timeout,omitempty"`
+	// Retry configures how connecting and publishing are retried after network failures.
+	Retry RetryConfig `mapstructure:"retry,omitempty"`
 }
 
+func (c Config) withDefaults() Config {
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = DefaultConnectTimeout
+	}
+	if c.PublishTimeout <= 0 {
+		c.PublishTimeout = DefaultPublishTimeout
+	}
+	c.Retry = c.Retry.withDefaults()
+	return c
+}
+
+const (
+	ProtocolVersion311 = 4
+	ProtocolVersion5   = 5
+)
+
 // QoS represents the different MQTT QoS levels.
 type QoS byte
 
@@ -52,41 +80,173 @@ func (m RetainMode) String() string {
 
 type Message = mqtt.Message
 
+// Properties are MQTT 5 properties of a published message. They're ignored when the client is connected with an earlier version of the protocol.
+type Properties struct {
+	ContentType string
+	// MessageExpiry is how long the broker should hold on to the message (including as a retained message) before discarding it. Zero means forever.
+	MessageExpiry time.Duration
+	// UserProperties let brokers and subscribers inspect messages without parsing their payloads.
+	UserProperties map[string]string
+}
+
+type payloadWithProperties struct {
+	payload interface{}
+	props   Properties
+}
+
+// WithProperties attaches properties to the payload of a message for Client.Publish.
+func WithProperties(payload interface{}, props Properties) interface{} {
+	return payloadWithProperties{payload, props}
+}
+
+// PayloadBytes returns the bytes of a payload for Client.Publish, without any properties that were attached by WithProperties.
+func PayloadBytes(payload interface{}) ([]byte, error) {
+	if p, ok := payload.(payloadWithProperties); ok {
+		payload = p.payload
+	}
+	return payloadBytes(payload)
+}
+
+// Will is a message that the broker publishes on a client's behalf if the client disconnects unexpectedly (i.e. its "last will and testament").
+type Will struct {
+	Topic   string
+	Payload string
+	QoS     QoS
+	Retain  RetainMode
+}
+
+// ClientOption customizes a Client.
+type ClientOption func(*Client)
+
+// ClientWill sets the client's last will.
+// The broker only publishes the will when the client disconnects unexpectedly, so it's also published when the client is closed.
+func ClientWill(w Will) ClientOption {
+	return func(c *Client) {
+		c.will = &w
+	}
+}
+
+// ClientOnConnect registers a function that's called every time the client connects to the broker, including when it automatically reconnects.
+// It's called synchronously by NewClient for the initial connection.
+func ClientOnConnect(f func(*Client)) ClientOption {
+	return func(c *Client) {
+		c.onConnect = append(c.onConnect, f)
+	}
+}
+
 // Client is an MQTT client.
 type Client struct {
-	c mqtt.Client
+	c    conn
+	conf Config
+
+	will      *Will
+	onConnect []func(*Client)
 }
 
 // NewClient constructs a new MQTT client and connects it to the broker.
-func NewClient(c Config) (*Client, error) {
+func NewClient(c Config, clOpts ...ClientOption) (*Client, error) {
 	defer logutil.StartTimerLogger(log.With().Str("broker", c.Broker).Logger(), zerolog.DebugLevel, "Connecting to MQTT broker").Stop()
 
-	opts := mqtt.NewClientOptions().
-		SetClientID("cron-mqtt").
-		SetOrderMatters(false).
-		AddBroker(c.Broker).
-		SetUsername(c.Username).
-		SetPassword(c.Password)
-	if c.ServerName != "" {
-		opts.SetTLSConfig(&tls.Config{
-			ServerName: c.ServerName,
-		})
+	c = c.withDefaults()
+	// The password is resolved up front so that problems with its source are reported immediately. MQTT 3.1.1 clients resolve it again when they reconnect, so that changes to its source (e.g. rotated credentials) are picked up.
+	ctx, cancel := context.WithTimeout(context.Background(), c.ConnectTimeout)
+	defer cancel()
+	pwd, err := c.resolvePassword(ctx)
+	if err != nil {
+		return nil, err
+	}
+	creds := c.credentialsProvider(pwd)
+	c.Password = pwd
+	c.PasswordSource = PasswordSource{}
+
+	cl := &Client{conf: c}
+	for _, opt := range clOpts {
+		opt(cl)
 	}
 
-	cl := Client{mqtt.NewClient(opts)}
-	if t := cl.c.Connect(); t.Wait() && t.Error() != nil {
-		return nil, fmt.Errorf("could not connect to broker: %w", t.Error())
+	// The initial connection is handled synchronously below.
+	var connections int32
+	onConnect := func() {
+		if atomic.AddInt32(&connections, 1) == 1 {
+			return
+		}
+		log.Info().Str("broker", c.Broker).Msg("Reconnected to MQTT broker")
+		cl.runOnConnect()
+	}
+
+	switch c.ProtocolVersion {
+	case 0, ProtocolVersion311:
+		cl.c = newV3Conn(c, creds, cl.will, onConnect)
+	case ProtocolVersion5:
+		v5, err := newV5Conn(c, cl.will, onConnect)
+		if err != nil {
+			return nil, err
+		}
+		cl.c = v5
+	default:
+		return nil, fmt.Errorf("unsupported protocol version %d, expected %d (MQTT 3.1.1) or %d (MQTT 5)", c.ProtocolVersion, ProtocolVersion311, ProtocolVersion5)
+	}
+	if err := cl.connect(); err != nil {
+		return nil, fmt.Errorf("could not connect to broker: %w", err)
+	}
+	cl.runOnConnect()
+
+	return cl, nil
+}
+
+func (c *Client) connect() error {
+	return c.conf.Retry.do(context.Background(), log.With().Str("broker", c.conf.Broker).Logger(), func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.conf.ConnectTimeout)
+		defer cancel()
+		return c.c.connect(ctx)
+	})
+}
+
+// conn is a connection to the broker with a particular version of the MQTT protocol.
+type conn interface {
+	broker() string
+	connect(ctx context.Context) error
+	isConnected() bool
+	publish(ctx context.Context, topic string, qos QoS, retain RetainMode, payload interface{}, props *Properties) error
+	subscribe(topic string, qos QoS, h func(Message)) error
+	unsubscribe(topic string) error
+	disconnect(quiesce uint)
+}
+
+func tlsConfig(c Config) *tls.Config {
+	if c.ServerName == "" {
+		return nil
+	}
+	return &tls.Config{
+		ServerName: c.ServerName,
 	}
+}
 
-	return &cl, nil
+func (c *Client) runOnConnect() {
+	for _, f := range c.onConnect {
+		f(c)
+	}
+}
+
+// clientID generates a unique client ID so that concurrent cron2mqtt processes (e.g. cron jobs and the daemon) don't kick each other off of the broker.
+func clientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "cron-mqtt-" + hex.EncodeToString(b)
 }
 
 func NewClientForTesting(c mqtt.Client) *Client {
-	return &Client{c}
+	return &Client{c: v3Conn{c}, conf: Config{}.withDefaults()}
 }
 
 // Publish publishes the given payload on the given topic on the connected broker.
-func (c *Client) Publish(topic string, qos QoS, retain RetainMode, payload interface{}) error {
+// Each attempt is bounded by Config.PublishTimeout, and network failures are retried according to Config.Retry until ctx is done.
+func (c *Client) Publish(ctx context.Context, topic string, qos QoS, retain RetainMode, payload interface{}) error {
+	var props *Properties
+	if p, ok := payload.(payloadWithProperties); ok {
+		payload = p.payload
+		props = &p.props
+	}
 	payloadHook := logutil.FuncOnce(func(e *zerolog.Event) {
 		var b []byte
 		switch p := payload.(type) {
@@ -111,10 +271,13 @@ func (c *Client) Publish(topic string, qos QoS, retain RetainMode, payload inter
 			}
 		}
 	}(payloadHook)
-	defer logutil.StartTimerLogger(log.With().Str("topic", topic).Bool("retained", bool(retain)).Logger().Hook(payloadHook), zerolog.DebugLevel, "Publishing message to MQTT topic").Stop()
-	t := c.c.Publish(topic, byte(qos), bool(retain), payload)
-	t.Wait()
-	return t.Error()
+	log := log.With().Str("topic", topic).Bool("retained", bool(retain)).Logger()
+	defer logutil.StartTimerLogger(log.Hook(payloadHook), zerolog.DebugLevel, "Publishing message to MQTT topic").Stop()
+	return c.conf.Retry.do(ctx, log, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.conf.PublishTimeout)
+		defer cancel()
+		return c.c.publish(ctx, topic, qos, retain, payload, props)
+	})
 }
 
 func (c *Client) Subscribe(ctx context.Context, topic string, qos QoS, ch chan<- Message) error {
@@ -122,25 +285,35 @@ func (c *Client) Subscribe(ctx context.Context, topic string, qos QoS, ch chan<-
 	log := log.Ctx(ctx).Hook(logutil.FuncHook(func(e *zerolog.Event) { e.TimeDiff("offset", zerolog.TimestampFunc(), start) })).With().Str("topic", topic).Logger()
 	log.Debug().Msg("Subscribing to MQTT topic")
 
-	var closeOnce sync.Once
+	// Messages can still be in flight after unsubscribing, so ch is only closed once nothing is sending to it.
+	var chMut sync.RWMutex
+	closed := false
+	closeCh := func() {
+		chMut.Lock()
+		defer chMut.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
 	unsub := func() error {
 		log.Debug().Msg("Unsubscribing from MQTT topic")
-		if !c.c.IsConnected() {
+		if !c.c.isConnected() {
 			log.Debug().Msg("Client is not connected")
-			closeOnce.Do(func() { close(ch) })
+			closeCh()
 			return nil
 		}
 
-		if t := c.c.Unsubscribe(topic); t.Wait() && t.Error() != nil {
-			return t.Error()
+		if err := c.c.unsubscribe(topic); err != nil {
+			return err
 		}
-		closeOnce.Do(func() { close(ch) })
+		closeCh()
 		log.Debug().Msg("Unsubscribed from MQTT topic")
 		return nil
 	}
 
 	var i int32
-	if t := c.c.Subscribe(topic, byte(qos), func(c mqtt.Client, m mqtt.Message) {
+	if err := c.c.subscribe(topic, qos, func(m Message) {
 		i := atomic.AddInt32(&i, 1)
 		log := log.Debug().
 			Int32("n", i).
@@ -160,15 +333,22 @@ func (c *Client) Subscribe(ctx context.Context, topic string, qos QoS, ch chan<-
 					}
 				}
 			})
+		chMut.RLock()
+		defer chMut.RUnlock()
+		if closed {
+			log.Msg("Dropping message")
+			return
+		}
+		// ch is only closed after ctx is done, so this can't block closeCh indefinitely.
 		select {
 		case <-ctx.Done():
 			log.Msg("Dropping message")
 		case ch <- m:
 			log.Msg("Received message")
 		}
-	}); t.Wait() && t.Error() != nil {
+	}); err != nil {
 		close(ch)
-		return t.Error()
+		return err
 	}
 
 	go func() {
@@ -190,7 +370,11 @@ func (c *Client) Subscribe(ctx context.Context, topic string, qos QoS, ch chan<-
 
 // Close disconnects this client from the broker.
 func (c *Client) Close(quiesce uint) {
-	opts := c.c.OptionsReader()
-	defer logutil.StartTimerLogger(log.With().Stringer("broker", opts.Servers()[0]).Logger(), zerolog.DebugLevel, "Disconnecting from MQTT broker").Stop()
-	c.c.Disconnect(quiesce)
+	defer logutil.StartTimerLogger(log.With().Str("broker", c.c.broker()).Logger(), zerolog.DebugLevel, "Disconnecting from MQTT broker").Stop()
+	if c.will != nil && c.c.isConnected() {
+		if err := c.Publish(context.Background(), c.will.Topic, c.will.QoS, c.will.Retain, c.will.Payload); err != nil {
+			log.Warn().Err(err).Str("topic", c.will.Topic).Msg("Could not publish last will")
+		}
+	}
+	c.c.disconnect(quiesce)
 }
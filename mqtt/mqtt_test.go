@@ -7,12 +7,13 @@ import (
 	"testing"
 	"time"
 
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
 )
 
 func TestSubscribe(t *testing.T) {
 	topic := "topic"
-	c := Client{mqttfake.NewClient()}
 
 	for _, tc := range []struct {
 		name string
@@ -57,6 +58,8 @@ func TestSubscribe(t *testing.T) {
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
+			// Each test case gets its own broker so that messages and subscriptions from earlier test cases can't interfere.
+			c := NewClientForTesting(mqttfake.NewClient())
 			ctx, canc := context.WithTimeout(context.Background(), 500*time.Millisecond)
 			defer canc()
 			ch := make(chan Message)
@@ -67,7 +70,7 @@ func TestSubscribe(t *testing.T) {
 			for i := 0; i < tc.numMessages; i++ {
 				i := i
 				go func() {
-					c.Publish(topic, QoSExactlyOnce, Retain, fmt.Sprintf("%d", i))
+					c.Publish(context.Background(), topic, QoSExactlyOnce, Retain, fmt.Sprintf("%d", i))
 				}()
 			}
 
@@ -88,6 +91,21 @@ func TestSubscribe(t *testing.T) {
 	}
 }
 
+func TestPublishWithProperties(t *testing.T) {
+	f := mqttfake.NewClient()
+	c := NewClientForTesting(f)
+	ch := make(chan string, 1)
+	f.Subscribe("topic", 0, func(_ mqttpaho.Client, m mqttpaho.Message) { ch <- string(m.Payload()) })
+
+	// MQTT 3.1.1 doesn't support properties, so only the payload should be published.
+	if err := c.Publish(context.Background(), "topic", QoSExactlyOnce, DoNotRetain, WithProperties("payload", Properties{ContentType: "text/plain"})); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+	if got := <-ch; got != "payload" {
+		t.Errorf("Published %q, want %q", got, "payload")
+	}
+}
+
 func messages(ctx context.Context, ms <-chan Message, n int, canc func()) []Message {
 	var got []Message
 	for {
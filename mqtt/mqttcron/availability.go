@@ -0,0 +1,58 @@
+package mqttcron
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+const (
+	OnlinePayload  = "online"
+	OfflinePayload = "offline"
+
+	// availabilitySuffix is used for both the device's and each cron job's availability topics.
+	availabilitySuffix = "availability"
+)
+
+// AvailabilityTopic reports whether the device is online. It's kept up to date by long-lived clients (see AvailabilityClientOptions).
+func (d Device) AvailabilityTopic() string {
+	return d.topicPrefix + "/" + availabilitySuffix
+}
+
+// AvailabilityClientOptions returns options for mqtt.NewClient that mark the current device as online for as long as the client is connected.
+// If the client disconnects unexpectedly (e.g. because the device lost power), the broker marks the device as offline.
+func AvailabilityClientOptions() ([]mqtt.ClientOption, error) {
+	d, err := CurrentDevice()
+	if err != nil {
+		return nil, err
+	}
+	t := d.AvailabilityTopic()
+	return []mqtt.ClientOption{
+		mqtt.ClientWill(mqtt.Will{Topic: t, Payload: OfflinePayload, QoS: mqtt.QoSExactlyOnce, Retain: mqtt.Retain}),
+		mqtt.ClientOnConnect(func(c *mqtt.Client) {
+			if err := c.Publish(context.Background(), t, mqtt.QoSExactlyOnce, mqtt.Retain, OnlinePayload); err != nil {
+				log.Error().Err(err).Str("topic", t).Msg("Could not publish availability")
+			}
+		}),
+	}, nil
+}
+
+// ConnectionPlugin is an optional interface for Plugins that report whether the device is connected, like AvailabilityClientOptions does.
+// A client can only have one will, so long-lived clients (e.g. the daemon) need a separate client for each ConnectionPlugin.
+type ConnectionPlugin interface {
+	// ConnectionClientOptions returns options for mqtt.NewClient that keep the plugin's connection state up to date for as long as the client is connected.
+	ConnectionClientOptions() ([]mqtt.ClientOption, error)
+}
+
+// PublishOnline marks the current device as online. It's meant for short-lived clients that have proof that the device is online (e.g. because a cron job just ran).
+// Nothing marks the device as offline again unless a long-lived client is running, so with MQTT 5, a non-zero expiry asks the broker to forget that the device is online after that long.
+func PublishOnline(ctx context.Context, c Client, expiry time.Duration) error {
+	d, err := CurrentDevice()
+	if err != nil {
+		return err
+	}
+	return c.Publish(ctx, d.AvailabilityTopic(), mqtt.QoSExactlyOnce, mqtt.Retain, mqtt.WithProperties(OnlinePayload, mqtt.Properties{MessageExpiry: expiry}))
+}
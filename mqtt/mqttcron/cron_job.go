@@ -10,7 +10,9 @@ import (
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/btcsuite/btcutil/base58"
@@ -21,8 +23,11 @@ import (
 
 	"github.com/JeffreyFalgout/cron2mqtt/cron"
 	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/history"
 	"github.com/JeffreyFalgout/cron2mqtt/logutil"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
+	"github.com/JeffreyFalgout/cron2mqtt/signing"
 )
 
 const (
@@ -41,6 +46,18 @@ func ValidateTopicComponent(s string) error {
 	return nil
 }
 
+// ValidateID checks whether s can be used as a cron job's ID.
+func ValidateID(s string) error {
+	if err := ValidateTopicComponent(s); err != nil {
+		return err
+	}
+	if s == availabilitySuffix {
+		// The cron job's topics would overlap with Device.AvailabilityTopic.
+		return fmt.Errorf("%q is reserved", s)
+	}
+	return nil
+}
+
 type Publisher interface {
 	Publish(topic string, qos mqtt.QoS, retain mqtt.RetainMode, payload interface{}) error
 }
@@ -48,20 +65,58 @@ type Subscriber interface {
 	Subscribe(ctx context.Context, topic string, qos mqtt.QoS, messages chan<- mqtt.Message) error
 }
 type Client interface {
-	Publisher
+	Publish(ctx context.Context, topic string, qos mqtt.QoS, retain mqtt.RetainMode, payload interface{}) error
 	Subscriber
 }
 
+// ContextPublisher adapts c to a Publisher that publishes with ctx.
+func ContextPublisher(ctx context.Context, c Client) Publisher {
+	return contextPublisher{ctx, c}
+}
+
+type contextPublisher struct {
+	ctx context.Context
+	c   Client
+}
+
+func (p contextPublisher) Publish(topic string, qos mqtt.QoS, retain mqtt.RetainMode, payload interface{}) error {
+	return p.c.Publish(p.ctx, topic, qos, retain, payload)
+}
+
+// History provides the past executions of cron jobs.
+type History interface {
+	Entries(id string, f history.Filter) ([]history.Entry, error)
+}
+
 type CronJob struct {
 	id string
 	// The configuration for this cron job on the host, if it's known.
 	Schedule *cron.Schedule
 	Command  *cron.Command
+	Enabled  *bool
 
 	client      Client
+	history     History
+	anomaly     history.AnomalyThreshold
+	extractors  []Extractor
 	topicPrefix string
-	plugins     []Plugin
-	topics      map[Plugin]map[string]mqtt.RetainMode
+	// resultsExpiry is how long brokers should hold on to published results.
+	resultsExpiry time.Duration
+	// signer signs the payloads that CorePlugin publishes, if it's set.
+	signer signing.Signer
+	// ctx is used by everything that the cron job publishes outside of Unpublish.
+	ctx context.Context
+	// clearStaleTopics is whether OnCreate clears the retained messages of TopicOwnerPlugins that are no longer registered.
+	clearStaleTopics bool
+
+	friendlyName string
+	description  string
+	nameTemplate *template.Template
+	name         string // friendlyName after it's been formatted by nameTemplate.
+
+	plugins      []Plugin
+	topics       map[Plugin]map[string]mqtt.RetainMode
+	sharedTopics map[string]bool
 }
 
 type CronJobOption func(*CronJob)
@@ -72,13 +127,66 @@ func CronJobPlugins(ps ...Plugin) CronJobOption {
 	}
 }
 
+// CronJobHistory provides the cron job's past executions to plugins that need them (e.g. StatsPlugin and anomaly detection in CorePlugin).
+func CronJobHistory(h History) CronJobOption {
+	return func(cj *CronJob) {
+		cj.history = h
+	}
+}
+
+// CronJobAnomalyThreshold configures when CorePlugin considers an execution's duration to be anomalous. It has no effect without CronJobHistory.
+func CronJobAnomalyThreshold(t history.AnomalyThreshold) CronJobOption {
+	return func(cj *CronJob) {
+		cj.anomaly = t
+	}
+}
+
+// CronJobResultsExpiry asks the broker to discard the cron job's published results after d, so that stale results aren't delivered to subscribers that reconnect much later. It only has an effect with MQTT 5.
+func CronJobResultsExpiry(d time.Duration) CronJobOption {
+	return func(cj *CronJob) {
+		cj.resultsExpiry = d
+	}
+}
+
+// CronJobContext makes the cron job publish with ctx, e.g. when it's created and when its results are published, so that those publishes can be cancelled. It defaults to context.Background().
+func CronJobContext(ctx context.Context) CronJobOption {
+	return func(cj *CronJob) {
+		cj.ctx = ctx
+	}
+}
+
+// CronJobClearStaleTopics makes the cron job clear retained messages that TopicOwnerPlugins own but didn't register when it's created (e.g. the sensors of removed Extractors).
+// It waits for the broker to deliver the retained messages, so it's meant for when the cron job's configuration is (re)published, rather than for every execution.
+func CronJobClearStaleTopics() CronJobOption {
+	return func(cj *CronJob) {
+		cj.clearStaleTopics = true
+	}
+}
+
+// CronJobSigner signs the cron job's metadata, results and last success so that consumers can verify where they came from.
+func CronJobSigner(s signing.Signer) CronJobOption {
+	return func(cj *CronJob) {
+		cj.signer = s
+	}
+}
+
 func CronJobConfig(j *cron.Job) CronJobOption {
 	return func(cj *CronJob) {
-		cj.Schedule = &j.Schedule
+		cj.Schedule = scheduleOf(cj.id, j)
 		cj.Command = j.Command
+		cj.Enabled = new.Ptr(j.Enabled())
 	}
 }
 
+// scheduleOf returns j's schedule, or nil if it couldn't be parsed, since a cron job's schedule is only useful if its next execution can be predicted.
+func scheduleOf(id string, j *cron.Job) *cron.Schedule {
+	if err := j.Schedule.Err(); err != nil {
+		log.Warn().Str("id", id).Err(err).Msg("Could not parse the schedule of the cron job, so its next execution can't be predicted.")
+		return nil
+	}
+	return &j.Schedule
+}
+
 func CronJobCommand(args []string) CronJobOption {
 	return func(cj *CronJob) {
 		cj.Command = cron.NewCommand(strings.Join(args, " "))
@@ -99,12 +207,18 @@ func NewCronJob(id string, c Client, opts ...CronJobOption) (*CronJob, error) {
 	return cj, nil
 }
 
+// ExistingCronJob creates a CronJob that has already been created by NewCronJob at some point, and runs the provided plugins through Init.
+// Unlike NewCronJob, plugins won't be run through OnCreate, so this is useful for publishing updates about a cron job without republishing all of its configuration.
+func ExistingCronJob(id string, c Client, opts ...CronJobOption) (*CronJob, error) {
+	return newCronJobNoCreate(id, c, opts)
+}
+
 func (c *CronJob) ID() string {
 	return c.id
 }
 
 func newCronJobNoCreate(id string, c Client, opts []CronJobOption) (*CronJob, error) {
-	if err := ValidateTopicComponent(id); err != nil {
+	if err := ValidateID(id); err != nil {
 		return nil, fmt.Errorf("provided cron job ID is invalid: %w", err)
 	}
 	d, err := CurrentDevice()
@@ -115,12 +229,20 @@ func newCronJobNoCreate(id string, c Client, opts []CronJobOption) (*CronJob, er
 	cj := &CronJob{
 		id:          id,
 		client:      c,
+		anomaly:     history.DefaultAnomalyThreshold,
 		topicPrefix: fmt.Sprintf("%s/%s", d.topicPrefix, id),
 		plugins:     []Plugin{&CorePlugin{}},
+		ctx:         context.Background(),
 	}
 	for _, opt := range opts {
 		opt(cj)
 	}
+	if err := compileExtractors(cj.extractors); err != nil {
+		return nil, err
+	}
+	if cj.name, err = cj.formatName(d); err != nil {
+		return nil, err
+	}
 
 	if err := cj.initPlugins(); err != nil {
 		return nil, err
@@ -135,6 +257,7 @@ func (c *CronJob) initPlugins() error {
 		suffixes:       make(map[string]Plugin),
 		topics:         make(map[string]Plugin),
 		topicsByPlugin: make(map[Plugin]map[string]mqtt.RetainMode),
+		shared:         make(map[string]bool),
 	}
 	var err error
 	for _, p := range c.plugins {
@@ -154,6 +277,7 @@ func (c *CronJob) initPlugins() error {
 	}
 
 	c.topics = reg.topicsByPlugin
+	c.sharedTopics = reg.shared
 	return err
 }
 
@@ -162,6 +286,7 @@ type topicRegister struct {
 	suffixes       map[string]Plugin
 	topics         map[string]Plugin
 	topicsByPlugin map[Plugin]map[string]mqtt.RetainMode
+	shared         map[string]bool
 
 	p   Plugin
 	err error
@@ -184,6 +309,12 @@ func (reg *topicRegister) RegisterTopic(topic string, retain mqtt.RetainMode) {
 	reg.registerTopic(topic, retain)
 }
 
+func (reg *topicRegister) RegisterSharedTopic(topic string) {
+	if reg.registerTopic(topic, mqtt.Retain) {
+		reg.shared[topic] = true
+	}
+}
+
 func (reg *topicRegister) registerTopic(topic string, retain mqtt.RetainMode) bool {
 	if p, ok := reg.topics[topic]; ok {
 		reg.err = multierr.Append(reg.err, fmt.Errorf("plugin %T tried to register topic %q which was already registered by %T", reg.p, topic, p))
@@ -231,14 +362,25 @@ func (c *CronJob) onCreate() error {
 	t := logutil.StartTimerLogger(log.Logger.With().Str("plugin", "discoverLocalCronJobIfNecessary").Logger(), zerolog.TraceLevel, "Plugin#OnCreate")
 	c.discoverLocalCronJobIfNecessary()
 	t.Stop()
+	// The name might depend on the schedule or command that were just discovered.
+	d, err := CurrentDevice()
+	if err != nil {
+		return err
+	}
+	if c.name, err = c.formatName(d); err != nil {
+		return err
+	}
 
 	var fs []func() error
 	for _, p := range c.plugins {
 		p := p
 		fs = append(fs, func() error {
 			defer logutil.StartTimerLogger(log.Logger.With().Str("plugin", fmt.Sprintf("%T", p)).Logger(), zerolog.TraceLevel, "Plugin#OnCreate").Stop()
-			return p.OnCreate(c, limitedPublisher{c.client, p, c.topics[p]})
+			return p.OnCreate(c, c.publisher(c.ctx, p))
 		})
+		if p, ok := p.(TopicOwnerPlugin); ok && c.clearStaleTopics {
+			fs = append(fs, func() error { return c.clearOwnedTopics(c.ctx, p, c.topics[p]) })
+		}
 	}
 	return MultiPublish(fs...)
 }
@@ -263,12 +405,15 @@ func (c *CronJob) discoverLocalCronJobIfNecessary() {
 
 	if c.Command != nil && !sameCron2mqttCommand(j.Command, c.Command) {
 		log.Warn().Str("id", c.id).Str("found", j.Command.String()).Str("current", c.Command.String()).Msgf("Found cron job configuration that does not match currently executing command.")
+	} else {
+		// The crontab has the command as it was written, instead of the arguments it was split into (e.g. from os.Args).
+		c.Command = j.Command
 	}
 	if c.Schedule == nil {
-		c.Schedule = &j.Schedule
+		c.Schedule = scheduleOf(c.id, j)
 	}
-	if c.Command == nil {
-		c.Command = j.Command
+	if c.Enabled == nil {
+		c.Enabled = new.Ptr(j.Enabled())
 	}
 }
 
@@ -302,12 +447,45 @@ func (c *CronJob) PublishResult(res exec.Result) error {
 		p := p
 		fs = append(fs, func() error {
 			defer logutil.StartTimerLogger(log.Logger.With().Str("plugin", fmt.Sprintf("%T", p)).Logger(), zerolog.TraceLevel, "Plugin#PublishResult").Stop()
-			return p.PublishResult(c, limitedPublisher{c.client, p, c.topics[p]}, res)
+			return p.PublishResult(c, c.publisher(c.ctx, p), res)
 		})
 	}
 	return MultiPublish(fs...)
 }
 
+// pastExecutions returns the cron job's history before res, oldest first.
+func (c *CronJob) pastExecutions(res exec.Result) ([]history.Entry, error) {
+	es, err := c.history.Entries(c.id, history.Filter{})
+	if err != nil {
+		return nil, fmt.Errorf("could not load history: %w", err)
+	}
+	// The current result might have already made it into the history.
+	if n := len(es); n > 0 && es[n-1].Start.Equal(res.Start) {
+		es = es[:n-1]
+	}
+	return es, nil
+}
+
+// ResultProperties returns MQTT 5 properties that describe res, so that brokers and bridges can route messages about it without parsing their payloads.
+// Plugins can attach them to a payload with mqtt.WithProperties.
+func (c *CronJob) ResultProperties(res exec.Result) mqtt.Properties {
+	props := mqtt.Properties{
+		UserProperties: map[string]string{
+			"job_id":    c.id,
+			"exit_code": strconv.Itoa(res.ExitCode),
+		},
+	}
+	if d, err := CurrentDevice(); err == nil {
+		props.UserProperties["host"] = d.Hostname
+	}
+	return props
+}
+
+// publisher returns the Publisher for p, which only allows p to publish to the topics that it registered.
+func (c *CronJob) publisher(ctx context.Context, p Plugin) limitedPublisher {
+	return limitedPublisher{ContextPublisher(ctx, c.client), p, c.topics[p]}
+}
+
 type limitedPublisher struct {
 	pub    Publisher
 	p      Plugin
@@ -323,25 +501,59 @@ func (p limitedPublisher) Publish(topic string, qos mqtt.QoS, retain mqtt.Retain
 	return p.pub.Publish(topic, qos, retain, payload)
 }
 
-// Unpublish clears all MQTT topics used by this CronJob that have retained data.
+// Unpublish clears all MQTT topics used by this CronJob that have retained data, except for shared topics (see TopicRegister.RegisterSharedTopic).
 func (c *CronJob) Unpublish(ctx context.Context) error {
 	var fs []func() error
 	fs = append(fs, func() error { return c.unpublishPrefix(ctx, c.topicPrefix) })
 	for _, ts := range c.topics {
 		for t, retain := range ts {
-			if retain != mqtt.Retain {
+			if retain != mqtt.Retain || c.sharedTopics[t] {
 				continue
 			}
 			if strings.HasPrefix(t, c.topicPrefix+"/") {
 				continue
 			}
 			t := t
-			fs = append(fs, func() error { return c.unpublishTopic(t) })
+			fs = append(fs, func() error { return c.unpublishTopic(ctx, t) })
+		}
+	}
+	for _, p := range c.plugins {
+		if p, ok := p.(UnpublishPlugin); ok {
+			fs = append(fs, func() error { return p.OnUnpublish(c, c.publisher(ctx, p)) })
+		}
+		if p, ok := p.(TopicOwnerPlugin); ok {
+			fs = append(fs, func() error { return c.clearOwnedTopics(ctx, p, nil) })
 		}
 	}
 	return MultiPublish(fs...)
 }
 
+// clearOwnedTopics clears the retained messages that p owns (see TopicOwnerPlugin), except for those on topics in keep.
+func (c *CronJob) clearOwnedTopics(ctx context.Context, p TopicOwnerPlugin, keep map[string]mqtt.RetainMode) error {
+	filter, owns := p.OwnedTopics(c)
+	defer logutil.StartTimerLogger(log.Ctx(ctx).With().Str("filter", filter).Logger(), zerolog.DebugLevel, "Clearing owned topics").Stop()
+
+	ms := make(chan mqtt.Message, 100)
+	timeout := 200 * time.Millisecond // TODO: Make this configutable
+	if err := discoverRetainedMessages(ctx, filter, c.client, timeout, chan<- mqtt.Message(ms)); err != nil {
+		return err
+	}
+
+	var errs error
+	for m := range ms {
+		if _, ok := keep[m.Topic()]; ok || !owns(m) {
+			m.Ack()
+			continue
+		}
+		if err := c.unpublishTopic(ctx, m.Topic()); err != nil {
+			errs = multierr.Append(errs, err)
+		} else {
+			m.Ack()
+		}
+	}
+	return errs
+}
+
 func (c *CronJob) unpublishPrefix(ctx context.Context, prefix string) error {
 	defer logutil.StartTimerLogger(log.Ctx(ctx).With().Str("prefix", prefix).Logger(), zerolog.DebugLevel, "Unpublishing by prefix").Stop()
 
@@ -353,7 +565,7 @@ func (c *CronJob) unpublishPrefix(ctx context.Context, prefix string) error {
 
 	var errs error
 	for m := range ms {
-		if err := c.unpublishTopic(m.Topic()); err != nil {
+		if err := c.unpublishTopic(ctx, m.Topic()); err != nil {
 			errs = multierr.Append(errs, err)
 		} else {
 			m.Ack()
@@ -362,8 +574,8 @@ func (c *CronJob) unpublishPrefix(ctx context.Context, prefix string) error {
 	return errs
 }
 
-func (c *CronJob) unpublishTopic(topic string) error {
-	return c.client.Publish(topic, mqtt.QoSExactlyOnce, mqtt.Retain, "")
+func (c *CronJob) unpublishTopic(ctx context.Context, topic string) error {
+	return c.client.Publish(ctx, topic, mqtt.QoSExactlyOnce, mqtt.Retain, "")
 }
 
 type Device struct {
@@ -374,7 +586,21 @@ type Device struct {
 	topicPrefix string
 }
 
+// CurrentDevice returns the device that cron2mqtt is running on, whose topics are determined by SetTopicLayout.
 func CurrentDevice() (Device, error) {
+	return currentDevice(topicLayout)
+}
+
+// DeviceWithLayout is like CurrentDevice, but its topics are determined by l instead.
+func DeviceWithLayout(l TopicLayout) (Device, error) {
+	p, err := parseTopicLayout(l)
+	if err != nil {
+		return Device{}, err
+	}
+	return currentDevice(p)
+}
+
+func currentDevice(l parsedTopicLayout) (Device, error) {
 	id, err := machineid.ID()
 	if err != nil {
 		return Device{}, fmt.Errorf("could not determine machineid: %w", err)
@@ -390,7 +616,15 @@ func CurrentDevice() (Device, error) {
 		return Device{}, fmt.Errorf("could not determine hostname: %w", err)
 	}
 
-	pre := fmt.Sprintf("cron2mqtt/%s/%s", id, u.Uid)
+	pre, err := l.topicPrefix(DeviceTopicData{
+		MachineID: id,
+		Hostname:  strings.SplitN(h, ".", 2)[0],
+		User:      u.Username,
+		UID:       u.Uid,
+	})
+	if err != nil {
+		return Device{}, err
+	}
 	return Device{id, u, h, pre}, nil
 }
 
@@ -1,13 +1,20 @@
 package mqttcron
 
 import (
+	"context"
 	"errors"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
 
 	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/harness"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
 	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
 )
 
 func TestPluginInit(t *testing.T) {
@@ -165,11 +172,108 @@ func TestSameCron2mqttCommand(t *testing.T) {
 }
 
 func TestDiscoverLocalCronjobIfPossible(t *testing.T) {
-	// TODO
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	// Like with exec, the command is known from its arguments.
+	args := []string{"cron2mqtt", "exec", "id", "echo", "true"}
+
+	for _, tc := range []struct {
+		name    string
+		crontab string
+
+		wantSchedule string // Empty if the schedule shouldn't be known.
+		wantCommand  string
+		wantEnabled  *bool
+	}{
+		{
+			name:         "found",
+			crontab:      "0 * * * * cron2mqtt exec id echo true\n",
+			wantSchedule: "0 * * * *",
+			wantCommand:  "cron2mqtt exec id echo true",
+			wantEnabled:  new.Ptr(true),
+		},
+		{
+			name:         "disabled",
+			crontab:      "#0 * * * * cron2mqtt exec id echo true\n",
+			wantSchedule: "0 * * * *",
+			wantCommand:  "cron2mqtt exec id echo true",
+			wantEnabled:  new.Ptr(false),
+		},
+		{
+			name:         "command is written differently",
+			crontab:      "0 * * * * cron2mqtt  exec id echo   true\n",
+			wantSchedule: "0 * * * *",
+			wantCommand:  "cron2mqtt  exec id echo   true",
+			wantEnabled:  new.Ptr(true),
+		},
+		{
+			name:         "different command",
+			crontab:      "0 * * * * cron2mqtt exec id echo false\n",
+			wantSchedule: "0 * * * *",
+			wantCommand:  "cron2mqtt exec id echo true",
+			wantEnabled:  new.Ptr(true),
+		},
+		{
+			name:        "not in crontab",
+			crontab:     "0 * * * * cron2mqtt exec other echo true\n",
+			wantCommand: "cron2mqtt exec id echo true",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			harness.UseTabs(t, cron.NewMemTab("test", d.User, tc.crontab))
+
+			cj, err := NewCronJob("id", mqtt.NewClientForTesting(mqttfake.NewClient()), CronJobCommand(args))
+			if err != nil {
+				t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+			}
+
+			var gotSchedule string
+			if cj.Schedule != nil {
+				gotSchedule = cj.Schedule.String()
+			}
+			if gotSchedule != tc.wantSchedule {
+				t.Errorf("Schedule = %q, want %q", gotSchedule, tc.wantSchedule)
+			}
+			if got := cj.Command.String(); got != tc.wantCommand {
+				t.Errorf("Command = %q, want %q", got, tc.wantCommand)
+			}
+			if diff := cmp.Diff(tc.wantEnabled, cj.Enabled); diff != "" {
+				t.Errorf("Enabled mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
 }
 
 func TestUnpublish(t *testing.T) {
-	// TODO
+	f := mqttfake.NewClient()
+	c := mqtt.NewClientForTesting(f)
+	cj, err := NewCronJob("id", c, CronJobPlugins(
+		&plugin{topic: "elsewhere/id", topicRetain: mqtt.Retain, createPublishPayload: "foo", createPublishRetain: mqtt.Retain}))
+	if err != nil {
+		t.Fatalf("NewCronJob failed: %s", err)
+	}
+	// Cron jobs whose IDs start with the same characters must not be affected.
+	if _, err := NewCronJob("id2", c); err != nil {
+		t.Fatalf("NewCronJob failed: %s", err)
+	}
+	want := make(map[string]string)
+	for topic, payload := range f.Retained() {
+		if strings.Contains(topic, "/id2/") {
+			want[topic] = payload
+		}
+	}
+
+	ctx, canc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer canc()
+	if err := cj.Unpublish(ctx); err != nil {
+		t.Fatalf("Unpublish failed: %s", err)
+	}
+
+	if diff := cmp.Diff(want, f.Retained()); diff != "" {
+		t.Errorf("Retained messages diff (-want +got):\n%s", diff)
+	}
 }
 
 type plugin struct {
@@ -53,7 +53,7 @@ func DiscoverRemoteCronJobs(ctx context.Context, c Client, fs ...func() Plugin)
 
 // discoverRetainedMessages subscribes to topic and reports any retained messages on ch.
 //
-// discoverRetainedMessages will continue discovering retained messages until either the provided context is done, or keepAlive has elapsed since we received the last retained message. If keepAlive is <= 0, it will be ignored.
+// discoverRetainedMessages will continue discovering retained messages until either the provided context is done, or keepAlive has elapsed since we subscribed or received the last retained message. If keepAlive is <= 0, it will be ignored.
 func discoverRetainedMessages(ctx context.Context, topic string, c Client, keepAlive time.Duration, ch chan<- mqtt.Message) error {
 	origCtx := ctx
 	ctx, canc := context.WithCancel(ctx)
@@ -70,6 +70,10 @@ func discoverRetainedMessages(ctx context.Context, topic string, c Client, keepA
 		defer close(ch)
 		defer canc()
 		var timeout <-chan time.Time
+		// There might not be any retained messages at all.
+		if keepAlive > 0 {
+			timeout = time.After(keepAlive)
+		}
 		for {
 			select {
 			case <-timeout:
@@ -103,6 +107,15 @@ func DiscoverLocalCronJobsByID(cts []cron.Tab, u *user.User, ids []string) (map[
 	for _, id := range ids {
 		idSet[id] = true
 	}
+	return discoverLocalCronJobs(cts, u, idSet)
+}
+
+// DiscoverLocalCronJobs looks at local crontabs for all cron jobs that are monitored by cron2mqtt.
+func DiscoverLocalCronJobs(cts []cron.Tab, u *user.User) (map[string]*cron.Job, error) {
+	return discoverLocalCronJobs(cts, u, nil)
+}
+
+func discoverLocalCronJobs(cts []cron.Tab, u *user.User, idSet map[string]bool) (map[string]*cron.Job, error) {
 	foundCt := make(map[string]cron.Tab)
 	cjs := make(map[string]*cron.Job)
 
@@ -113,36 +126,67 @@ func DiscoverLocalCronJobsByID(cts []cron.Tab, u *user.User, ids []string) (map[
 		}
 
 		for _, j := range t.Jobs() {
-			if j.User == nil || j.User.Uid != u.Uid {
-				continue
-			}
-			if !j.Command.IsCron2Mqtt() {
-				continue
-			}
-
-			// Check to see if any of the cron job's arguments are one of the remote cron job IDs.
-			args, ok := j.Command.Args()
+			id, ok := cronJobID(j, u, idSet)
 			if !ok {
 				continue
 			}
-			// The cron job's command will be at a minimum "cron2mqtt exec ID ...", so only start looking at the third element.
-			// Technically we're looking at more arugments than necessary, but it seems unlikely we'd have a false positive.
-			for _, arg := range args[2:] {
-				if !idSet[arg] {
-					continue
-				}
-				id := arg
 
-				if cj, ok := cjs[id]; ok {
-					log.Warn().Str("id", id).Msgf("Discovered ID multiple times:\n%s\n%s\n\n%s\n%s\n", foundCt[id], cj, ct, j)
-				} else {
-					foundCt[id] = ct
-					cjs[id] = j
-				}
-				break
+			if cj, ok := cjs[id]; ok {
+				log.Warn().Str("id", id).Msgf("Discovered ID multiple times:\n%s\n%s\n\n%s\n%s\n", foundCt[id], cj, ct, j)
+			} else {
+				foundCt[id] = ct
+				cjs[id] = j
 			}
 		}
 	}
 
 	return cjs, nil
 }
+
+// cronJobID checks whether j is a cron2mqtt job for u identified by one of the entries in idSet. If idSet is nil, any ID is accepted.
+func cronJobID(j *cron.Job, u *user.User, idSet map[string]bool) (string, bool) {
+	if j.User == nil || j.User.Uid != u.Uid {
+		return "", false
+	}
+	if idSet == nil {
+		return localCronJobID(j.Command)
+	}
+	if !j.Command.IsCron2Mqtt() {
+		return "", false
+	}
+
+	// Check to see if any of the cron job's arguments are one of the remote cron job IDs.
+	args, ok := j.Command.Args()
+	if !ok {
+		return "", false
+	}
+	// The cron job's command will be at a minimum "cron2mqtt exec ID ...", so only start looking at the third element.
+	// Technically we're looking at more arugments than necessary, but it seems unlikely we'd have a false positive.
+	for _, arg := range args[2:] {
+		if idSet[arg] {
+			return arg, true
+		}
+	}
+	return "", false
+}
+
+// localCronJobID parses the ID from a command that looks like "cron2mqtt exec [flags] ID ..."
+func localCronJobID(c *cron.Command) (string, bool) {
+	if !c.IsCron2Mqtt() {
+		return "", false
+	}
+	args, _ := c.Args()
+	if len(args) < 3 || args[1] != "exec" {
+		return "", false
+	}
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if ValidateTopicComponent(arg) != nil {
+			return "", false
+		}
+		return arg, true
+	}
+	return "", false
+}
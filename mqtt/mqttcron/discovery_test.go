@@ -0,0 +1,129 @@
+package mqttcron
+
+import (
+	"context"
+	"os/user"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+func TestLocalCronJobID(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cmd  string
+
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "simple",
+			cmd:  "cron2mqtt exec abcd echo true",
+
+			want:   "abcd",
+			wantOK: true,
+		},
+		{
+			name: "flags",
+			cmd:  "/usr/bin/cron2mqtt exec -vvv abcd echo true",
+
+			want:   "abcd",
+			wantOK: true,
+		},
+		{
+			name: "not cron2mqtt",
+			cmd:  "echo exec abcd",
+		},
+		{
+			name: "not exec",
+			cmd:  "cron2mqtt prune",
+		},
+		{
+			name: "missing ID",
+			cmd:  "cron2mqtt exec -v",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := localCronJobID(cron.NewCommand(tc.cmd))
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("localCronJobID(%q) = %q, %t, want %q, %t", tc.cmd, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestDiscoverRemoteCronJobs(t *testing.T) {
+	f := mqttfake.NewClient()
+	c := mqtt.NewClientForTesting(f)
+	for _, id := range []string{"b", "a"} {
+		if _, err := NewCronJob(id, c); err != nil {
+			t.Fatalf("NewCronJob(%q) failed: %s", id, err)
+		}
+	}
+	// Cron jobs on other devices shouldn't be discovered.
+	if err := c.Publish(context.Background(), DefaultTopicRoot+"/other/device/c/discovery", mqtt.QoSExactlyOnce, mqtt.Retain, "1"); err != nil {
+		t.Fatalf("Publish failed: %s", err)
+	}
+
+	ctx, canc := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer canc()
+	cjs, err := DiscoverRemoteCronJobs(ctx, c)
+	if err != nil {
+		t.Fatalf("DiscoverRemoteCronJobs failed: %s", err)
+	}
+	var got []string
+	for _, cj := range cjs {
+		got = append(got, cj.ID())
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("DiscoverRemoteCronJobs diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiscoverLocalCronJobsByID(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatalf("Could not determine current user: %s", err)
+	}
+	other := &user.User{Uid: u.Uid + "0", Username: "other"}
+	cts := []cron.Tab{
+		cron.NewMemTab("user", u, strings.Join([]string{
+			"0 * * * * cron2mqtt exec a echo a",
+			"0 * * * * cron2mqtt exec unwanted echo unwanted",
+			// Not a cron2mqtt command, even though it mentions an ID.
+			"0 * * * * echo exec b",
+			"",
+		}, "\n")),
+		cron.NewMemTab("other user", other, "0 * * * * cron2mqtt exec c echo c\n"),
+		cron.NewMemTab("system", nil, strings.Join([]string{
+			"0 * * * * " + u.Username + " cron2mqtt exec -v b echo b",
+			"0 * * * * " + u.Username + " cron2mqtt exec a echo duplicate",
+			"",
+		}, "\n")),
+	}
+
+	js, err := DiscoverLocalCronJobsByID(cts, u, []string{"a", "b", "c", "missing"})
+	if err != nil {
+		t.Fatalf("DiscoverLocalCronJobsByID failed: %s", err)
+	}
+	got := make(map[string]string)
+	for id, j := range js {
+		got[id] = j.Command.String()
+	}
+	want := map[string]string{
+		// The first crontab wins.
+		"a": "cron2mqtt exec a echo a",
+		"b": "cron2mqtt exec -v b echo b",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DiscoverLocalCronJobsByID mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,98 @@
+package mqttcron
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+// EnableRequest is a request received over MQTT to enable or disable a cron job.
+type EnableRequest struct {
+	ID     string
+	Enable bool
+}
+
+// SubscribeEnableRequests listens for messages on any of the current device's CorePlugin.EnabledCommandTopics, and reports them on ch.
+//
+// ch will be closed once ctx is done.
+func SubscribeEnableRequests(ctx context.Context, c Subscriber, ch chan<- EnableRequest) error {
+	d, err := CurrentDevice()
+	if err != nil {
+		close(ch)
+		return err
+	}
+
+	pre := d.topicPrefix + "/"
+	post := "/enabled/set"
+	ms := make(chan mqtt.Message, 100)
+	if err := c.Subscribe(ctx, pre+"+"+post, mqtt.QoSExactlyOnce, chan<- mqtt.Message(ms)); err != nil {
+		close(ch)
+		return fmt.Errorf("could not subscribe to MQTT: %w", err)
+	}
+
+	go func() {
+		defer close(ch)
+		for m := range ms {
+			id := m.Topic()
+			id = strings.TrimPrefix(id, pre)
+			id = strings.TrimSuffix(id, post)
+
+			var req EnableRequest
+			switch p := string(m.Payload()); p {
+			case EnabledPayload:
+				req = EnableRequest{id, true}
+			case DisabledPayload:
+				req = EnableRequest{id, false}
+			default:
+				log.Warn().Str("topic", m.Topic()).Str("payload", p).Msg("Ignoring unknown enable request")
+				// Acknowledge it anyway, so that the broker doesn't redeliver it.
+				m.Ack()
+				continue
+			}
+
+			m.Ack()
+			select {
+			case <-ctx.Done():
+			case ch <- req:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// SetLocalCronJobEnabled looks for the cron job identified by id in the local crontabs, and comments or uncomments it.
+//
+// The crontab is only updated if the cron job isn't already in the requested state.
+func SetLocalCronJobEnabled(cts []cron.Tab, u *user.User, id string, enabled bool) (*cron.Job, error) {
+	idSet := map[string]bool{id: true}
+	for _, ct := range cts {
+		t, err := ct.Load()
+		if err != nil {
+			return nil, fmt.Errorf("could not load %s: %w", ct, err)
+		}
+
+		for _, j := range t.Jobs() {
+			if _, ok := cronJobID(j, u, idSet); !ok {
+				continue
+			}
+
+			if j.Enabled() == enabled {
+				return j, nil
+			}
+			j.SetEnabled(enabled)
+			if err := ct.Update(t); err != nil {
+				return nil, err
+			}
+			return j, nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not find cron job %q in any crontab for %q", id, u.Username)
+}
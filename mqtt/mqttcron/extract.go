@@ -0,0 +1,159 @@
+package mqttcron
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Extractor extracts a named value from a cron job's stdout (e.g. "Backed up 1234 files").
+type Extractor struct {
+	Name string `mapstructure:"name"`
+
+	// Exactly one of Regex and JSONPath must be set.
+	//
+	// If Regex has a named group called Name, that's the extracted value. Otherwise, it's the first group, or the entire match if there are no groups.
+	// This lets multiple Extractors share the same Regex.
+	Regex string `mapstructure:"regex"`
+	// JSONPath is evaluated over stdout, which must be JSON. Only a subset of JSONPath is supported: object members ($.foo or $['foo']) and array indices ($[0]).
+	JSONPath string `mapstructure:"json_path"`
+
+	// Unit, DeviceClass and StateClass describe the value to plugins that present it (e.g. hass.Plugin).
+	Unit        string `mapstructure:"unit"`
+	DeviceClass string `mapstructure:"device_class"`
+	StateClass  string `mapstructure:"state_class"`
+
+	re   *regexp.Regexp
+	path []interface{} // Either string (object member) or int (array index).
+}
+
+// CronJobExtractors extracts values from the cron job's stdout whenever its result is published.
+func CronJobExtractors(es ...Extractor) CronJobOption {
+	return func(cj *CronJob) {
+		cj.extractors = append(cj.extractors, es...)
+	}
+}
+
+// Extractors returns the cron job's Extractors (see CronJobExtractors).
+func (c *CronJob) Extractors() []Extractor {
+	return c.extractors
+}
+
+// compileExtractors validates each of es, and prepares them to extract values.
+func compileExtractors(es []Extractor) error {
+	names := make(map[string]bool)
+	for i := range es {
+		e := &es[i]
+		if err := ValidateTopicComponent(e.Name); err != nil || e.Name == "" {
+			return fmt.Errorf("extractor has an invalid name %q", e.Name)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("multiple extractors are named %q", e.Name)
+		}
+		names[e.Name] = true
+
+		switch {
+		case e.Regex != "" && e.JSONPath != "":
+			return fmt.Errorf("extractor %q has both a regex and a JSONPath", e.Name)
+		case e.Regex != "":
+			re, err := regexp.Compile(e.Regex)
+			if err != nil {
+				return fmt.Errorf("extractor %q has an invalid regex: %w", e.Name, err)
+			}
+			e.re = re
+		case e.JSONPath != "":
+			path, err := parseJSONPath(e.JSONPath)
+			if err != nil {
+				return fmt.Errorf("extractor %q has an invalid JSONPath: %w", e.Name, err)
+			}
+			e.path = path
+		default:
+			return fmt.Errorf("extractor %q needs a regex or a JSONPath", e.Name)
+		}
+	}
+	return nil
+}
+
+// extract returns the value extracted from stdout, or nil if it couldn't be found.
+// Numbers are returned as float64, and everything else as it appears in stdout.
+func (e Extractor) extract(stdout []byte) interface{} {
+	if e.re != nil {
+		m := e.re.FindSubmatch(stdout)
+		if m == nil {
+			return nil
+		}
+		i := 0
+		if j := e.re.SubexpIndex(e.Name); j > 0 {
+			i = j
+		} else if len(m) > 1 {
+			i = 1
+		}
+		s := string(m[i])
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f
+		}
+		return s
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(stdout, &v); err != nil {
+		return nil
+	}
+	for _, p := range e.path {
+		switch p := p.(type) {
+		case string:
+			o, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = o[p]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || p >= len(a) {
+				return nil
+			}
+			v = a[p]
+		}
+	}
+	return v
+}
+
+var jsonPathComponentRegexp = regexp.MustCompile(`^(?:\.([A-Za-z_][A-Za-z0-9_]*)|\['([^']*)'\]|\[([0-9]+)\])`)
+
+func parseJSONPath(s string) ([]interface{}, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("%q must start with $", s)
+	}
+	var path []interface{}
+	for rest := s[1:]; rest != ""; {
+		m := jsonPathComponentRegexp.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("%q has an unsupported component at %q", s, rest)
+		}
+		switch {
+		case m[1] != "":
+			path = append(path, m[1])
+		case m[3] != "":
+			i, err := strconv.Atoi(m[3])
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, i)
+		default:
+			path = append(path, m[2])
+		}
+		rest = rest[len(m[0]):]
+	}
+	return path, nil
+}
+
+// extractValues returns the values extracted by each of es. Values that couldn't be extracted are nil.
+func extractValues(es []Extractor, stdout []byte) map[string]interface{} {
+	vs := make(map[string]interface{})
+	for _, e := range es {
+		vs[e.Name] = e.extract(stdout)
+	}
+	return vs
+}
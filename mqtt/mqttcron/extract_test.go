@@ -0,0 +1,133 @@
+package mqttcron
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExtractValues(t *testing.T) {
+	backup := `Backed up (?P<files>\d+) files \((?P<size>[\d.]+) GB\)`
+	for _, tc := range []struct {
+		name string
+
+		extractors []Extractor
+		stdout     string
+
+		want map[string]interface{}
+	}{
+		{
+			name: "named groups",
+
+			extractors: []Extractor{{Name: "files", Regex: backup}, {Name: "size", Regex: backup}},
+			stdout:     "Starting backup\nBacked up 1234 files (5.6 GB)\n",
+
+			want: map[string]interface{}{"files": 1234.0, "size": 5.6},
+		},
+		{
+			name: "first group",
+
+			extractors: []Extractor{{Name: "status", Regex: `status: (\w+)`}},
+			stdout:     "status: ok",
+
+			want: map[string]interface{}{"status": "ok"},
+		},
+		{
+			name: "whole match",
+
+			extractors: []Extractor{{Name: "version", Regex: `v\d+\.\d+`}},
+			stdout:     "running v1.2",
+
+			want: map[string]interface{}{"version": "v1.2"},
+		},
+		{
+			name: "no match",
+
+			extractors: []Extractor{{Name: "files", Regex: backup}},
+			stdout:     "Backup failed",
+
+			want: map[string]interface{}{"files": nil},
+		},
+		{
+			name: "JSONPath",
+
+			extractors: []Extractor{
+				{Name: "download", JSONPath: "$.download.bandwidth"},
+				{Name: "server", JSONPath: "$['server']['name']"},
+				{Name: "first", JSONPath: "$.results[0]"},
+				{Name: "missing", JSONPath: "$.results[5]"},
+			},
+			stdout: `{"download": {"bandwidth": 12345}, "server": {"name": "foo"}, "results": [1, 2]}`,
+
+			want: map[string]interface{}{"download": 12345.0, "server": "foo", "first": 1.0, "missing": nil},
+		},
+		{
+			name: "JSONPath with invalid JSON",
+
+			extractors: []Extractor{{Name: "download", JSONPath: "$.download"}},
+			stdout:     "not json",
+
+			want: map[string]interface{}{"download": nil},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := compileExtractors(tc.extractors); err != nil {
+				t.Fatalf("compileExtractors failed: %s", err)
+			}
+			if diff := cmp.Diff(tc.want, extractValues(tc.extractors, []byte(tc.stdout))); diff != "" {
+				t.Errorf("extractValues diff (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompileExtractors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		extractors []Extractor
+
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:       "missing name",
+			extractors: []Extractor{{Regex: "foo"}},
+			wantErr:    regexp.MustCompile("invalid name"),
+		},
+		{
+			name:       "duplicate name",
+			extractors: []Extractor{{Name: "foo", Regex: "foo"}, {Name: "foo", Regex: "bar"}},
+			wantErr:    regexp.MustCompile(`multiple extractors are named "foo"`),
+		},
+		{
+			name:       "missing rule",
+			extractors: []Extractor{{Name: "foo"}},
+			wantErr:    regexp.MustCompile("needs a regex or a JSONPath"),
+		},
+		{
+			name:       "both rules",
+			extractors: []Extractor{{Name: "foo", Regex: "foo", JSONPath: "$.foo"}},
+			wantErr:    regexp.MustCompile("both a regex and a JSONPath"),
+		},
+		{
+			name:       "invalid regex",
+			extractors: []Extractor{{Name: "foo", Regex: "("}},
+			wantErr:    regexp.MustCompile("invalid regex"),
+		},
+		{
+			name:       "unsupported JSONPath",
+			extractors: []Extractor{{Name: "foo", JSONPath: "$..foo"}},
+			wantErr:    regexp.MustCompile("invalid JSONPath"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := compileExtractors(tc.extractors)
+			if err == nil {
+				t.Fatalf("compileExtractors did not fail, but expected error message to match %q", tc.wantErr)
+			} else if !tc.wantErr.MatchString(err.Error()) {
+				t.Errorf("compileExtractors failed with %v, wanted to match %q", err, tc.wantErr)
+			}
+		})
+	}
+}
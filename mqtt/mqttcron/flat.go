@@ -0,0 +1,104 @@
+package mqttcron
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+// FlatPlugin publishes each field of a cron job's results to its own topic as a plain value (e.g. "0" for the exit code), for consumers that can't easily parse JSON.
+type FlatPlugin struct {
+	NopPlugin
+	// Topics are keyed by field name (e.g. "exit_code").
+	Topics map[string]string
+
+	opts   FlatOptions
+	fields map[string]flatField
+}
+
+// FlatFieldOptions configures how a single field is published by FlatPlugin. Unset options use the field's defaults.
+type FlatFieldOptions struct {
+	QoS    *int  `mapstructure:"qos"`
+	Retain *bool `mapstructure:"retain"`
+}
+
+// FlatOptions configures the "flat" plugin.
+type FlatOptions struct {
+	// Fields are keyed by field name (e.g. "exit_code").
+	Fields map[string]FlatFieldOptions `mapstructure:"fields"`
+}
+
+type flatField struct {
+	qos    mqtt.QoS
+	retain mqtt.RetainMode
+	value  func(exec.Result) string
+}
+
+// flatFields are the fields published by FlatPlugin along with their default options.
+// Output isn't retained by default because it can be large.
+var flatFields = map[string]flatField{
+	"state":       {mqtt.QoSExactlyOnce, mqtt.Retain, ResultState},
+	"exit_code":   {mqtt.QoSExactlyOnce, mqtt.Retain, func(res exec.Result) string { return strconv.Itoa(res.ExitCode) }},
+	"duration_ms": {mqtt.QoSExactlyOnce, mqtt.Retain, func(res exec.Result) string { return strconv.FormatInt(res.End.Sub(res.Start).Milliseconds(), 10) }},
+	"last_run":    {mqtt.QoSExactlyOnce, mqtt.Retain, func(res exec.Result) string { return res.Start.Format(time.RFC3339) }},
+	"stdout":      {mqtt.QoSExactlyOnce, mqtt.DoNotRetain, func(res exec.Result) string { return string(res.Stdout) }},
+	"stderr":      {mqtt.QoSExactlyOnce, mqtt.DoNotRetain, func(res exec.Result) string { return string(res.Stderr) }},
+}
+
+func init() {
+	RegisterPlugin("flat", false, FlatOptions{}, NewFlatPlugin)
+}
+
+// NewFlatPlugin creates a FlatPlugin. Its options are validated by Init.
+func NewFlatPlugin(opts FlatOptions) Plugin {
+	return &FlatPlugin{opts: opts}
+}
+
+func (p *FlatPlugin) Init(cj *CronJob, reg TopicRegister) error {
+	p.fields = make(map[string]flatField)
+	for n, f := range flatFields {
+		p.fields[n] = f
+	}
+	for n, o := range p.opts.Fields {
+		f, ok := p.fields[n]
+		if !ok {
+			return fmt.Errorf("unknown field %q", n)
+		}
+		if o.QoS != nil {
+			if *o.QoS < int(mqtt.QoSAtMostOnce) || *o.QoS > int(mqtt.QoSExactlyOnce) {
+				return fmt.Errorf("field %q has an invalid QoS %d", n, *o.QoS)
+			}
+			f.qos = mqtt.QoS(*o.QoS)
+		}
+		if o.Retain != nil {
+			f.retain = mqtt.RetainMode(*o.Retain)
+		}
+		p.fields[n] = f
+	}
+
+	p.Topics = make(map[string]string)
+	for n := range p.fields {
+		p.Topics[n] = reg.RegisterSuffix(n)
+	}
+	return nil
+}
+
+func (p *FlatPlugin) PublishResult(cj *CronJob, pub Publisher, res exec.Result) error {
+	var ns []string
+	for n := range p.fields {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+
+	var fs []func() error
+	for _, n := range ns {
+		f := p.fields[n]
+		t := p.Topics[n]
+		fs = append(fs, func() error { return pub.Publish(t, f.qos, f.retain, f.value(res)) })
+	}
+	return MultiPublish(fs...)
+}
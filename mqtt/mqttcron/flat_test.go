@@ -0,0 +1,123 @@
+package mqttcron
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
+)
+
+func TestFlatPlugin(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	res := exec.Result{Start: start, End: start.Add(1500 * time.Millisecond), ExitCode: 1, Stdout: []byte("foo"), Stderr: []byte("bar")}
+
+	type published struct {
+		Payload string
+		QoS     byte
+		Retain  bool
+	}
+	for _, tc := range []struct {
+		name string
+
+		opts FlatOptions
+
+		want    map[string]published
+		wantErr *regexp.Regexp
+	}{
+		{
+			name: "defaults",
+
+			want: map[string]published{
+				"state":       {"failed", 2, true},
+				"exit_code":   {"1", 2, true},
+				"duration_ms": {"1500", 2, true},
+				"last_run":    {"2000-01-01T00:00:00Z", 2, true},
+				"stdout":      {"foo", 2, false},
+				"stderr":      {"bar", 2, false},
+			},
+		},
+		{
+			name: "field options",
+
+			opts: FlatOptions{Fields: map[string]FlatFieldOptions{
+				"stdout":    {QoS: new.Ptr(0), Retain: new.Ptr(true)},
+				"exit_code": {Retain: new.Ptr(false)},
+			}},
+
+			want: map[string]published{
+				"state":       {"failed", 2, true},
+				"exit_code":   {"1", 2, false},
+				"duration_ms": {"1500", 2, true},
+				"last_run":    {"2000-01-01T00:00:00Z", 2, true},
+				"stdout":      {"foo", 0, true},
+				"stderr":      {"bar", 2, false},
+			},
+		},
+		{
+			name: "unknown field",
+
+			opts:    FlatOptions{Fields: map[string]FlatFieldOptions{"foo": {}}},
+			wantErr: regexp.MustCompile(`unknown field "foo"`),
+		},
+		{
+			name: "invalid QoS",
+
+			opts:    FlatOptions{Fields: map[string]FlatFieldOptions{"stdout": {QoS: new.Ptr(3)}}},
+			wantErr: regexp.MustCompile(`invalid QoS 3`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := mqttfake.NewClient()
+			cj, err := NewCronJob("id", mqtt.NewClientForTesting(c), CronJobPlugins(NewFlatPlugin(tc.opts)))
+			if err != nil {
+				if tc.wantErr == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Fatalf("NewCronJob failed with %v, wanted to match %v", err, tc.wantErr)
+				}
+				return
+			} else if tc.wantErr != nil {
+				t.Fatalf("NewCronJob did not fail, but expected error message to match %q", tc.wantErr)
+			}
+			var fp *FlatPlugin
+			if !cj.Plugin(&fp) {
+				t.Fatalf("Could not find FlatPlugin")
+			}
+
+			var mut sync.Mutex
+			got := make(map[string]published)
+			for n, topic := range fp.Topics {
+				n := n
+				c.Subscribe(topic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+					mut.Lock()
+					defer mut.Unlock()
+					got[n] = published{Payload: string(m.Payload()), QoS: m.Qos()}
+				})
+			}
+
+			if err := cj.PublishResult(res); err != nil {
+				t.Fatalf("PublishResult failed: %s", err)
+			}
+
+			mut.Lock()
+			defer mut.Unlock()
+			retained := c.Retained()
+			for n, p := range got {
+				p.Retain = retained[fp.Topics[n]] == p.Payload
+				got[n] = p
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Published fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package mqttcron
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+const (
+	DefaultTopicRoot = "cron2mqtt"
+	// DefaultDeviceTopic identifies the device by an opaque hash of its machine ID, so that topics don't reveal anything about it.
+	DefaultDeviceTopic = "{{.MachineID}}/{{.UID}}"
+)
+
+// TopicLayout determines the topics that the current device's cron jobs are published to, i.e. <Root>/<Device>/<cron job ID>/...
+type TopicLayout struct {
+	// Root is the first part of every topic. It can contain multiple levels (e.g. "home/cron").
+	Root string `mapstructure:"root"`
+	// Device is a template (executed with DeviceTopicData) for the topic levels that identify the device, e.g. "{{.Hostname}}/{{.User}}" or a fixed string like "garage".
+	// Each user on a device should have their own topics, or they'll overwrite each other's cron jobs.
+	Device string `mapstructure:"device"`
+}
+
+var DefaultTopicLayout = TopicLayout{
+	Root:   DefaultTopicRoot,
+	Device: DefaultDeviceTopic,
+}
+
+// DeviceTopicData is the data available to TopicLayout.Device.
+type DeviceTopicData struct {
+	// MachineID is an opaque hash of the device's machine ID.
+	MachineID string
+	// Hostname is the device's host name, up to the first dot.
+	Hostname string
+	// User is the current user's username.
+	User string
+	UID  string
+}
+
+type parsedTopicLayout struct {
+	root   string
+	device *template.Template
+}
+
+var topicLayout = mustParseTopicLayout(DefaultTopicLayout)
+
+// SetTopicLayout changes the topics used by CurrentDevice. It should be called before any cron jobs are created.
+func SetTopicLayout(l TopicLayout) error {
+	p, err := parseTopicLayout(l)
+	if err != nil {
+		return err
+	}
+	topicLayout = p
+	return nil
+}
+
+func parseTopicLayout(l TopicLayout) (parsedTopicLayout, error) {
+	if l.Root == "" {
+		l.Root = DefaultTopicRoot
+	}
+	if l.Device == "" {
+		l.Device = DefaultDeviceTopic
+	}
+	if err := validateTopic(l.Root); err != nil {
+		return parsedTopicLayout{}, fmt.Errorf("invalid topic root: %w", err)
+	}
+	t, err := template.New("device").Option("missingkey=error").Parse(l.Device)
+	if err != nil {
+		return parsedTopicLayout{}, fmt.Errorf("invalid device topic template: %w", err)
+	}
+	return parsedTopicLayout{l.Root, t}, nil
+}
+
+func mustParseTopicLayout(l TopicLayout) parsedTopicLayout {
+	p, err := parseTopicLayout(l)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (l parsedTopicLayout) topicPrefix(d DeviceTopicData) (string, error) {
+	var b strings.Builder
+	if err := l.device.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("could not execute device topic template: %w", err)
+	}
+	if err := validateTopic(b.String()); err != nil {
+		return "", fmt.Errorf("invalid device topic: %w", err)
+	}
+	return l.root + "/" + b.String(), nil
+}
+
+// validateTopic checks that each level of t is a valid topic component.
+func validateTopic(t string) error {
+	for _, c := range strings.Split(t, "/") {
+		if err := ValidateTopicComponent(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TopicPrefix returns the prefix of the topics of all of the device's cron jobs.
+func (d Device) TopicPrefix() string {
+	return d.topicPrefix
+}
+
+// MigrateRetained moves the retained messages under from's topics to the same topics under to's, e.g. after the TopicLayout was changed.
+// Messages whose payloads refer to topics (e.g. Home Assistant's discovery configs) aren't updated, so cron jobs should be republished afterwards.
+// Retained messages are discovered for up to timeout. It returns the number of messages that were moved.
+func MigrateRetained(ctx context.Context, c Client, from, to Device, timeout time.Duration) (int, error) {
+	fromPre, toPre := from.topicPrefix+"/", to.topicPrefix+"/"
+	if strings.HasPrefix(fromPre, toPre) || strings.HasPrefix(toPre, fromPre) {
+		return 0, fmt.Errorf("can't migrate between overlapping topics %s and %s", from.topicPrefix, to.topicPrefix)
+	}
+
+	discoverCtx, canc := context.WithTimeout(ctx, timeout)
+	defer canc()
+	ms := make(chan mqtt.Message, 100)
+	if err := discoverRetainedMessages(discoverCtx, fromPre+"#", c, 0, chan<- mqtt.Message(ms)); err != nil {
+		return 0, err
+	}
+
+	var n int
+	var fs []func() error
+	for m := range ms {
+		m := m
+		if len(m.Payload()) == 0 {
+			continue
+		}
+		n++
+		fs = append(fs, func() error {
+			t := toPre + strings.TrimPrefix(m.Topic(), fromPre)
+			if err := c.Publish(ctx, t, mqtt.QoSExactlyOnce, mqtt.Retain, m.Payload()); err != nil {
+				return fmt.Errorf("could not move %s to %s: %w", m.Topic(), t, err)
+			}
+			if err := c.Publish(ctx, m.Topic(), mqtt.QoSExactlyOnce, mqtt.Retain, ""); err != nil {
+				return fmt.Errorf("could not clear %s: %w", m.Topic(), err)
+			}
+			m.Ack()
+			return nil
+		})
+	}
+	return n, MultiPublish(fs...)
+}
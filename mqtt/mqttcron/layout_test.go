@@ -0,0 +1,132 @@
+package mqttcron
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+func TestDeviceWithLayout(t *testing.T) {
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	host := strings.SplitN(d.Hostname, ".", 2)[0]
+
+	for _, tc := range []struct {
+		name   string
+		layout TopicLayout
+
+		want    string
+		wantErr *regexp.Regexp
+	}{
+		{
+			name: "defaults",
+			want: "cron2mqtt/" + d.ID + "/" + d.User.Uid,
+		},
+		{
+			name:   "hostname",
+			layout: TopicLayout{Root: "home", Device: "{{.Hostname}}/{{.User}}"},
+			want:   "home/" + host + "/" + d.User.Username,
+		},
+		{
+			name:   "custom string",
+			layout: TopicLayout{Root: "home/cron", Device: "garage"},
+			want:   "home/cron/garage",
+		},
+		{
+			name:    "invalid root",
+			layout:  TopicLayout{Root: "home/#"},
+			wantErr: regexp.MustCompile("invalid topic root"),
+		},
+		{
+			name:    "invalid device topic",
+			layout:  TopicLayout{Device: "{{.User}}/+"},
+			wantErr: regexp.MustCompile("invalid device topic"),
+		},
+		{
+			name:    "unknown field",
+			layout:  TopicLayout{Device: "{{.Foo}}"},
+			wantErr: regexp.MustCompile("could not execute device topic template"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DeviceWithLayout(tc.layout)
+			if err != nil {
+				if tc.wantErr == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Fatalf("DeviceWithLayout failed with %v, wanted to match %v", err, tc.wantErr)
+				}
+				return
+			} else if tc.wantErr != nil {
+				t.Fatalf("DeviceWithLayout did not fail, but expected error message to match %q", tc.wantErr)
+			}
+			if got.TopicPrefix() != tc.want {
+				t.Errorf("DeviceWithLayout(%+v).TopicPrefix() = %q, want %q", tc.layout, got.TopicPrefix(), tc.want)
+			}
+		})
+	}
+}
+
+func TestSetTopicLayout(t *testing.T) {
+	defer SetTopicLayout(DefaultTopicLayout)
+	if err := SetTopicLayout(TopicLayout{Root: "home", Device: "garage"}); err != nil {
+		t.Fatalf("SetTopicLayout failed: %s", err)
+	}
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	if got, want := d.AvailabilityTopic(), "home/garage/availability"; got != want {
+		t.Errorf("AvailabilityTopic() = %q, want %q", got, want)
+	}
+}
+
+func TestMigrateRetained(t *testing.T) {
+	from, err := DeviceWithLayout(TopicLayout{Root: "old", Device: "device"})
+	if err != nil {
+		t.Fatalf("DeviceWithLayout failed: %s", err)
+	}
+	to, err := DeviceWithLayout(TopicLayout{Root: "new", Device: "device"})
+	if err != nil {
+		t.Fatalf("DeviceWithLayout failed: %s", err)
+	}
+
+	f := mqttfake.NewClient()
+	c := mqtt.NewClientForTesting(f)
+	for topic, payload := range map[string]string{
+		"old/device/id/discovery": "1",
+		"old/device/availability": OnlinePayload,
+		"old/other/id/discovery":  "1",
+	} {
+		if err := c.Publish(context.Background(), topic, mqtt.QoSExactlyOnce, mqtt.Retain, payload); err != nil {
+			t.Fatalf("Publish failed: %s", err)
+		}
+	}
+
+	n, err := MigrateRetained(context.Background(), c, from, to, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("MigrateRetained failed: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("MigrateRetained moved %d messages, want 2", n)
+	}
+	want := map[string]string{
+		"new/device/id/discovery": "1",
+		"new/device/availability": OnlinePayload,
+		"old/other/id/discovery":  "1",
+	}
+	if diff := cmp.Diff(want, f.Retained()); diff != "" {
+		t.Errorf("Retained messages diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := MigrateRetained(context.Background(), c, from, from, time.Millisecond); err == nil {
+		t.Errorf("MigrateRetained succeeded between overlapping topics")
+	}
+}
@@ -0,0 +1,53 @@
+package mqttcron
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+)
+
+// MissedRun describes whether a cron job missed its most recent scheduled execution.
+type MissedRun struct {
+	Missed bool
+	// ExpectedTime is when the cron job was expected to start after LastStartTime. It's the zero value if the cron job isn't expected to run (e.g. it's disabled).
+	ExpectedTime  time.Time
+	LastStartTime time.Time
+}
+
+// CheckMissedRun determines whether a cron job with the given schedule that last started at lastStart should have started again by now.
+// The cron job is allowed to be up to grace late before it's considered missed.
+func CheckMissedRun(s cron.Schedule, lastStart time.Time, now time.Time, grace time.Duration) MissedRun {
+	exp := s.Next(lastStart)
+	return MissedRun{
+		Missed:        now.After(exp.Add(grace)),
+		ExpectedTime:  exp,
+		LastStartTime: lastStart,
+	}
+}
+
+// MissedRunPlugin is an optional interface for Plugins that want to report on missed executions.
+type MissedRunPlugin interface {
+	PublishMissedRun(cj *CronJob, pub Publisher, m MissedRun) error
+}
+
+// PublishMissedRun publishes one or more messages to MQTT about whether this cron job missed its most recent scheduled execution.
+func (c *CronJob) PublishMissedRun(m MissedRun) error {
+	var fs []func() error
+	for _, p := range c.plugins {
+		p := p
+		mp, ok := p.(MissedRunPlugin)
+		if !ok {
+			continue
+		}
+		fs = append(fs, func() error {
+			defer logutil.StartTimerLogger(log.Logger.With().Str("plugin", fmt.Sprintf("%T", p)).Logger(), zerolog.TraceLevel, "Plugin#PublishMissedRun").Stop()
+			return mp.PublishMissedRun(c, c.publisher(c.ctx, p), m)
+		})
+	}
+	return MultiPublish(fs...)
+}
@@ -0,0 +1,64 @@
+package mqttcron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+)
+
+func TestCheckMissedRun(t *testing.T) {
+	topOfTheHour, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	s, err := cron.NewSchedule("0 * * * *")
+	if err != nil {
+		t.Fatalf("Could not parse testing schedule: %s", err)
+	}
+	lastStart := topOfTheHour.Add(time.Second)
+
+	for _, tc := range []struct {
+		name string
+
+		now   time.Time
+		grace time.Duration
+
+		want bool
+	}{
+		{
+			name: "before next execution",
+
+			now:   topOfTheHour.Add(30 * time.Minute),
+			grace: time.Minute,
+
+			want: false,
+		},
+		{
+			name: "within grace period",
+
+			now:   topOfTheHour.Add(time.Hour + 30*time.Second),
+			grace: time.Minute,
+
+			want: false,
+		},
+		{
+			name: "missed",
+
+			now:   topOfTheHour.Add(time.Hour + 2*time.Minute),
+			grace: time.Minute,
+
+			want: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := CheckMissedRun(s, lastStart, tc.now, tc.grace)
+			if m.Missed != tc.want {
+				t.Errorf("CheckMissedRun(%s).Missed = %t, want %t", tc.now, m.Missed, tc.want)
+			}
+			if want := topOfTheHour.Add(time.Hour); !m.ExpectedTime.Equal(want) {
+				t.Errorf("CheckMissedRun(%s).ExpectedTime = %s, want %s", tc.now, m.ExpectedTime, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package mqttcron
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+)
+
+// DefaultNameTemplate is used to name cron jobs unless CronJobNameTemplate is provided.
+const DefaultNameTemplate = "[{{.User}}@{{.Host}}] {{.Name}}"
+
+var defaultNameTemplate = template.Must(ParseNameTemplate(DefaultNameTemplate))
+
+// NameData is what's available to name templates.
+type NameData struct {
+	ID string
+	// Name is the cron job's friendly name (see CronJobName), or a guess based on its command if it doesn't have one.
+	Name        string
+	Description string
+	User        string
+	Host        string
+	// Schedule and Command are empty if they're unknown.
+	Schedule string
+	Command  string
+}
+
+// ParseNameTemplate parses a text/template that names cron jobs using NameData (e.g. DefaultNameTemplate).
+func ParseNameTemplate(s string) (*template.Template, error) {
+	t, err := template.New("name").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %w", err)
+	}
+	return t, nil
+}
+
+// CronJobName gives the cron job a friendly name and description. The name is used instead of guessing one from the cron job's command.
+func CronJobName(name, description string) CronJobOption {
+	return func(cj *CronJob) {
+		cj.friendlyName = name
+		cj.description = description
+	}
+}
+
+// CronJobNameTemplate determines how the cron job's name is presented (see CronJob.Name).
+func CronJobNameTemplate(t *template.Template) CronJobOption {
+	return func(cj *CronJob) {
+		cj.nameTemplate = t
+	}
+}
+
+// Name returns the cron job's name, formatted by its name template (see CronJobNameTemplate).
+func (c *CronJob) Name() string {
+	return c.name
+}
+
+// Description returns the cron job's description (see CronJobName), if it has one.
+func (c *CronJob) Description() string {
+	return c.description
+}
+
+func (c *CronJob) formatName(d Device) (string, error) {
+	nd := NameData{
+		ID:          c.id,
+		Name:        c.friendlyName,
+		Description: c.description,
+		User:        d.User.Username,
+		Host:        d.Hostname,
+	}
+	if nd.Name == "" {
+		nd.Name = commandName(c.id, c.Command)
+	}
+	if c.Schedule != nil {
+		nd.Schedule = c.Schedule.String()
+	}
+	if c.Command != nil {
+		nd.Command = c.Command.String()
+	}
+
+	t := c.nameTemplate
+	if t == nil {
+		t = defaultNameTemplate
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, nd); err != nil {
+		return "", fmt.Errorf("could not name cron job: %w", err)
+	}
+	return b.String(), nil
+}
+
+// commandName guesses a name for the cron job based on the command that cron2mqtt is executing for it.
+func commandName(id string, c *cron.Command) string {
+	if c == nil {
+		return id
+	}
+	args, ok := c.Args()
+	if !ok {
+		return c.String()
+	}
+
+	var shArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			shArgs = args[i+1:]
+			break
+		}
+		if arg == id && i < len(args)-1 {
+			shArgs = []string{}
+			continue
+		}
+		if shArgs == nil || strings.HasPrefix(arg, "-") {
+			continue
+		}
+		shArgs = append(shArgs, arg)
+	}
+	if len(shArgs) > 0 {
+		if sp, err := shellquote.Split(strings.Join(shArgs, " ")); err == nil {
+			return strings.Join(sp, " ")
+		}
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,205 @@
+package mqttcron
+
+import (
+	"testing"
+
+	"github.com/JeffreyFalgout/cron2mqtt/cron"
+	"github.com/JeffreyFalgout/cron2mqtt/harness"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+func TestCommandName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+
+		id  string
+		cmd string
+
+		want string
+	}{
+		{
+			name: "simple",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd echo true",
+
+			want: "echo true",
+		},
+		{
+			name: "simple with flags",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd -vvv echo true",
+
+			want: "echo true",
+		},
+		{
+			name: "ambiguous flag",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd echo true -vvv",
+
+			want: "echo true",
+		},
+		{
+			name: "dashdash",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd -- echo true",
+
+			want: "echo true",
+		},
+		{
+			name: "dashdash with flags",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd -vvv -- echo -n true",
+
+			want: "echo -n true",
+		},
+		{
+			name: "quoted command",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd 'echo true'",
+
+			want: "echo true",
+		},
+		{
+			name: "quoted command with flags",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd -vvv 'echo -n true'",
+
+			want: "echo -n true",
+		},
+		{
+			name: "unsplittable",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd 'echo true",
+
+			want: "cron2mqtt exec abcd 'echo true",
+		},
+		{
+			name: "missing command",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd",
+
+			want: "cron2mqtt exec abcd",
+		},
+		{
+			name: "dashdash missing command",
+
+			id:  "abcd",
+			cmd: "cron2mqtt exec abcd --",
+
+			want: "cron2mqtt exec abcd --",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := cron.NewCommand(tc.cmd)
+			if got := commandName(tc.id, cmd); got != tc.want {
+				t.Errorf("commandName(%q, %q) = %q, want %q", tc.id, tc.cmd, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCronJobName(t *testing.T) {
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	s, err := cron.NewSchedule("0 * * * *")
+	if err != nil {
+		t.Fatalf("Could not parse testing schedule: %s", err)
+	}
+	j := &cron.Job{Schedule: s, Command: cron.NewCommand("cron2mqtt exec id echo true")}
+
+	for _, tc := range []struct {
+		name string
+
+		template string
+		opts     []CronJobOption
+
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "default",
+
+			want: "[" + d.User.Username + "@" + d.Hostname + "] echo true",
+		},
+		{
+			name: "friendly name",
+
+			opts: []CronJobOption{CronJobName("Echo", "")},
+
+			want: "[" + d.User.Username + "@" + d.Hostname + "] Echo",
+		},
+		{
+			name: "template",
+
+			template: "{{.Name}} ({{.ID}}, {{.Schedule}}): {{.Description}}",
+			opts:     []CronJobOption{CronJobName("Echo", "Says true")},
+
+			want: "Echo (id, 0 * * * *): Says true",
+		},
+		{
+			name: "unknown field",
+
+			template: "{{.Foo}}",
+
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := append([]CronJobOption{CronJobConfig(j)}, tc.opts...)
+			if tc.template != "" {
+				nt, err := ParseNameTemplate(tc.template)
+				if err != nil {
+					t.Fatalf("ParseNameTemplate(%q) yielded an unexpected error: %s", tc.template, err)
+				}
+				opts = append(opts, CronJobNameTemplate(nt))
+			}
+
+			cj, err := NewCronJob("id", mqtt.NewClientForTesting(mqttfake.NewClient()), opts...)
+			if err != nil {
+				if !tc.wantErr {
+					t.Errorf("NewCronJob unexpectedly failed with %v", err)
+				}
+				return
+			}
+			if tc.wantErr {
+				t.Fatalf("NewCronJob did not fail")
+			}
+			if got := cj.Name(); got != tc.want {
+				t.Errorf("Name() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCronJobNameOfDiscoveredCronJob(t *testing.T) {
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice() yielded an unexpected error: %s", err)
+	}
+	harness.UseTabs(t, cron.NewMemTab("test", d.User, "0 * * * * cron2mqtt exec id echo true\n"))
+	nt, err := ParseNameTemplate("{{.Name}} ({{.Schedule}}): {{.Command}}")
+	if err != nil {
+		t.Fatalf("ParseNameTemplate yielded an unexpected error: %s", err)
+	}
+
+	// Like with exec, the command is known from its arguments, but the rest has to be found in the crontab.
+	cj, err := NewCronJob("id", mqtt.NewClientForTesting(mqttfake.NewClient()), CronJobCommand([]string{"/usr/bin/cron2mqtt", "exec", "id", "echo", "true"}), CronJobNameTemplate(nt))
+	if err != nil {
+		t.Fatalf("NewCronJob unexpectedly failed with %v", err)
+	}
+	if got, want := cj.Name(), "echo true (0 * * * *): cron2mqtt exec id echo true"; got != want {
+		t.Errorf("Name() = %q, want %q", got, want)
+	}
+}
@@ -29,12 +29,29 @@ func (NopPlugin) OnCreate(*CronJob, Publisher) error                   { return
 func (NopPlugin) PublishResult(*CronJob, Publisher, exec.Result) error { return nil }
 
 // TopicRegister lets Plugins declare that they would like to publish to a particular topic.
-//  - Only one plugin may publish to a particular topic.
-//  - You may only publish to a topic with mqtt.Retain if it is registered with mqtt.Retain. You are allowed to publish to a topic with mqtt.DoNotRetain even if it's registered with mqtt.Retain.
+//   - Only one plugin may publish to a particular topic.
+//   - You may only publish to a topic with mqtt.Retain if it is registered with mqtt.Retain. You are allowed to publish to a topic with mqtt.DoNotRetain even if it's registered with mqtt.Retain.
 type TopicRegister interface {
 	// RegisterSuffix registers a topic that is prefixed with the standard topic prefix for the cron job. The complete topic string is returned from this method. You may publish to this topic with mqtt.Retain if you wish.
 	RegisterSuffix(suffix string) string
 	RegisterTopic(topic string, retain mqtt.RetainMode)
+	// RegisterSharedTopic registers a retained topic that's shared with other cron jobs (e.g. an attribute of the device).
+	// Unlike other topics, shared topics aren't cleared by CronJob.Unpublish. Plugins can update them in UnpublishPlugin.OnUnpublish instead.
+	RegisterSharedTopic(topic string)
+}
+
+// UnpublishPlugin is implemented by Plugins that need to do more than clear their topics when a CronJob is unpublished.
+type UnpublishPlugin interface {
+	Plugin
+	OnUnpublish(cj *CronJob, pub Publisher) error
+}
+
+// TopicOwnerPlugin is implemented by Plugins whose topics depend on the cron job's configuration (e.g. its Extractors).
+// Retained messages on topics that the plugin owns but didn't register (e.g. because an Extractor was removed) are cleared when the cron job is created with CronJobClearStaleTopics, and all of them are cleared when it's unpublished.
+type TopicOwnerPlugin interface {
+	Plugin
+	// OwnedTopics returns a topic filter that matches the plugin's topics, and reports whether the plugin owns a retained message that matches the filter.
+	OwnedTopics(cj *CronJob) (filter string, owns func(m mqtt.Message) bool)
 }
 
 type CorePlugin struct {
@@ -42,11 +59,40 @@ type CorePlugin struct {
 	MetadataTopic    string
 	ResultsTopic     string
 	LastSuccessTopic string
+	// StartedTopic reports when the most recent execution started, as soon as it starts (see CronJob.PublishStart).
+	StartedTopic string
+	// EnabledTopic reports whether the cron job is enabled in its crontab. Messages published to EnabledCommandTopic will enable or disable the cron job if something is listening (see SubscribeEnableRequests).
+	EnabledTopic        string
+	EnabledCommandTopic string
+	// MissedTopic reports whether the cron job missed its most recent scheduled execution (see CronJob.PublishMissedRun).
+	MissedTopic string
+	// ValuesTopic receives the values extracted from the cron job's stdout by its Extractors, keyed by their names. Values that couldn't be extracted are null.
+	ValuesTopic string
+	// AvailabilityTopic reports whether the cron job is expected to run, i.e. it's offline while the cron job is disabled. See also Device.AvailabilityTopic.
+	AvailabilityTopic string
 }
 
+const (
+	EnabledPayload  = "ON"
+	DisabledPayload = "OFF"
+
+	// The possible outcomes of an execution (see ResultState).
+	SucceededState = "succeeded"
+	FailedState    = "failed"
+	TimedOutState  = "timed_out"
+)
+
 var (
-	ExitCodeAttributeName = loadAttributeName(results{}, "ExitCode")
-	DurationAttributeName = loadAttributeName(results{}, "Duration")
+	StartTimeAttributeName  = loadAttributeName(started{}, "StartTime")
+	EndTimeAttributeName    = loadAttributeName(results{}, "EndTime")
+	ExitCodeAttributeName   = loadAttributeName(results{}, "ExitCode")
+	DurationAttributeName   = loadAttributeName(results{}, "Duration")
+	StdoutSizeAttributeName = loadAttributeName(results{}, "StdoutSize")
+	StderrSizeAttributeName = loadAttributeName(results{}, "StderrSize")
+	AnomalousAttributeName  = loadAttributeName(results{}, "Anomalous")
+	MissedAttributeName     = loadAttributeName(missedRun{}, "Missed")
+
+	NextExecutionTimeAttributeName = loadAttributeName(metadata{}, "NextExecutionTime")
 )
 
 func loadAttributeName(s any, f string) string {
@@ -58,6 +104,8 @@ func loadAttributeName(s any, f string) string {
 }
 
 type metadata struct {
+	Name              string     `json:"name"`
+	Description       string     `json:"description,omitempty"`
 	Schedule          string     `json:"schedule,omitempty"`
 	NextExecutionTime *time.Time `json:"next_execution_time,omitempty"`
 }
@@ -69,7 +117,23 @@ type results struct {
 	Duration  milliseconds `json:"duration_ms"`
 	Stdout    string       `json:"stdout"`
 	Stderr    string       `json:"stderr"`
-	ExitCode  int          `json:"exit_code"`
+	// StdoutSize and StderrSize are in bytes.
+	StdoutSize int `json:"stdout_size"`
+	StderrSize int `json:"stderr_size"`
+	ExitCode   int `json:"exit_code"`
+	// Anomalous is set if the duration was unusual compared to the cron job's history.
+	Anomalous     bool   `json:"anomalous"`
+	AnomalyReason string `json:"anomaly_reason,omitempty"`
+}
+
+type started struct {
+	StartTime time.Time `json:"start_time"`
+}
+
+type missedRun struct {
+	Missed        bool       `json:"missed"`
+	ExpectedTime  *time.Time `json:"expected_time,omitempty"`
+	LastStartTime time.Time  `json:"last_start_time"`
 }
 
 type milliseconds time.Duration
@@ -91,11 +155,17 @@ func (p *CorePlugin) Init(cj *CronJob, reg TopicRegister) error {
 	p.MetadataTopic = reg.RegisterSuffix("metadata")
 	p.ResultsTopic = reg.RegisterSuffix("results")
 	p.LastSuccessTopic = reg.RegisterSuffix("last_success")
+	p.StartedTopic = reg.RegisterSuffix("started")
+	p.EnabledTopic = reg.RegisterSuffix("enabled")
+	p.EnabledCommandTopic = p.EnabledTopic + "/set"
+	p.MissedTopic = reg.RegisterSuffix("missed")
+	p.AvailabilityTopic = reg.RegisterSuffix(availabilitySuffix)
+	p.ValuesTopic = reg.RegisterSuffix("values")
 	return nil
 }
 
 func (p *CorePlugin) OnCreate(cj *CronJob, pub Publisher) error {
-	m := metadata{}
+	m := metadata{Name: cj.Name(), Description: cj.Description()}
 	if cj.Schedule != nil {
 		m.Schedule = cj.Schedule.String()
 		m.NextExecutionTime = new.Ptr(cj.Schedule.Next(time.Now()))
@@ -104,37 +174,152 @@ func (p *CorePlugin) OnCreate(cj *CronJob, pub Publisher) error {
 	if err != nil {
 		return err
 	}
+	if b, err = cj.sign(p.MetadataTopic, b); err != nil {
+		return fmt.Errorf("could not sign metadata: %w", err)
+	}
 
 	return MultiPublish(
 		func() error { return pub.Publish(p.DiscoveryTopic, mqtt.QoSExactlyOnce, mqtt.Retain, "1") },
-		func() error { return pub.Publish(p.MetadataTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b) })
+		func() error { return pub.Publish(p.MetadataTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b) },
+		func() error {
+			if cj.Enabled == nil {
+				return nil
+			}
+			return pub.Publish(p.EnabledTopic, mqtt.QoSExactlyOnce, mqtt.Retain, enabledPayload(*cj.Enabled))
+		},
+		func() error {
+			a := OnlinePayload
+			if cj.Enabled != nil && !*cj.Enabled {
+				a = OfflinePayload
+			}
+			return pub.Publish(p.AvailabilityTopic, mqtt.QoSExactlyOnce, mqtt.Retain, a)
+		})
+}
+
+// sign signs b, which will be published to topic, with the cron job's signer, if it has one.
+func (c *CronJob) sign(topic string, b []byte) ([]byte, error) {
+	if c.signer == nil {
+		return b, nil
+	}
+	return c.signer.Sign(topic, b)
+}
+
+func enabledPayload(enabled bool) string {
+	if enabled {
+		return EnabledPayload
+	}
+	return DisabledPayload
+}
+
+// ResultState summarizes the outcome of res as SucceededState, FailedState or TimedOutState.
+func ResultState(res exec.Result) string {
+	switch {
+	case res.TimedOut:
+		return TimedOutState
+	case res.ExitCode != 0:
+		return FailedState
+	default:
+		return SucceededState
+	}
 }
 
 func (p *CorePlugin) PublishResult(cj *CronJob, pub Publisher, res exec.Result) error {
 	results := results{
-		Args:      res.Args,
-		StartTime: res.Start,
-		EndTime:   res.End,
-		Duration:  milliseconds(res.End.Sub(res.Start)),
-		Stdout:    string(res.Stdout),
-		Stderr:    string(res.Stderr),
-		ExitCode:  res.ExitCode,
+		Args:       res.Args,
+		StartTime:  res.Start,
+		EndTime:    res.End,
+		Duration:   milliseconds(res.End.Sub(res.Start)),
+		Stdout:     string(res.Stdout),
+		Stderr:     string(res.Stderr),
+		StdoutSize: len(res.Stdout),
+		StderrSize: len(res.Stderr),
+		ExitCode:   res.ExitCode,
+	}
+	if cj.history != nil {
+		es, err := cj.pastExecutions(res)
+		if err != nil {
+			return err
+		}
+		results.Anomalous, results.AnomalyReason = cj.anomaly.Check(es, res.End.Sub(res.Start))
 	}
 	b, err := json.Marshal(results)
 	if err != nil {
 		return fmt.Errorf("could not marshal results: %w", err)
 	}
+	// The same results are published to both topics, but their signatures differ.
+	signed, err := cj.sign(p.ResultsTopic, b)
+	if err != nil {
+		return fmt.Errorf("could not sign results: %w", err)
+	}
+	signedLastSuccess, err := cj.sign(p.LastSuccessTopic, b)
+	if err != nil {
+		return fmt.Errorf("could not sign last success: %w", err)
+	}
 
+	props := cj.ResultProperties(res)
+	props.ContentType = "application/json"
+	// The last success is retained indefinitely, since it's still relevant no matter how old it is.
+	lastSuccess := mqtt.WithProperties(signedLastSuccess, props)
+	props.MessageExpiry = cj.resultsExpiry
 	return MultiPublish(
-		func() error { return pub.Publish(p.ResultsTopic, mqtt.QoSExactlyOnce, mqtt.DoNotRetain, b) },
+		func() error {
+			return pub.Publish(p.ResultsTopic, mqtt.QoSExactlyOnce, mqtt.DoNotRetain, mqtt.WithProperties(signed, props))
+		},
 		func() error {
 			if res.ExitCode != 0 {
 				return nil
 			}
-			return pub.Publish(p.LastSuccessTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b)
+			return pub.Publish(p.LastSuccessTopic, mqtt.QoSExactlyOnce, mqtt.Retain, lastSuccess)
+		},
+		func() error {
+			if len(cj.extractors) == 0 {
+				return nil
+			}
+			b, err := json.Marshal(extractValues(cj.extractors, res.Stdout))
+			if err != nil {
+				return fmt.Errorf("could not marshal extracted values: %w", err)
+			}
+			return pub.Publish(p.ValuesTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b)
+		},
+		func() error {
+			// In case nothing published the start when the execution started.
+			return p.PublishStart(cj, pub, res.Start)
+		},
+		func() error {
+			// The cron job obviously didn't miss this execution.
+			if cj.Schedule == nil {
+				return nil
+			}
+			return p.PublishMissedRun(cj, pub, MissedRun{ExpectedTime: cj.Schedule.Next(res.Start), LastStartTime: res.Start})
 		})
 }
 
+func (p *CorePlugin) PublishStart(cj *CronJob, pub Publisher, start time.Time) error {
+	b, err := json.Marshal(started{StartTime: start})
+	if err != nil {
+		return fmt.Errorf("could not marshal start: %w", err)
+	}
+	if b, err = cj.sign(p.StartedTopic, b); err != nil {
+		return fmt.Errorf("could not sign start: %w", err)
+	}
+	return pub.Publish(p.StartedTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b)
+}
+
+func (p *CorePlugin) PublishMissedRun(cj *CronJob, pub Publisher, m MissedRun) error {
+	mr := missedRun{
+		Missed:        m.Missed,
+		LastStartTime: m.LastStartTime,
+	}
+	if !m.ExpectedTime.IsZero() {
+		mr.ExpectedTime = new.Ptr(m.ExpectedTime)
+	}
+	b, err := json.Marshal(mr)
+	if err != nil {
+		return fmt.Errorf("could not marshal missed run: %w", err)
+	}
+	return pub.Publish(p.MissedTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b)
+}
+
 // MultiPublish runs all of the functions in parallel and returns a multierr for all those that failed.
 // Expected to be used with parallel calls to Publisher.Publish.
 func MultiPublish(fs ...func() error) error {
@@ -0,0 +1,190 @@
+package mqttcron
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/history"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
+	"github.com/JeffreyFalgout/cron2mqtt/signing"
+)
+
+func TestCorePluginAnomaly(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	var h fakeHistory
+	for i := 0; i < 10; i++ {
+		s := start.Add(time.Duration(i) * time.Hour)
+		h = append(h, history.Entry{Start: s, End: s.Add(2 * time.Minute)})
+	}
+
+	for _, tc := range []struct {
+		name string
+
+		d time.Duration
+
+		want bool
+	}{
+		{
+			name: "normal",
+			d:    2 * time.Minute,
+			want: false,
+		},
+		{
+			name: "slow",
+			d:    40 * time.Minute,
+			want: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := mqttfake.NewClient()
+			cj, err := NewCronJob("id", mqtt.NewClientForTesting(c), CronJobHistory(h))
+			if err != nil {
+				t.Fatalf("NewCronJob failed: %s", err)
+			}
+			var cp *CorePlugin
+			if !cj.Plugin(&cp) {
+				t.Fatalf("Could not find CorePlugin")
+			}
+
+			var got []results
+			if tok := c.Subscribe(cp.ResultsTopic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+				var r results
+				if err := json.Unmarshal(m.Payload(), &r); err != nil {
+					t.Errorf("Could not unmarshal results: %s", err)
+				}
+				got = append(got, r)
+			}); tok.Wait() && tok.Error() != nil {
+				t.Fatalf("Could not subscribe: %s", tok.Error())
+			}
+
+			s := start.Add(24 * time.Hour)
+			if err := cj.PublishResult(exec.Result{Start: s, End: s.Add(tc.d)}); err != nil {
+				t.Fatalf("PublishResult failed: %s", err)
+			}
+
+			if len(got) != 1 {
+				t.Fatalf("Got %d results, want 1", len(got))
+			}
+			if got[0].Anomalous != tc.want {
+				t.Errorf("Results are anomalous = %t (%q), want %t", got[0].Anomalous, got[0].AnomalyReason, tc.want)
+			}
+		})
+	}
+}
+
+func TestCorePluginAvailability(t *testing.T) {
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice failed: %s", err)
+	}
+	topic := d.topicPrefix + "/id/" + availabilitySuffix
+
+	for _, tc := range []struct {
+		name string
+
+		enabled *bool
+
+		want string
+	}{
+		{
+			name: "unknown",
+			want: OnlinePayload,
+		},
+		{
+			name:    "enabled",
+			enabled: new.Ptr(true),
+			want:    OnlinePayload,
+		},
+		{
+			name:    "disabled",
+			enabled: new.Ptr(false),
+			want:    OfflinePayload,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := mqttfake.NewClient()
+			var got []string
+			if tok := c.Subscribe(topic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+				got = append(got, string(m.Payload()))
+			}); tok.Wait() && tok.Error() != nil {
+				t.Fatalf("Could not subscribe: %s", tok.Error())
+			}
+
+			if _, err := NewCronJob("id", mqtt.NewClientForTesting(c), func(cj *CronJob) { cj.Enabled = tc.enabled }); err != nil {
+				t.Fatalf("NewCronJob failed: %s", err)
+			}
+
+			if len(got) != 1 || got[0] != tc.want {
+				t.Errorf("Published availability %q, want [%q]", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	for _, tc := range []struct {
+		id      string
+		wantErr bool
+	}{
+		{id: "abcd"},
+		{id: "a/b", wantErr: true},
+		{id: availabilitySuffix, wantErr: true},
+	} {
+		if err := ValidateID(tc.id); (err != nil) != tc.wantErr {
+			t.Errorf("ValidateID(%q) = %v, want error: %t", tc.id, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCorePluginSigning(t *testing.T) {
+	d, err := CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice failed: %s", err)
+	}
+	key := []byte("secret")
+
+	c := mqttfake.NewClient()
+	got := make(map[string][]byte)
+	for _, suffix := range []string{"metadata", "results", "last_success"} {
+		suffix := suffix
+		if tok := c.Subscribe(d.topicPrefix+"/id/"+suffix, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+			got[suffix] = m.Payload()
+		}); tok.Wait() && tok.Error() != nil {
+			t.Fatalf("Could not subscribe: %s", tok.Error())
+		}
+	}
+
+	cj, err := NewCronJob("id", mqtt.NewClientForTesting(c), CronJobSigner(signing.HMACSigner(key)))
+	if err != nil {
+		t.Fatalf("NewCronJob failed: %s", err)
+	}
+	if err := cj.PublishResult(exec.Result{Start: time.Now(), End: time.Now()}); err != nil {
+		t.Fatalf("PublishResult failed: %s", err)
+	}
+
+	v := signing.HMACVerifier(key)
+	for _, suffix := range []string{"metadata", "results", "last_success"} {
+		p, ok := got[suffix]
+		if !ok {
+			t.Errorf("Nothing was published to %s", suffix)
+			continue
+		}
+		topic := d.topicPrefix + "/id/" + suffix
+		if _, err := v.Verify(topic, p); err != nil {
+			t.Errorf("Could not verify %s %s: %s", suffix, p, err)
+		}
+		if _, err := v.Verify(d.topicPrefix+"/other/"+suffix, p); !errors.Is(err, signing.ErrInvalidSignature) {
+			t.Errorf("Verifying %s on another cron job's topic = %v, want %v", suffix, err, signing.ErrInvalidSignature)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package mqttcron
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// PluginConfig is the user's configuration for a single registered plugin.
+type PluginConfig struct {
+	// Enabled overrides whether the plugin is enabled by default.
+	Enabled *bool `mapstructure:"enabled"`
+	// Options are decoded into the plugin's options type. Any options that aren't specified keep their default value.
+	Options map[string]interface{} `mapstructure:",remain"`
+}
+
+// PluginsConfig configures registered plugins by name.
+type PluginsConfig map[string]PluginConfig
+
+type registration struct {
+	enabledByDefault bool
+	// factory decodes the plugin's options and returns a function that creates new instances of the plugin.
+	factory func(opts map[string]interface{}) (func() Plugin, error)
+}
+
+var registry = make(map[string]registration)
+
+// RegisterPlugin makes a plugin available to be enabled and configured by name (see PluginFactories).
+//
+// defaults are the plugin's default options. The user's options are decoded on top of them with mapstructure, so O should use mapstructure tags.
+// This is expected to be called from an init function, and it will panic if name has already been registered.
+func RegisterPlugin[O any](name string, enabledByDefault bool, defaults O, newPlugin func(O) Plugin) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Errorf("plugin %q is already registered", name))
+	}
+	registry[name] = registration{
+		enabledByDefault: enabledByDefault,
+		factory: func(m map[string]interface{}) (func() Plugin, error) {
+			opts := defaults
+			dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+				DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+				ErrorUnused:      true,
+				WeaklyTypedInput: true,
+				Result:           &opts,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := dec.Decode(m); err != nil {
+				return nil, err
+			}
+			return func() Plugin { return newPlugin(opts) }, nil
+		},
+	}
+}
+
+// RegisteredPlugins returns the names of all registered plugins.
+func RegisteredPlugins() []string {
+	var ns []string
+	for n := range registry {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// PluginFactories returns functions that create new instances of each plugin enabled by c, sorted by name.
+//
+// Plugins typically keep state about the CronJob they're attached to, so every CronJob needs its own instances.
+func PluginFactories(c PluginsConfig) ([]func() Plugin, error) {
+	for n := range c {
+		if _, ok := registry[n]; !ok {
+			return nil, fmt.Errorf("unknown plugin %q, expected one of %q", n, RegisteredPlugins())
+		}
+	}
+
+	var fs []func() Plugin
+	for _, n := range RegisteredPlugins() {
+		reg := registry[n]
+		pc := c[n]
+		if enabled := reg.enabledByDefault; (pc.Enabled == nil && !enabled) || (pc.Enabled != nil && !*pc.Enabled) {
+			continue
+		}
+
+		f, err := reg.factory(pc.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for plugin %q: %w", n, err)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// NewPlugins creates a new instance of each plugin from fs.
+func NewPlugins(fs []func() Plugin) []Plugin {
+	var ps []Plugin
+	for _, f := range fs {
+		ps = append(ps, f())
+	}
+	return ps
+}
@@ -0,0 +1,116 @@
+package mqttcron
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type registryTestOptions struct {
+	Name    string        `mapstructure:"name"`
+	Count   int           `mapstructure:"count"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type registryTestPlugin struct {
+	NopPlugin
+	opts registryTestOptions
+}
+
+func init() {
+	RegisterPlugin("registry_test", false, registryTestOptions{Name: "default", Count: 1}, func(o registryTestOptions) Plugin { return &registryTestPlugin{opts: o} })
+}
+
+func TestPluginFactories(t *testing.T) {
+	enabled := true
+	disabled := false
+	for _, tc := range []struct {
+		name string
+
+		conf    PluginsConfig
+		wantErr *regexp.Regexp
+
+		// want is nil if registry_test shouldn't be enabled.
+		want     *registryTestOptions
+		wantStat bool
+	}{
+		{
+			name: "defaults",
+
+			wantStat: true,
+		},
+		{
+			name: "enabled with default options",
+
+			conf: PluginsConfig{"registry_test": {Enabled: &enabled}},
+
+			want:     &registryTestOptions{Name: "default", Count: 1},
+			wantStat: true,
+		},
+		{
+			name: "enabled with options",
+
+			conf: PluginsConfig{"registry_test": {Enabled: &enabled, Options: map[string]interface{}{"count": "5", "timeout": "1m"}}},
+
+			want:     &registryTestOptions{Name: "default", Count: 5, Timeout: time.Minute},
+			wantStat: true,
+		},
+		{
+			name: "disabled by default",
+
+			conf: PluginsConfig{"registry_test": {Options: map[string]interface{}{"count": 5}}},
+
+			wantStat: true,
+		},
+		{
+			name: "disabled",
+
+			conf: PluginsConfig{"stats": {Enabled: &disabled}},
+		},
+		{
+			name: "unknown plugin",
+
+			conf:    PluginsConfig{"foo": {}},
+			wantErr: regexp.MustCompile(regexp.QuoteMeta(`unknown plugin "foo"`)),
+		},
+		{
+			name: "unknown option",
+
+			conf:    PluginsConfig{"registry_test": {Enabled: &enabled, Options: map[string]interface{}{"foo": "bar"}}},
+			wantErr: regexp.MustCompile(regexp.QuoteMeta(`invalid options for plugin "registry_test"`)),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, err := PluginFactories(tc.conf)
+			if err != nil {
+				if tc.wantErr == nil {
+					t.Fatalf("PluginFactories unexpectedly failed with %v", err)
+				} else if !tc.wantErr.MatchString(err.Error()) {
+					t.Fatalf("PluginFactories failed with %v, wanted to match %q", err, tc.wantErr)
+				}
+				return
+			} else if tc.wantErr != nil {
+				t.Fatalf("PluginFactories did not fail, but expected error message to match %q", tc.wantErr)
+			}
+
+			var got *registryTestOptions
+			gotStat := false
+			for _, p := range NewPlugins(fs) {
+				switch p := p.(type) {
+				case *registryTestPlugin:
+					got = &p.opts
+				case *StatsPlugin:
+					gotStat = true
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("registry_test options diff (-want +got)\n%s", diff)
+			}
+			if gotStat != tc.wantStat {
+				t.Errorf("StatsPlugin enabled = %t, want %t", gotStat, tc.wantStat)
+			}
+		})
+	}
+}
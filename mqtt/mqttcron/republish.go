@@ -0,0 +1,64 @@
+package mqttcron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+)
+
+// RepublishPlugin is an optional interface for Plugins whose consumers can lose track of cron jobs (e.g. when they restart without retained messages), and announce when that happens.
+type RepublishPlugin interface {
+	// RepublishTopic returns the topic that the consumer publishes payload to when every cron job needs to be re-created (see Plugin.OnCreate).
+	RepublishTopic() (topic string, payload string)
+}
+
+// SubscribeRepublishRequests listens on the RepublishTopic of each of ps, and reports on ch whenever every cron job should be re-created.
+//
+// ch will be closed once ctx is done.
+func SubscribeRepublishRequests(ctx context.Context, c Subscriber, ps []Plugin, ch chan<- struct{}) error {
+	var wg sync.WaitGroup
+	for _, p := range ps {
+		rp, ok := p.(RepublishPlugin)
+		if !ok {
+			continue
+		}
+		topic, payload := rp.RepublishTopic()
+
+		ms := make(chan mqtt.Message, 10)
+		if err := c.Subscribe(ctx, topic, mqtt.QoSAtLeastOnce, chan<- mqtt.Message(ms)); err != nil {
+			// Wait for the other subscriptions to be cleaned up before closing ch.
+			go func() {
+				wg.Wait()
+				close(ch)
+			}()
+			return fmt.Errorf("could not subscribe to MQTT: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range ms {
+				if p := string(m.Payload()); p != payload {
+					log.Debug().Str("topic", m.Topic()).Str("payload", p).Msg("Ignoring status update")
+					continue
+				}
+				m.Ack()
+				log.Info().Str("topic", m.Topic()).Msg("Received republish request")
+				select {
+				case <-ctx.Done():
+				case ch <- struct{}{}:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return nil
+}
@@ -0,0 +1,62 @@
+package mqttcron
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+type republishPlugin struct {
+	NopPlugin
+	topic string
+}
+
+func (p *republishPlugin) RepublishTopic() (string, string) {
+	return p.topic, "online"
+}
+
+func TestSubscribeRepublishRequests(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+
+	fake := mqttfake.NewClient()
+	c := mqtt.NewClientForTesting(fake)
+	ch := make(chan struct{}, 10)
+	ps := []Plugin{&republishPlugin{topic: "foo/status"}, &republishPlugin{topic: "bar/status"}, &CorePlugin{}}
+	if err := SubscribeRepublishRequests(ctx, c, ps, ch); err != nil {
+		t.Fatalf("SubscribeRepublishRequests failed: %s", err)
+	}
+
+	for _, m := range []struct{ topic, payload string }{
+		{"foo/status", "online"},
+		{"foo/status", "offline"},
+		{"bar/status", "online"},
+		{"baz/status", "online"},
+	} {
+		if err := c.Publish(context.Background(), m.topic, mqtt.QoSAtLeastOnce, mqtt.DoNotRetain, m.payload); err != nil {
+			t.Fatalf("Publish(%q, %q) failed: %s", m.topic, m.payload, err)
+		}
+	}
+
+	var got int
+	timeout := time.After(time.Second)
+	for got < 2 {
+		select {
+		case <-ch:
+			got++
+		case <-timeout:
+			t.Fatalf("Got %d republish requests, want 2", got)
+		}
+	}
+
+	canc()
+	for range ch {
+		got++
+	}
+	if got != 2 {
+		t.Errorf("Got %d republish requests, want 2", got)
+	}
+}
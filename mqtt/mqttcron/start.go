@@ -0,0 +1,33 @@
+package mqttcron
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+)
+
+// StartPlugin is an optional interface for Plugins that want to report when an execution starts, before its result is known.
+type StartPlugin interface {
+	PublishStart(cj *CronJob, pub Publisher, start time.Time) error
+}
+
+// PublishStart publishes one or more messages to MQTT about an execution of this cron job that started at start.
+func (c *CronJob) PublishStart(start time.Time) error {
+	var fs []func() error
+	for _, p := range c.plugins {
+		p := p
+		sp, ok := p.(StartPlugin)
+		if !ok {
+			continue
+		}
+		fs = append(fs, func() error {
+			defer logutil.StartTimerLogger(log.Logger.With().Str("plugin", fmt.Sprintf("%T", p)).Logger(), zerolog.TraceLevel, "Plugin#PublishStart").Stop()
+			return sp.PublishStart(c, c.publisher(c.ctx, p), start)
+		})
+	}
+	return MultiPublish(fs...)
+}
@@ -0,0 +1,94 @@
+package mqttcron
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/history"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
+)
+
+// StatsPlugin publishes rolling statistics about a cron job's recent executions, based on the cron job's local history (see CronJobHistory).
+type StatsPlugin struct {
+	NopPlugin
+	StatsTopic string
+
+	window int
+}
+
+var (
+	SuccessRateAttributeName         = loadAttributeName(stats{}, "SuccessRate")
+	ConsecutiveFailuresAttributeName = loadAttributeName(stats{}, "ConsecutiveFailures")
+	MinDurationAttributeName         = loadAttributeName(stats{}, "MinDuration")
+	MeanDurationAttributeName        = loadAttributeName(stats{}, "MeanDuration")
+	P95DurationAttributeName         = loadAttributeName(stats{}, "P95Duration")
+	LastSuccessTimeAttributeName     = loadAttributeName(stats{}, "LastSuccessTime")
+	SinceLastSuccessAttributeName    = loadAttributeName(stats{}, "SinceLastSuccess")
+)
+
+type stats struct {
+	Runs                int           `json:"runs"`
+	SuccessRate         float64       `json:"success_rate"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	MinDuration         milliseconds  `json:"min_duration_ms"`
+	MeanDuration        milliseconds  `json:"mean_duration_ms"`
+	P95Duration         milliseconds  `json:"p95_duration_ms"`
+	LastSuccessTime     *time.Time    `json:"last_success_time"`
+	SinceLastSuccess    *milliseconds `json:"since_last_success_ms"`
+}
+
+// StatsOptions configures the "stats" plugin.
+type StatsOptions struct {
+	// Window is the number of recent executions to summarize.
+	Window int `mapstructure:"window"`
+}
+
+func init() {
+	RegisterPlugin("stats", true, StatsOptions{Window: 30}, func(o StatsOptions) Plugin { return NewStatsPlugin(o.Window) })
+}
+
+// NewStatsPlugin creates a StatsPlugin that summarizes the last window executions of the cron job.
+func NewStatsPlugin(window int) Plugin {
+	return &StatsPlugin{
+		window: window,
+	}
+}
+
+func (p *StatsPlugin) Init(cj *CronJob, reg TopicRegister) error {
+	p.StatsTopic = reg.RegisterSuffix("stats")
+	return nil
+}
+
+func (p *StatsPlugin) PublishResult(cj *CronJob, pub Publisher, res exec.Result) error {
+	if cj.history == nil {
+		return nil
+	}
+	es, err := cj.pastExecutions(res)
+	if err != nil {
+		return err
+	}
+	es = append(es, history.Entry{Start: res.Start, End: res.End, ExitCode: res.ExitCode})
+
+	s := history.Summarize(es, p.window)
+	st := stats{
+		Runs:                s.Runs,
+		SuccessRate:         s.SuccessRate(),
+		ConsecutiveFailures: s.ConsecutiveFailures,
+		MinDuration:         milliseconds(s.MinDuration),
+		MeanDuration:        milliseconds(s.MeanDuration),
+		P95Duration:         milliseconds(s.P95Duration),
+	}
+	if !s.LastSuccess.IsZero() {
+		st.LastSuccessTime = new.Ptr(s.LastSuccess)
+		st.SinceLastSuccess = new.Ptr(milliseconds(res.End.Sub(s.LastSuccess)))
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("could not marshal stats: %w", err)
+	}
+
+	return pub.Publish(p.StatsTopic, mqtt.QoSExactlyOnce, mqtt.Retain, b)
+}
@@ -0,0 +1,74 @@
+package mqttcron
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/exec"
+	"github.com/JeffreyFalgout/cron2mqtt/history"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+	"github.com/JeffreyFalgout/cron2mqtt/new"
+)
+
+type fakeHistory []history.Entry
+
+func (h fakeHistory) Entries(string, history.Filter) ([]history.Entry, error) {
+	return h, nil
+}
+
+func TestStatsPlugin(t *testing.T) {
+	start, err := time.Parse(time.RFC3339, "2000-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Could not parse testing time: %s", err)
+	}
+	h := fakeHistory{
+		{Start: start, End: start.Add(time.Second), ExitCode: 0},
+		{Start: start.Add(time.Hour), End: start.Add(time.Hour + 3*time.Second), ExitCode: 1},
+	}
+	// This result isn't in the history yet.
+	res := exec.Result{Start: start.Add(2 * time.Hour), End: start.Add(2*time.Hour + 2*time.Second), ExitCode: 1}
+
+	c := mqttfake.NewClient()
+	cj, err := NewCronJob("id", mqtt.NewClientForTesting(c), CronJobHistory(h), CronJobPlugins(NewStatsPlugin(10)))
+	if err != nil {
+		t.Fatalf("NewCronJob failed: %s", err)
+	}
+	var sp *StatsPlugin
+	if !cj.Plugin(&sp) {
+		t.Fatalf("Could not find StatsPlugin")
+	}
+
+	var got []stats
+	if tok := c.Subscribe(sp.StatsTopic, 0, func(_ mqttpaho.Client, m mqttpaho.Message) {
+		var s stats
+		if err := json.Unmarshal(m.Payload(), &s); err != nil {
+			t.Errorf("Could not unmarshal stats: %s", err)
+		}
+		got = append(got, s)
+	}); tok.Wait() && tok.Error() != nil {
+		t.Fatalf("Could not subscribe: %s", tok.Error())
+	}
+
+	if err := cj.PublishResult(res); err != nil {
+		t.Fatalf("PublishResult failed: %s", err)
+	}
+
+	want := []stats{{
+		Runs:                3,
+		SuccessRate:         100.0 / 3,
+		ConsecutiveFailures: 2,
+		MinDuration:         milliseconds(time.Second),
+		MeanDuration:        milliseconds(2 * time.Second),
+		P95Duration:         milliseconds(3 * time.Second),
+		LastSuccessTime:     new.Ptr(start.Add(time.Second)),
+		SinceLastSuccess:    new.Ptr(milliseconds(2*time.Hour + time.Second)),
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Published stats mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -2,16 +2,26 @@ package mqttfake
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// NewClient returns a fake client that's already connected, as if Connect had been called.
 func NewClient() *Client {
+	return NewClientWithOptions(mqtt.NewClientOptions())
+}
+
+// NewClientWithOptions is like NewClient, but the client's OptionsReader reads opts. Connect calls opts' OnConnect handler, and Drop publishes its will and calls its OnConnectionLost handler.
+func NewClientWithOptions(opts *mqtt.ClientOptions) *Client {
 	return &Client{
-		messages: make(map[string][]message),
-		handlers: make(map[string]mqtt.MessageHandler),
+		opts:      opts,
+		connected: true,
+		retained:  make(map[string]message),
+		handlers:  make(map[string]mqtt.MessageHandler),
 	}
 }
 
@@ -64,9 +74,13 @@ func newToken(err <-chan error) *token {
 		t.err = err
 		t.mut.Unlock()
 	}()
-	return &token{
-		done: done,
-	}
+	return &t
+}
+
+func errToken(err error) *token {
+	ch := make(chan error, 1)
+	ch <- err
+	return newToken(ch)
 }
 
 func (t *token) Wait() bool {
@@ -108,23 +122,95 @@ func (t *token) Error() error {
 	return t.err
 }
 
+// Client is a fake mqtt.Client that acts as its own broker. Messages published to it are delivered to its subscriptions, including those with + and # wildcards, and retained messages are delivered to new subscriptions.
+// Like a real broker, only messages that are delivered because they were retained have their retain flag set. Use Retained to check which messages are retained.
 type Client struct {
-	mut      sync.Mutex
-	messages map[string][]message
-	handlers map[string]mqtt.MessageHandler
+	opts *mqtt.ClientOptions
+
+	mut       sync.Mutex
+	connected bool
+	retained  map[string]message
+	handlers  map[string]mqtt.MessageHandler
+
+	errMut       sync.Mutex
+	connectErrs  []error
+	publishErrs  []error
+	connectCalls int
 }
 
-func (*Client) IsConnected() bool {
-	return true
+// FailConnects makes the next calls to Connect fail with errs, in order.
+func (c *Client) FailConnects(errs ...error) {
+	c.errMut.Lock()
+	defer c.errMut.Unlock()
+	c.connectErrs = append(c.connectErrs, errs...)
 }
-func (*Client) IsConnectionOpen() bool {
-	return true
+
+// FailPublishes makes the next calls to Publish fail with errs, in order. Failed messages aren't delivered.
+func (c *Client) FailPublishes(errs ...error) {
+	c.errMut.Lock()
+	defer c.errMut.Unlock()
+	c.publishErrs = append(c.publishErrs, errs...)
 }
-func (*Client) Connect() mqtt.Token {
-	panic("not yet implemented")
+
+// ConnectCalls returns the number of times that Connect has been called.
+func (c *Client) ConnectCalls() int {
+	c.errMut.Lock()
+	defer c.errMut.Unlock()
+	return c.connectCalls
 }
-func (*Client) Disconnect(quiesce uint) {
-	panic("not yet implemented")
+
+func pop(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (c *Client) IsConnected() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.connected
+}
+func (c *Client) IsConnectionOpen() bool {
+	return c.IsConnected()
+}
+func (c *Client) Connect() mqtt.Token {
+	c.errMut.Lock()
+	c.connectCalls++
+	err := pop(&c.connectErrs)
+	c.errMut.Unlock()
+	if err != nil {
+		return errToken(err)
+	}
+
+	c.mut.Lock()
+	c.connected = true
+	c.mut.Unlock()
+	if h := c.opts.OnConnect; h != nil {
+		go h(c)
+	}
+	return okToken
+}
+func (c *Client) Disconnect(quiesce uint) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.connected = false
+}
+
+// Drop simulates losing the connection to the broker unexpectedly, i.e. the client's will is published and its OnConnectionLost handler is called with err.
+func (c *Client) Drop(err error) {
+	c.mut.Lock()
+	c.connected = false
+	c.mut.Unlock()
+
+	if o := c.OptionsReader(); o.WillEnabled() {
+		c.deliver(message{o.WillTopic(), o.WillQos(), o.WillRetained(), o.WillPayload()}).Wait()
+	}
+	if h := c.opts.OnConnectionLost; h != nil {
+		h(c, err)
+	}
 }
 func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
 	var b []byte
@@ -136,33 +222,85 @@ func (c *Client) Publish(topic string, qos byte, retained bool, payload interfac
 	default:
 		panic(fmt.Errorf("unhandled payload type %T", p))
 	}
-	m := message{topic, qos, retained, b}
 
+	c.errMut.Lock()
+	pubErr := pop(&c.publishErrs)
+	c.errMut.Unlock()
+	if pubErr != nil {
+		return errToken(pubErr)
+	}
+	if !c.IsConnected() {
+		return errToken(mqtt.ErrNotConnected)
+	}
+
+	return c.deliver(message{topic, qos, retained, b})
+}
+
+// deliver acts as the broker for m: it's retained if necessary (or clears the retained message if it's empty), then it's delivered to every matching subscription.
+func (c *Client) deliver(m message) mqtt.Token {
 	c.mut.Lock()
-	c.messages[topic] = append(c.messages[topic], m)
-	h, ok := c.handlers[topic]
-	if !ok {
+	if m.retained {
+		if len(m.payload) == 0 {
+			delete(c.retained, m.topic)
+		} else {
+			c.retained[m.topic] = m
+		}
+		m.retained = false
+	}
+	var hs []mqtt.MessageHandler
+	for f, h := range c.handlers {
+		if Match(f, m.topic) {
+			hs = append(hs, h)
+		}
+	}
+	if len(hs) == 0 {
 		c.mut.Unlock()
 		return okToken
 	}
 
-	err := make(chan error)
+	// Handlers are called while the lock is held so that messages are delivered in the order they're published.
+	done := make(chan error)
 	go func() {
-		defer close(err)
-		h(c, m)
-		c.mut.Unlock()
+		defer close(done)
+		defer c.mut.Unlock()
+		for _, h := range hs {
+			h(c, m)
+		}
 	}()
-	return newToken(err)
+	return newToken(done)
 }
 func (c *Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
 	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
 }
+
+// SubscribeMultiple subscribes to the filters. Retained messages that match them are delivered asynchronously, i.e. the token completes before callback is called.
 func (c *Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
 	c.mut.Lock()
-	defer c.mut.Unlock()
-	for t := range filters {
-		c.handlers[t] = callback
+	if !c.connected {
+		c.mut.Unlock()
+		return errToken(mqtt.ErrNotConnected)
 	}
+	var ms []message
+	for f := range filters {
+		c.handlers[f] = callback
+		for t, m := range c.retained {
+			if Match(f, t) {
+				ms = append(ms, m)
+			}
+		}
+	}
+	if len(ms) == 0 {
+		c.mut.Unlock()
+		return okToken
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].topic < ms[j].topic })
+	go func() {
+		defer c.mut.Unlock()
+		for _, m := range ms {
+			callback(c, m)
+		}
+	}()
 	return okToken
 }
 func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
@@ -173,9 +311,43 @@ func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
 	}
 	return okToken
 }
+
+// Retained returns the payloads of the retained messages, keyed by their topics.
+func (c *Client) Retained() map[string]string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	r := make(map[string]string)
+	for t, m := range c.retained {
+		r[t] = string(m.payload)
+	}
+	return r
+}
 func (*Client) AddRoute(topic string, callback mqtt.MessageHandler) {
 	panic("not yet implemented")
 }
-func (*Client) OptionsReader() mqtt.ClientOptionsReader {
-	panic("not yet implemented")
+func (c *Client) OptionsReader() mqtt.ClientOptionsReader {
+	// paho doesn't provide a way to create a ClientOptionsReader, but creating a client doesn't connect it.
+	return mqtt.NewClient(c.opts).OptionsReader()
+}
+
+// Match reports whether topic matches the topic filter f, which may contain + and # wildcards.
+func Match(f, topic string) bool {
+	fs := strings.Split(f, "/")
+	ts := strings.Split(topic, "/")
+	// Wildcards at the start of a filter don't match topics that start with $ (e.g. $SYS).
+	if (fs[0] == "+" || fs[0] == "#") && strings.HasPrefix(topic, "$") {
+		return false
+	}
+	for i, l := range fs {
+		switch {
+		case l == "#":
+			// # also matches the parent level, e.g. foo/# matches foo.
+			return true
+		case i >= len(ts):
+			return false
+		case l != "+" && l != ts[i]:
+			return false
+		}
+	}
+	return len(fs) == len(ts)
 }
@@ -1,8 +1,11 @@
 package mqttfake
 
 import (
+	"errors"
+	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/go-cmp/cmp"
@@ -33,3 +36,162 @@ func TestSubscribe(t *testing.T) {
 		t.Errorf("Subscribe did not receive expected messages (-want +got):\n%s", diff)
 	}
 }
+
+func TestFailures(t *testing.T) {
+	c := NewClient()
+	errFoo := errors.New("foo")
+	c.FailConnects(errFoo)
+	c.FailPublishes(errFoo)
+
+	for i, want := range []error{errFoo, nil} {
+		if tok := c.Connect(); tok.Wait() && tok.Error() != want {
+			t.Errorf("Connect #%d failed with %v, want %v", i+1, tok.Error(), want)
+		}
+		if tok := c.Publish("foo/bar", 0, false, "hello"); tok.Wait() && tok.Error() != want {
+			t.Errorf("Publish #%d failed with %v, want %v", i+1, tok.Error(), want)
+		}
+	}
+	if got := c.ConnectCalls(); got != 2 {
+		t.Errorf("ConnectCalls() = %d, want 2", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"foo/bar", "foo/bar", true},
+		{"foo/bar", "foo/baz", false},
+		{"foo/bar", "foo/bar/baz", false},
+		{"foo/+", "foo/bar", true},
+		{"foo/+", "foo/bar/baz", false},
+		{"foo/+/baz", "foo/bar/baz", true},
+		{"foo/+/baz", "foo//baz", true},
+		{"foo/#", "foo", true},
+		{"foo/#", "foo/bar/baz", true},
+		{"foo/#", "foobar", false},
+		{"#", "foo/bar", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	} {
+		if got := Match(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("Match(%q, %q) = %t, want %t", tc.filter, tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	c := NewClient()
+	got := make(chan string, 10)
+	for _, f := range []string{"foo/+", "foo/#"} {
+		f := f
+		if tok := c.Subscribe(f, 0, func(c mqtt.Client, m mqtt.Message) {
+			got <- f + " " + m.Topic()
+		}); tok.Wait() && tok.Error() != nil {
+			t.Fatalf("Could not subscribe: %s", tok.Error())
+		}
+	}
+
+	for _, topic := range []string{"foo/bar", "foo/bar/baz", "bar"} {
+		if tok := c.Publish(topic, 0, false, "hello"); tok.Wait() && tok.Error() != nil {
+			t.Errorf("Could not publish to %s: %s", topic, tok.Error())
+		}
+	}
+	close(got)
+
+	var ms []string
+	for m := range got {
+		ms = append(ms, m)
+	}
+	sort.Strings(ms)
+	if diff := cmp.Diff([]string{"foo/# foo/bar", "foo/# foo/bar/baz", "foo/+ foo/bar"}, ms); diff != "" {
+		t.Errorf("Subscriptions did not receive expected messages (-want +got):\n%s", diff)
+	}
+}
+
+func TestRetained(t *testing.T) {
+	c := NewClient()
+	for topic, payload := range map[string]string{
+		"foo/retained": "hello",
+		"foo/cleared":  "hello",
+		"bar/retained": "hello",
+	} {
+		if tok := c.Publish(topic, 0, true, payload); tok.Wait() && tok.Error() != nil {
+			t.Fatalf("Could not publish to %s: %s", topic, tok.Error())
+		}
+	}
+	if tok := c.Publish("foo/cleared", 0, true, ""); tok.Wait() && tok.Error() != nil {
+		t.Fatalf("Could not clear foo/cleared: %s", tok.Error())
+	}
+	if tok := c.Publish("foo/not_retained", 0, false, "hello"); tok.Wait() && tok.Error() != nil {
+		t.Fatalf("Could not publish to foo/not_retained: %s", tok.Error())
+	}
+
+	want := map[string]string{"foo/retained": "hello", "bar/retained": "hello"}
+	if diff := cmp.Diff(want, c.Retained()); diff != "" {
+		t.Errorf("Retained() diff (-want +got):\n%s", diff)
+	}
+
+	got := make(chan mqtt.Message, 10)
+	if tok := c.Subscribe("foo/#", 0, func(c mqtt.Client, m mqtt.Message) { got <- m }); tok.Wait() && tok.Error() != nil {
+		t.Fatalf("Could not subscribe: %s", tok.Error())
+	}
+	select {
+	case m := <-got:
+		if m.Topic() != "foo/retained" || !m.Retained() {
+			t.Errorf("Subscribe received %s (retained: %t), want retained foo/retained", m.Topic(), m.Retained())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Subscribe did not receive the retained message")
+	}
+	select {
+	case m := <-got:
+		t.Errorf("Subscribe received unexpected message on %s", m.Topic())
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestConnection(t *testing.T) {
+	will := "lost"
+	connected := make(chan bool, 10)
+	opts := mqtt.NewClientOptions().
+		AddBroker("tcp://broker:1883").
+		SetWill("will", will, 0, true).
+		SetOnConnectHandler(func(mqtt.Client) { connected <- true }).
+		SetConnectionLostHandler(func(mqtt.Client, error) { connected <- false })
+	c := NewClientWithOptions(opts)
+
+	r := c.OptionsReader()
+	if s := r.Servers(); len(s) != 1 || s[0].Host != "broker:1883" {
+		t.Errorf("OptionsReader().Servers() = %v, want [tcp://broker:1883]", s)
+	}
+
+	c.Disconnect(0)
+	if c.IsConnected() {
+		t.Errorf("IsConnected() = true after Disconnect")
+	}
+	if tok := c.Publish("foo", 0, false, "hello"); tok.Wait() && tok.Error() != mqtt.ErrNotConnected {
+		t.Errorf("Publish while disconnected failed with %v, want %v", tok.Error(), mqtt.ErrNotConnected)
+	}
+
+	if tok := c.Connect(); tok.Wait() && tok.Error() != nil {
+		t.Fatalf("Connect failed: %s", tok.Error())
+	}
+	if !<-connected {
+		t.Errorf("OnConnect handler was not called")
+	}
+	if !c.IsConnected() {
+		t.Errorf("IsConnected() = false after Connect")
+	}
+
+	c.Drop(errors.New("oops"))
+	if <-connected {
+		t.Errorf("OnConnectionLost handler was not called")
+	}
+	if diff := cmp.Diff(map[string]string{"will": will}, c.Retained()); diff != "" {
+		t.Errorf("Will was not published (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,119 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultConnectTimeout = 30 * time.Second
+	DefaultPublishTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNotAuthorized is matched (with errors.Is) by errors caused by the broker rejecting the client's credentials. They're never retried.
+	// With MQTT 5, the broker's reason for refusing a connection isn't available, so refused connections aren't classified.
+	ErrNotAuthorized = errors.New("not authorized by broker")
+	// ErrNetwork is matched (with errors.Is) by errors caused by the broker being unreachable, including timeouts. They're retried.
+	ErrNetwork = errors.New("could not reach broker")
+)
+
+// RetryConfig configures how operations are retried after network failures. Zero values use the defaults in DefaultRetryConfig.
+type RetryConfig struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	Attempts int `mapstructure:"attempts,omitempty"`
+	// InitialBackoff is how long to wait before the first retry. It doubles after every subsequent attempt, up to MaxBackoff.
+	InitialBackoff time.Duration `mapstructure:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff,omitempty"`
+}
+
+var DefaultRetryConfig = RetryConfig{
+	Attempts:       3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+func (r RetryConfig) withDefaults() RetryConfig {
+	if r.Attempts <= 0 {
+		r.Attempts = DefaultRetryConfig.Attempts
+	}
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = DefaultRetryConfig.InitialBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultRetryConfig.MaxBackoff
+	}
+	return r
+}
+
+// do calls f until it succeeds, fails with an error that isn't an ErrNetwork, runs out of attempts, or ctx is done.
+func (r RetryConfig) do(ctx context.Context, log zerolog.Logger, f func(context.Context) error) error {
+	return r.Retry(ctx, log, func(err error) bool { return errors.Is(err, ErrNetwork) }, func(ctx context.Context) error {
+		return classify(f(ctx))
+	})
+}
+
+// Retry calls f until it succeeds, fails with an error that isn't retryable, runs out of attempts, or ctx is done. It lets other transports share the retry settings' semantics. Zero values use the defaults in DefaultRetryConfig.
+func (r RetryConfig) Retry(ctx context.Context, log zerolog.Logger, retryable func(error) bool, f func(context.Context) error) error {
+	r = r.withDefaults()
+	backoff := r.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f(ctx)
+		if err == nil || attempt >= r.Attempts || !retryable(err) {
+			return err
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("Retrying after failure")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// brokerError classifies err as kind (i.e. ErrNotAuthorized or ErrNetwork).
+type brokerError struct {
+	kind error
+	err  error
+}
+
+func (e brokerError) Error() string        { return e.kind.Error() + ": " + e.err.Error() }
+func (e brokerError) Is(target error) bool { return target == e.kind }
+func (e brokerError) Unwrap() error        { return e.err }
+
+func classify(err error) error {
+	switch {
+	case err == nil, errors.Is(err, ErrNotAuthorized), errors.Is(err, ErrNetwork):
+		return err
+	case errors.Is(err, packets.ErrorRefusedBadUsernameOrPassword), errors.Is(err, packets.ErrorRefusedNotAuthorised):
+		return brokerError{ErrNotAuthorized, err}
+	case isNetworkError(err):
+		return brokerError{ErrNetwork, err}
+	}
+	return err
+}
+
+func isNetworkError(err error) bool {
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne), errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF):
+		return true
+	case errors.Is(err, mqtt.ErrNotConnected), errors.Is(err, autopaho.ConnectionDownError), errors.Is(err, packets.ErrorRefusedServerUnavailable):
+		return true
+	}
+	// paho.mqtt.golang doesn't wrap the underlying network error when it can't connect.
+	return strings.HasPrefix(err.Error(), packets.ErrorNetworkError.Error())
+}
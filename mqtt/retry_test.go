@@ -0,0 +1,173 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mqttpaho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttfake"
+)
+
+var networkErr = fmt.Errorf("%s : connection refused", packets.ErrorNetworkError)
+
+func testClient(f *mqttfake.Client, attempts int) *Client {
+	c := NewClientForTesting(f)
+	c.conf.PublishTimeout = 50 * time.Millisecond
+	c.conf.Retry = RetryConfig{Attempts: attempts, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	return c
+}
+
+func TestConnect(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		attempts int
+		errs     []error
+
+		wantCalls int
+		wantKind  error
+	}{
+		{
+			name:      "success",
+			attempts:  3,
+			wantCalls: 1,
+		},
+		{
+			name:      "retried network failures",
+			attempts:  3,
+			errs:      []error{networkErr, networkErr},
+			wantCalls: 3,
+		},
+		{
+			name:      "too many network failures",
+			attempts:  3,
+			errs:      []error{networkErr, networkErr, networkErr},
+			wantCalls: 3,
+			wantKind:  ErrNetwork,
+		},
+		{
+			name:      "bad credentials",
+			attempts:  3,
+			errs:      []error{packets.ErrorRefusedBadUsernameOrPassword},
+			wantCalls: 1,
+			wantKind:  ErrNotAuthorized,
+		},
+		{
+			name:      "not authorized",
+			attempts:  3,
+			errs:      []error{packets.ErrorRefusedNotAuthorised},
+			wantCalls: 1,
+			wantKind:  ErrNotAuthorized,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := mqttfake.NewClient()
+			f.FailConnects(tc.errs...)
+			c := testClient(f, tc.attempts)
+
+			err := c.connect()
+			if tc.wantKind == nil && err != nil {
+				t.Errorf("connect() failed with %v", err)
+			} else if tc.wantKind != nil && !errors.Is(err, tc.wantKind) {
+				t.Errorf("connect() failed with %v, want %v", err, tc.wantKind)
+			}
+			if got := f.ConnectCalls(); got != tc.wantCalls {
+				t.Errorf("connect() tried to connect %d times, want %d", got, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPublishRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		attempts int
+		errs     []error
+
+		wantDelivered bool
+		wantKind      error
+	}{
+		{
+			name:          "retried network failures",
+			attempts:      3,
+			errs:          []error{mqttpaho.ErrNotConnected, networkErr},
+			wantDelivered: true,
+		},
+		{
+			name:     "too many network failures",
+			attempts: 2,
+			errs:     []error{mqttpaho.ErrNotConnected, mqttpaho.ErrNotConnected},
+			wantKind: ErrNetwork,
+		},
+		{
+			name:     "other failures aren't retried",
+			attempts: 3,
+			errs:     []error{errors.New("unknown payload type")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := mqttfake.NewClient()
+			delivered := make(chan struct{}, 1)
+			f.Subscribe("topic", 0, func(mqttpaho.Client, mqttpaho.Message) { delivered <- struct{}{} })
+			f.FailPublishes(tc.errs...)
+			c := testClient(f, tc.attempts)
+
+			err := c.Publish(context.Background(), "topic", QoSExactlyOnce, DoNotRetain, "payload")
+			if tc.wantDelivered && err != nil {
+				t.Errorf("Publish failed with %v", err)
+			} else if !tc.wantDelivered && err == nil {
+				t.Errorf("Publish unexpectedly succeeded")
+			} else if tc.wantKind != nil && !errors.Is(err, tc.wantKind) {
+				t.Errorf("Publish failed with %v, want %v", err, tc.wantKind)
+			}
+			select {
+			case <-delivered:
+				if !tc.wantDelivered {
+					t.Errorf("Message was unexpectedly delivered")
+				}
+			default:
+				if tc.wantDelivered {
+					t.Errorf("Message was not delivered")
+				}
+			}
+		})
+	}
+}
+
+func TestPublishTimeout(t *testing.T) {
+	f := mqttfake.NewClient()
+	release := make(chan struct{})
+	defer close(release)
+	f.Subscribe("topic", 0, func(mqttpaho.Client, mqttpaho.Message) { <-release })
+	c := testClient(f, 1)
+
+	start := time.Now()
+	err := c.Publish(context.Background(), "topic", QoSExactlyOnce, DoNotRetain, "payload")
+	if !errors.Is(err, ErrNetwork) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Publish failed with %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Publish took %s, but should've timed out after %s", d, c.conf.PublishTimeout)
+	}
+}
+
+func TestPublishCancelled(t *testing.T) {
+	f := mqttfake.NewClient()
+	f.FailPublishes(mqttpaho.ErrNotConnected)
+	c := testClient(f, 3)
+	c.conf.Retry.InitialBackoff = time.Hour
+	c.conf.Retry.MaxBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Publish(ctx, "topic", QoSExactlyOnce, DoNotRetain, "payload"); !errors.Is(err, ErrNetwork) {
+		t.Errorf("Publish failed with %v, want %v", err, ErrNetwork)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Publish returned before its context was done")
+	}
+}
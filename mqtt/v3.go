@@ -0,0 +1,84 @@
+package mqtt
+
+import (
+	"context"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// v3Conn connects to the broker with MQTT 3.1.1.
+type v3Conn struct {
+	c mqtt.Client
+}
+
+func newV3Conn(c Config, creds mqtt.CredentialsProvider, will *Will, onConnect func()) v3Conn {
+	opts := mqtt.NewClientOptions().
+		SetClientID(clientID()).
+		SetOrderMatters(false).
+		AddBroker(c.Broker).
+		SetCredentialsProvider(creds).
+		SetConnectTimeout(c.ConnectTimeout)
+	if tc := tlsConfig(c); tc != nil {
+		opts.SetTLSConfig(tc)
+	}
+	if will != nil {
+		opts.SetBinaryWill(will.Topic, []byte(will.Payload), byte(will.QoS), bool(will.Retain))
+	}
+	opts.SetOnConnectHandler(func(mqtt.Client) { onConnect() })
+
+	return v3Conn{mqtt.NewClient(opts)}
+}
+
+func (c v3Conn) connect(ctx context.Context) error {
+	if err := wait(ctx, c.c.Connect()); err != nil {
+		if ctx.Err() != nil {
+			// Stop trying to connect in the background.
+			c.c.Disconnect(0)
+		}
+		return err
+	}
+	return nil
+}
+
+// wait waits for t to complete or for ctx to be done.
+func wait(ctx context.Context, t mqtt.Token) error {
+	select {
+	case <-t.Done():
+		return t.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c v3Conn) broker() string {
+	opts := c.c.OptionsReader()
+	if s := opts.Servers(); len(s) > 0 {
+		return s[0].String()
+	}
+	return ""
+}
+
+func (c v3Conn) isConnected() bool {
+	return c.c.IsConnected()
+}
+
+// publish ignores props since MQTT 3.1.1 doesn't support them.
+func (c v3Conn) publish(ctx context.Context, topic string, qos QoS, retain RetainMode, payload interface{}, props *Properties) error {
+	return wait(ctx, c.c.Publish(topic, byte(qos), bool(retain), payload))
+}
+
+func (c v3Conn) subscribe(topic string, qos QoS, h func(Message)) error {
+	t := c.c.Subscribe(topic, byte(qos), func(_ mqtt.Client, m mqtt.Message) { h(m) })
+	t.Wait()
+	return t.Error()
+}
+
+func (c v3Conn) unsubscribe(topic string) error {
+	t := c.c.Unsubscribe(topic)
+	t.Wait()
+	return t.Error()
+}
+
+func (c v3Conn) disconnect(quiesce uint) {
+	c.c.Disconnect(quiesce)
+}
@@ -0,0 +1,209 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	v5KeepAlive     = 30 // seconds
+	v5PacketTimeout = 30 * time.Second
+)
+
+// v5Conn connects to the broker with MQTT 5.
+type v5Conn struct {
+	cfg    autopaho.ClientConfig
+	router *paho.StandardRouter
+	url    *url.URL
+
+	cm        *autopaho.ConnectionManager
+	connected int32
+	onConnect func()
+}
+
+func newV5Conn(c Config, will *Will, onConnect func()) (*v5Conn, error) {
+	u, err := url.Parse(c.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+
+	conn := &v5Conn{
+		router:    paho.NewStandardRouter(),
+		url:       u,
+		onConnect: onConnect,
+	}
+	// OnConnectionUp and OnConnectError are set by each connection attempt.
+	conn.cfg = autopaho.ClientConfig{
+		BrokerUrls:     []*url.URL{u},
+		TlsCfg:         tlsConfig(c),
+		KeepAlive:      v5KeepAlive,
+		ConnectTimeout: c.ConnectTimeout,
+		ClientConfig: paho.ClientConfig{
+			ClientID:           clientID(),
+			Router:             conn.router,
+			PacketTimeout:      v5PacketTimeout,
+			OnClientError:      func(error) { atomic.StoreInt32(&conn.connected, 0) },
+			OnServerDisconnect: func(*paho.Disconnect) { atomic.StoreInt32(&conn.connected, 0) },
+		},
+	}
+	if c.Username != "" || c.Password != "" {
+		conn.cfg.SetUsernamePassword(c.Username, []byte(c.Password))
+	}
+	if will != nil {
+		conn.cfg.SetWillMessage(will.Topic, []byte(will.Payload), byte(will.QoS), bool(will.Retain))
+	}
+	return conn, nil
+}
+
+func (c *v5Conn) connect(ctx context.Context) error {
+	// The connection manager of a failed attempt might still be running its callbacks, so each attempt gets its own state.
+	// up is closed by the first successful connection, and connErr receives the first failure.
+	up := make(chan struct{})
+	var upOnce sync.Once
+	connErr := make(chan error, 1)
+	cfg := c.cfg
+	cfg.OnConnectionUp = func(*autopaho.ConnectionManager, *paho.Connack) {
+		atomic.StoreInt32(&c.connected, 1)
+		upOnce.Do(func() { close(up) })
+		// Don't block autopaho from noticing that the connection has dropped again.
+		go c.onConnect()
+	}
+	cfg.OnConnectError = func(err error) {
+		select {
+		case connErr <- err:
+		default:
+		}
+	}
+
+	cm, err := autopaho.NewConnection(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	// autopaho retries forever, but failures are retried by Client instead so that they're bounded.
+	select {
+	case <-up:
+		c.cm = cm
+		return nil
+	case err = <-connErr:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	cm.Disconnect(ctx)
+	atomic.StoreInt32(&c.connected, 0)
+	return err
+}
+
+func (c *v5Conn) broker() string {
+	return c.url.String()
+}
+
+func (c *v5Conn) isConnected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+func (c *v5Conn) publish(ctx context.Context, topic string, qos QoS, retain RetainMode, payload interface{}, props *Properties) error {
+	b, err := payloadBytes(payload)
+	if err != nil {
+		return err
+	}
+	p := &paho.Publish{
+		QoS:        byte(qos),
+		Retain:     bool(retain),
+		Topic:      topic,
+		Payload:    b,
+		Properties: publishProperties(props),
+	}
+	_, err = c.cm.Publish(ctx, p)
+	return err
+}
+
+func publishProperties(props *Properties) *paho.PublishProperties {
+	if props == nil {
+		return nil
+	}
+	pp := &paho.PublishProperties{ContentType: props.ContentType}
+	if props.MessageExpiry > 0 {
+		// The expiry interval is in seconds, and zero would mean that it never expires.
+		s := uint32(props.MessageExpiry.Round(time.Second) / time.Second)
+		if s == 0 {
+			s = 1
+		}
+		pp.MessageExpiry = &s
+	}
+	var ks []string
+	for k := range props.UserProperties {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	for _, k := range ks {
+		pp.User.Add(k, props.UserProperties[k])
+	}
+	return pp
+}
+
+func (c *v5Conn) subscribe(topic string, qos QoS, h func(Message)) error {
+	c.router.RegisterHandler(topic, func(p *paho.Publish) { h(v5Message{p}) })
+	ctx, cancel := context.WithTimeout(context.Background(), v5PacketTimeout)
+	defer cancel()
+	if _, err := c.cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{topic: {QoS: byte(qos)}},
+	}); err != nil {
+		c.router.UnregisterHandler(topic)
+		return err
+	}
+	return nil
+}
+
+func (c *v5Conn) unsubscribe(topic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5PacketTimeout)
+	defer cancel()
+	if _, err := c.cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{topic}}); err != nil {
+		return err
+	}
+	c.router.UnregisterHandler(topic)
+	return nil
+}
+
+func (c *v5Conn) disconnect(quiesce uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond)
+	defer cancel()
+	c.cm.Disconnect(ctx)
+	atomic.StoreInt32(&c.connected, 0)
+}
+
+// payloadBytes converts payload in the same way that the MQTT 3.1.1 client does.
+func payloadBytes(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case string:
+		return []byte(p), nil
+	case []byte:
+		return p, nil
+	case bytes.Buffer:
+		return p.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown payload type %T", payload)
+}
+
+// v5Message adapts an MQTT 5 message to Message.
+type v5Message struct {
+	p *paho.Publish
+}
+
+func (m v5Message) Duplicate() bool   { return false }
+func (m v5Message) Qos() byte         { return m.p.QoS }
+func (m v5Message) Retained() bool    { return m.p.Retain }
+func (m v5Message) Topic() string     { return m.p.Topic }
+func (m v5Message) MessageID() uint16 { return m.p.PacketID }
+func (m v5Message) Payload() []byte   { return m.p.Payload }
+func (m v5Message) Ack()              {}
@@ -0,0 +1,302 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/new"
+)
+
+func TestPublishProperties(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		props *Properties
+		want  *paho.PublishProperties
+	}{
+		{
+			name: "no properties",
+		},
+		{
+			name: "all properties",
+			props: &Properties{
+				ContentType:    "application/json",
+				MessageExpiry:  time.Hour,
+				UserProperties: map[string]string{"job_id": "backup", "exit_code": "0"},
+			},
+			want: &paho.PublishProperties{
+				ContentType:   "application/json",
+				MessageExpiry: new.Ptr(uint32(3600)),
+				User:          paho.UserProperties{{Key: "exit_code", Value: "0"}, {Key: "job_id", Value: "backup"}},
+			},
+		},
+		{
+			name:  "sub-second expiry",
+			props: &Properties{MessageExpiry: time.Millisecond},
+			want:  &paho.PublishProperties{MessageExpiry: new.Ptr(uint32(1))},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, publishProperties(tc.props)); diff != "" {
+				t.Errorf("publishProperties(%+v) diff (-want +got):\n%s", tc.props, diff)
+			}
+		})
+	}
+}
+
+func TestV5Client(t *testing.T) {
+	b := startV5Broker(t, 0)
+	c, err := NewClient(Config{Broker: b.url(), ProtocolVersion: ProtocolVersion5})
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	defer c.Close(0)
+
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	ms := make(chan Message, 10)
+	if err := c.Subscribe(ctx, "foo/#", QoSExactlyOnce, ms); err != nil {
+		t.Fatalf("Subscribe failed: %s", err)
+	}
+	for _, qos := range []QoS{QoSAtMostOnce, QoSAtLeastOnce, QoSExactlyOnce} {
+		topic := fmt.Sprintf("foo/qos%d", qos)
+		if err := c.Publish(ctx, topic, qos, DoNotRetain, "hello"); err != nil {
+			t.Fatalf("Publish with QoS %d failed: %s", qos, err)
+		}
+		select {
+		case m := <-ms:
+			if m.Topic() != topic || string(m.Payload()) != "hello" {
+				t.Errorf("Received %s %q, want %s %q", m.Topic(), m.Payload(), topic, "hello")
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive the message published with QoS %d", qos)
+		}
+	}
+
+	if err := c.Publish(ctx, "bar", QoSAtLeastOnce, Retain, WithProperties("expiring", Properties{MessageExpiry: time.Minute})); err != nil {
+		t.Fatalf("Publish with properties failed: %s", err)
+	}
+	p := b.last()
+	if p.Topic != "bar" || !p.Retain || p.Properties.MessageExpiry == nil || *p.Properties.MessageExpiry != 60 {
+		t.Errorf("Broker received %s (retained: %t, properties: %+v), want retained bar with a message expiry of 60s", p.Topic, p.Retain, p.Properties)
+	}
+	select {
+	case m := <-ms:
+		t.Errorf("Received %s, which doesn't match the subscription", m.Topic())
+	default:
+	}
+}
+
+func TestV5ConnectRetry(t *testing.T) {
+	b := startV5Broker(t, 2)
+	c, err := NewClient(Config{
+		Broker:          b.url(),
+		ProtocolVersion: ProtocolVersion5,
+		Retry:           RetryConfig{Attempts: 3, InitialBackoff: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	defer c.Close(0)
+	if !c.c.isConnected() {
+		t.Errorf("Client isn't connected after NewClient succeeded")
+	}
+	if err := c.Publish(context.Background(), "foo", QoSAtLeastOnce, DoNotRetain, "hello"); err != nil {
+		t.Errorf("Publish failed: %s", err)
+	}
+}
+
+// v5Broker is a minimal MQTT 5 broker. It delivers messages to the matching subscriptions of every client with QoS 0, and doesn't retain them.
+type v5Broker struct {
+	l net.Listener
+	// refuse is how many more connections are dropped instead of being acknowledged.
+	refuse int32
+
+	mut       sync.Mutex
+	conns     map[*v5BrokerConn]bool
+	published []*packets.Publish
+
+	wg sync.WaitGroup
+}
+
+type v5BrokerConn struct {
+	conn net.Conn
+	// mut guards writes to conn and subs.
+	mut  sync.Mutex
+	subs map[string]bool
+}
+
+func startV5Broker(t *testing.T, refuse int32) *v5Broker {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not start MQTT broker: %s", err)
+	}
+	b := &v5Broker{l: l, refuse: refuse, conns: make(map[*v5BrokerConn]bool)}
+	b.wg.Add(1)
+	go b.serve()
+	t.Cleanup(b.close)
+	return b
+}
+
+func (b *v5Broker) url() string {
+	return "tcp://" + b.l.Addr().String()
+}
+
+func (b *v5Broker) close() {
+	b.l.Close()
+	b.mut.Lock()
+	for c := range b.conns {
+		c.conn.Close()
+	}
+	b.mut.Unlock()
+	b.wg.Wait()
+}
+
+// last returns the message that was most recently published to the broker.
+func (b *v5Broker) last() *packets.Publish {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if len(b.published) == 0 {
+		return &packets.Publish{Properties: &packets.Properties{}}
+	}
+	return b.published[len(b.published)-1]
+}
+
+func (b *v5Broker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &v5BrokerConn{conn: conn, subs: make(map[string]bool)}
+		b.mut.Lock()
+		b.conns[c] = true
+		b.mut.Unlock()
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handle(c)
+			conn.Close()
+			b.mut.Lock()
+			delete(b.conns, c)
+			b.mut.Unlock()
+		}()
+	}
+}
+
+func (b *v5Broker) handle(c *v5BrokerConn) {
+	for {
+		cp, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		var resp *packets.ControlPacket
+		switch p := cp.Content.(type) {
+		case *packets.Connect:
+			if atomic.AddInt32(&b.refuse, -1) >= 0 {
+				return
+			}
+			resp = packets.NewControlPacket(packets.CONNACK)
+		case *packets.Publish:
+			// ReadPacket doesn't decode the retain flag.
+			p.Retain = cp.Flags&1 == 1
+			b.publish(p)
+			switch p.QoS {
+			case 1:
+				resp = packets.NewControlPacket(packets.PUBACK)
+				resp.Content.(*packets.Puback).PacketID = p.PacketID
+			case 2:
+				resp = packets.NewControlPacket(packets.PUBREC)
+				resp.Content.(*packets.Pubrec).PacketID = p.PacketID
+			}
+		case *packets.Pubrel:
+			resp = packets.NewControlPacket(packets.PUBCOMP)
+			resp.Content.(*packets.Pubcomp).PacketID = p.PacketID
+		case *packets.Subscribe:
+			resp = packets.NewControlPacket(packets.SUBACK)
+			sa := resp.Content.(*packets.Suback)
+			sa.PacketID = p.PacketID
+			c.mut.Lock()
+			for topic := range p.Subscriptions {
+				c.subs[topic] = true
+				sa.Reasons = append(sa.Reasons, 0)
+			}
+			c.mut.Unlock()
+		case *packets.Unsubscribe:
+			resp = packets.NewControlPacket(packets.UNSUBACK)
+			ua := resp.Content.(*packets.Unsuback)
+			ua.PacketID = p.PacketID
+			c.mut.Lock()
+			for _, topic := range p.Topics {
+				delete(c.subs, topic)
+				ua.Reasons = append(ua.Reasons, 0)
+			}
+			c.mut.Unlock()
+		case *packets.Pingreq:
+			resp = packets.NewControlPacket(packets.PINGRESP)
+		case *packets.Disconnect:
+			return
+		}
+		if resp != nil && c.write(resp) != nil {
+			return
+		}
+	}
+}
+
+func (b *v5Broker) publish(p *packets.Publish) {
+	b.mut.Lock()
+	b.published = append(b.published, p)
+	var cs []*v5BrokerConn
+	for c := range b.conns {
+		cs = append(cs, c)
+	}
+	b.mut.Unlock()
+
+	for _, c := range cs {
+		c.mut.Lock()
+		match := false
+		for filter := range c.subs {
+			match = match || topicMatches(filter, p.Topic)
+		}
+		c.mut.Unlock()
+		if match {
+			fwd := packets.NewControlPacket(packets.PUBLISH)
+			f := fwd.Content.(*packets.Publish)
+			f.Topic = p.Topic
+			f.Payload = p.Payload
+			c.write(fwd)
+		}
+	}
+}
+
+func (c *v5BrokerConn) write(cp *packets.ControlPacket) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	_, err := cp.WriteTo(c.conn)
+	return err
+}
+
+// topicMatches reports whether topic matches filter, which may contain wildcards.
+func topicMatches(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,143 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyConfig configures how payloads are signed and verified.
+type KeyConfig struct {
+	Algorithm Algorithm `mapstructure:"algorithm"`
+	// Key is the base64 encoded secret key: the shared key for HMACSHA256, or the 32 byte seed of the private key for Ed25519.
+	Key string `mapstructure:"key,omitempty"`
+	// KeyFile is a file that contains Key, so that it doesn't have to be stored in the config file.
+	KeyFile string `mapstructure:"key_file,omitempty"`
+	// PublicKey is the base64 encoded Ed25519 public key. It's enough to verify payloads, so consumers don't need the private key.
+	PublicKey string `mapstructure:"public_key,omitempty"`
+}
+
+// Enabled reports whether anything has been configured.
+func (c KeyConfig) Enabled() bool {
+	return c != KeyConfig{}
+}
+
+func (c KeyConfig) secret() ([]byte, error) {
+	s := c.Key
+	switch {
+	case c.Key != "" && c.KeyFile != "":
+		return nil, errors.New("only one of key and key_file can be set")
+	case c.KeyFile != "":
+		b, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read key file: %w", err)
+		}
+		s = strings.TrimSpace(string(b))
+	case c.Key == "":
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	return b, nil
+}
+
+func (c KeyConfig) privateKey() (ed25519.PrivateKey, error) {
+	b, err := c.secret()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errors.New("key or key_file must be set to sign payloads")
+	}
+	if len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ed25519 keys must be %d bytes, not %d", ed25519.SeedSize, len(b))
+	}
+	return ed25519.NewKeyFromSeed(b), nil
+}
+
+// Signer returns a Signer for the configured key.
+func (c KeyConfig) Signer() (Signer, error) {
+	switch c.Algorithm {
+	case HMACSHA256:
+		b, err := c.hmacKey()
+		if err != nil {
+			return nil, err
+		}
+		return HMACSigner(b), nil
+	case Ed25519:
+		k, err := c.privateKey()
+		if err != nil {
+			return nil, err
+		}
+		return Ed25519Signer(k), nil
+	}
+	return nil, c.unknownAlgorithm()
+}
+
+// Verifier returns a Verifier for the configured key. Ed25519 payloads can be verified with either the public key or the private key.
+func (c KeyConfig) Verifier() (Verifier, error) {
+	switch c.Algorithm {
+	case HMACSHA256:
+		b, err := c.hmacKey()
+		if err != nil {
+			return nil, err
+		}
+		return HMACVerifier(b), nil
+	case Ed25519:
+		pub, err := c.ed25519PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		return Ed25519Verifier(pub), nil
+	}
+	return nil, c.unknownAlgorithm()
+}
+
+// Ed25519PublicKey returns the public key that consumers need to verify payloads signed with the configured private key.
+func (c KeyConfig) Ed25519PublicKey() (ed25519.PublicKey, error) {
+	if c.Algorithm != Ed25519 {
+		return nil, fmt.Errorf("%s keys don't have a public key", c.Algorithm)
+	}
+	return c.ed25519PublicKey()
+}
+
+func (c KeyConfig) ed25519PublicKey() (ed25519.PublicKey, error) {
+	if c.PublicKey == "" {
+		k, err := c.privateKey()
+		if err != nil {
+			return nil, err
+		}
+		return k.Public().(ed25519.PublicKey), nil
+	}
+	b, err := base64.StdEncoding.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("public_key is not valid base64: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 public keys must be %d bytes, not %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+func (c KeyConfig) hmacKey() ([]byte, error) {
+	if c.PublicKey != "" {
+		return nil, fmt.Errorf("public_key can't be used with %s", HMACSHA256)
+	}
+	b, err := c.secret()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("key or key_file must be set for %s", HMACSHA256)
+	}
+	return b, nil
+}
+
+func (c KeyConfig) unknownAlgorithm() error {
+	return fmt.Errorf("unknown signing algorithm %q (must be %s or %s)", c.Algorithm, HMACSHA256, Ed25519)
+}
@@ -0,0 +1,167 @@
+// Package signing signs and verifies the JSON payloads that cron2mqtt publishes, so that consumers can tell whether a payload really came from a particular host.
+//
+// A signed payload is the original JSON object with an extra "signature" field appended to it, e.g.
+//
+//	{"exit_code":0,"signature":"hmac-sha256:<base64>"}
+//
+// The signature covers the topic that the payload is published to, followed by a NUL byte (which topics can't contain) and the exact bytes of the original object, which Verify reconstructs by removing the "signature" field.
+// Binding the topic means that a signed payload can't be replayed on another topic, e.g. as the results of another cron job or host.
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Algorithm identifies how a payload is signed.
+type Algorithm string
+
+const (
+	// HMACSHA256 signs payloads with a key that's shared between the publisher and its consumers.
+	HMACSHA256 Algorithm = "hmac-sha256"
+	// Ed25519 signs payloads with a private key. Consumers only need the public key.
+	Ed25519 Algorithm = "ed25519"
+)
+
+// FieldName is the name of the JSON field that holds the signature.
+const FieldName = "signature"
+
+var (
+	// ErrUnsigned is returned by Verify when the payload doesn't have a signature.
+	ErrUnsigned = errors.New("payload is not signed")
+	// ErrInvalidSignature is returned by Verify when the signature doesn't match the payload.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Signer signs payloads.
+type Signer interface {
+	Algorithm() Algorithm
+	// Sign returns payload, which must be a JSON object that will be published to topic, with a signature field added.
+	Sign(topic string, payload []byte) ([]byte, error)
+}
+
+// Verifier verifies payloads that were signed by a Signer.
+type Verifier interface {
+	Algorithm() Algorithm
+	// Verify checks the signature of a signed payload that was received on topic, and returns the payload as it was before it was signed.
+	Verify(topic string, signed []byte) ([]byte, error)
+}
+
+type hmacKey []byte
+
+// HMACSigner signs payloads with HMAC-SHA256.
+func HMACSigner(key []byte) Signer { return hmacKey(key) }
+
+// HMACVerifier verifies payloads that were signed by HMACSigner with the same key.
+func HMACVerifier(key []byte) Verifier { return hmacKey(key) }
+
+func (k hmacKey) Algorithm() Algorithm { return HMACSHA256 }
+
+func (k hmacKey) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, k)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func (k hmacKey) Sign(topic string, payload []byte) ([]byte, error) {
+	return sign(k.Algorithm(), topic, payload, k.mac)
+}
+
+func (k hmacKey) Verify(topic string, signed []byte) ([]byte, error) {
+	return verify(k.Algorithm(), topic, signed, func(data, sig []byte) bool {
+		return hmac.Equal(k.mac(data), sig)
+	})
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+// Ed25519Signer signs payloads with an Ed25519 private key.
+func Ed25519Signer(key ed25519.PrivateKey) Signer { return ed25519Signer(key) }
+
+func (k ed25519Signer) Algorithm() Algorithm { return Ed25519 }
+
+func (k ed25519Signer) Sign(topic string, payload []byte) ([]byte, error) {
+	return sign(k.Algorithm(), topic, payload, func(data []byte) []byte {
+		return ed25519.Sign(ed25519.PrivateKey(k), data)
+	})
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+// Ed25519Verifier verifies payloads that were signed by Ed25519Signer with the private key that corresponds to key.
+func Ed25519Verifier(key ed25519.PublicKey) Verifier { return ed25519Verifier(key) }
+
+func (k ed25519Verifier) Algorithm() Algorithm { return Ed25519 }
+
+func (k ed25519Verifier) Verify(topic string, signed []byte) ([]byte, error) {
+	return verify(k.Algorithm(), topic, signed, func(data, sig []byte) bool {
+		// ed25519.Verify panics on malformed keys.
+		return len(k) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(k), data, sig)
+	})
+}
+
+// signedData is what's actually signed: the topic and the payload, separated by a NUL byte.
+func signedData(topic string, payload []byte) []byte {
+	b := make([]byte, 0, len(topic)+1+len(payload))
+	b = append(b, topic...)
+	b = append(b, 0)
+	return append(b, payload...)
+}
+
+func sign(alg Algorithm, topic string, payload []byte, f func([]byte) []byte) ([]byte, error) {
+	if len(payload) < 2 || payload[0] != '{' || payload[len(payload)-1] != '}' {
+		return nil, errors.New("only JSON objects can be signed")
+	}
+	body := payload[:len(payload)-1]
+
+	var b bytes.Buffer
+	b.Write(body)
+	if !isEmptyObject(body) {
+		b.WriteByte(',')
+	}
+	fmt.Fprintf(&b, "%q:\"%s:%s\"}", FieldName, alg, base64.StdEncoding.EncodeToString(f(signedData(topic, payload))))
+	return b.Bytes(), nil
+}
+
+func verify(alg Algorithm, topic string, signed []byte, f func(data, sig []byte) bool) ([]byte, error) {
+	marker := []byte(fmt.Sprintf("%q:\"", FieldName))
+	i := bytes.LastIndex(signed, marker)
+	if i < 0 || !bytes.HasSuffix(signed, []byte(`"}`)) {
+		return nil, ErrUnsigned
+	}
+	value := signed[i+len(marker) : len(signed)-2]
+	body := signed[:i]
+	// Only accept exactly what sign would have produced.
+	if bytes.HasSuffix(body, []byte(",")) && !isEmptyObject(body[:len(body)-1]) {
+		body = body[:len(body)-1]
+	} else if !isEmptyObject(body) {
+		return nil, fmt.Errorf("%w: the %s field must be the last field of the object", ErrInvalidSignature, FieldName)
+	}
+	payload := append(body[:len(body):len(body)], '}')
+
+	a, s, ok := bytes.Cut(value, []byte(":"))
+	if !ok {
+		return nil, fmt.Errorf("%w: missing algorithm", ErrInvalidSignature)
+	}
+	if Algorithm(a) != alg {
+		return nil, fmt.Errorf("%w: payload is signed with %s, not %s", ErrInvalidSignature, a, alg)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if !f(signedData(topic, payload), sig) {
+		return nil, ErrInvalidSignature
+	}
+	return payload, nil
+}
+
+// isEmptyObject reports whether body, a JSON object without its closing brace, has no fields.
+func isEmptyObject(body []byte) bool {
+	return bytes.Equal(bytes.TrimRight(body, " \t\r\n"), []byte("{"))
+}
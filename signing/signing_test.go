@@ -0,0 +1,252 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var (
+	hmacConfig    = KeyConfig{Algorithm: HMACSHA256, Key: base64.StdEncoding.EncodeToString([]byte("shared secret"))}
+	ed25519Config = KeyConfig{Algorithm: Ed25519, Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, ed25519.SeedSize))}
+)
+
+const topic = "cron2mqtt/host/job/results"
+
+func TestRoundTrip(t *testing.T) {
+	for _, conf := range []KeyConfig{hmacConfig, ed25519Config} {
+		s, err := conf.Signer()
+		if err != nil {
+			t.Fatalf("%s: Signer() = _, %v", conf.Algorithm, err)
+		}
+		v, err := conf.Verifier()
+		if err != nil {
+			t.Fatalf("%s: Verifier() = _, %v", conf.Algorithm, err)
+		}
+
+		for _, payload := range []string{
+			`{"exit_code":0,"stdout":"signature"}`,
+			`{}`,
+			"{ \n}",
+			`{"signature":"user supplied"}`,
+		} {
+			signed, err := s.Sign(topic, []byte(payload))
+			if err != nil {
+				t.Fatalf("%s: Sign(%s) = _, %v", conf.Algorithm, payload, err)
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(signed, &m); err != nil {
+				t.Errorf("%s: Sign(%s) = %s, which isn't valid JSON: %v", conf.Algorithm, payload, signed, err)
+			}
+			got, err := v.Verify(topic, signed)
+			if err != nil {
+				t.Errorf("%s: Verify(%s) = _, %v", conf.Algorithm, signed, err)
+			} else if string(got) != payload {
+				t.Errorf("%s: Verify(%s) = %s, want %s", conf.Algorithm, signed, got, payload)
+			}
+		}
+	}
+}
+
+func TestSignNonObject(t *testing.T) {
+	s, err := hmacConfig.Signer()
+	if err != nil {
+		t.Fatalf("Signer() = _, %v", err)
+	}
+	for _, payload := range []string{``, `[]`, `"ON"`, `1`} {
+		if got, err := s.Sign(topic, []byte(payload)); err == nil {
+			t.Errorf("Sign(%q) = %s, want error", payload, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s, err := hmacConfig.Signer()
+	if err != nil {
+		t.Fatalf("Signer() = _, %v", err)
+	}
+	signed, err := s.Sign(topic, []byte(`{"exit_code":1}`))
+	if err != nil {
+		t.Fatalf("Sign() = _, %v", err)
+	}
+	otherKey := KeyConfig{Algorithm: HMACSHA256, Key: base64.StdEncoding.EncodeToString([]byte("other secret"))}
+
+	for _, tc := range []struct {
+		name    string
+		conf    KeyConfig
+		topic   string
+		payload []byte
+		want    error
+	}{
+		{
+			name:    "valid",
+			conf:    hmacConfig,
+			payload: signed,
+		},
+		{
+			name:    "other topic",
+			conf:    hmacConfig,
+			topic:   "cron2mqtt/host/other/results",
+			payload: signed,
+			want:    ErrInvalidSignature,
+		},
+		{
+			name:    "topic prefix",
+			conf:    hmacConfig,
+			topic:   "cron2mqtt/host/job/results/x",
+			payload: signed,
+			want:    ErrInvalidSignature,
+		},
+		{
+			name:    "unsigned",
+			conf:    hmacConfig,
+			payload: []byte(`{"exit_code":0}`),
+			want:    ErrUnsigned,
+		},
+		{
+			name:    "tampered",
+			conf:    hmacConfig,
+			payload: bytes.Replace(signed, []byte(`"exit_code":1`), []byte(`"exit_code":0`), 1),
+			want:    ErrInvalidSignature,
+		},
+		{
+			name:    "extra field",
+			conf:    hmacConfig,
+			payload: bytes.Replace(signed, []byte(`{`), []byte(`{"a":1,`), 1),
+			want:    ErrInvalidSignature,
+		},
+		{
+			name:    "wrong key",
+			conf:    otherKey,
+			payload: signed,
+			want:    ErrInvalidSignature,
+		},
+		{
+			name:    "wrong algorithm",
+			conf:    ed25519Config,
+			payload: signed,
+			want:    ErrInvalidSignature,
+		},
+		{
+			name:    "signature isn't last",
+			conf:    hmacConfig,
+			payload: []byte(`{"signature":"hmac-sha256:AAAA" ,"exit_code":1}`),
+			want:    ErrUnsigned,
+		},
+		{
+			name:    "extra comma",
+			conf:    hmacConfig,
+			payload: []byte(`{,"signature":"hmac-sha256:AAAA"}`),
+			want:    ErrInvalidSignature,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := tc.conf.Verifier()
+			if err != nil {
+				t.Fatalf("Verifier() = _, %v", err)
+			}
+			onTopic := tc.topic
+			if onTopic == "" {
+				onTopic = topic
+			}
+			if _, err := v.Verify(onTopic, tc.payload); !errors.Is(err, tc.want) {
+				t.Errorf("Verify(%s, %s) = _, %v, want %v", onTopic, tc.payload, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeyConfig(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "key")
+	if err := os.WriteFile(f, []byte(ed25519Config.Key+"\n"), 0600); err != nil {
+		t.Fatalf("Could not write key file: %s", err)
+	}
+	pub, err := ed25519Config.Ed25519PublicKey()
+	if err != nil {
+		t.Fatalf("Ed25519PublicKey() = _, %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		conf KeyConfig
+
+		wantSignErr   *regexp.Regexp
+		wantVerifyErr *regexp.Regexp
+	}{
+		{
+			name: "key file",
+			conf: KeyConfig{Algorithm: Ed25519, KeyFile: f},
+		},
+		{
+			name:        "public key only",
+			conf:        KeyConfig{Algorithm: Ed25519, PublicKey: base64.StdEncoding.EncodeToString(pub)},
+			wantSignErr: regexp.MustCompile("key or key_file must be set"),
+		},
+		{
+			name:          "key and key file",
+			conf:          KeyConfig{Algorithm: Ed25519, Key: ed25519Config.Key, KeyFile: f},
+			wantSignErr:   regexp.MustCompile("only one of key and key_file"),
+			wantVerifyErr: regexp.MustCompile("only one of key and key_file"),
+		},
+		{
+			name:          "short ed25519 key",
+			conf:          KeyConfig{Algorithm: Ed25519, Key: base64.StdEncoding.EncodeToString([]byte("short"))},
+			wantSignErr:   regexp.MustCompile("must be 32 bytes, not 5"),
+			wantVerifyErr: regexp.MustCompile("must be 32 bytes, not 5"),
+		},
+		{
+			name:          "invalid base64",
+			conf:          KeyConfig{Algorithm: HMACSHA256, Key: "not base64!"},
+			wantSignErr:   regexp.MustCompile("not valid base64"),
+			wantVerifyErr: regexp.MustCompile("not valid base64"),
+		},
+		{
+			name:          "hmac public key",
+			conf:          KeyConfig{Algorithm: HMACSHA256, PublicKey: base64.StdEncoding.EncodeToString(pub)},
+			wantSignErr:   regexp.MustCompile("public_key can't be used"),
+			wantVerifyErr: regexp.MustCompile("public_key can't be used"),
+		},
+		{
+			name:          "unknown algorithm",
+			conf:          KeyConfig{Algorithm: "rot13", Key: hmacConfig.Key},
+			wantSignErr:   regexp.MustCompile(`unknown signing algorithm "rot13"`),
+			wantVerifyErr: regexp.MustCompile(`unknown signing algorithm "rot13"`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := tc.conf.Signer()
+			if !matches(err, tc.wantSignErr) {
+				t.Fatalf("Signer() = _, %v, want error matching %v", err, tc.wantSignErr)
+			}
+			v, err := tc.conf.Verifier()
+			if !matches(err, tc.wantVerifyErr) {
+				t.Fatalf("Verifier() = _, %v, want error matching %v", err, tc.wantVerifyErr)
+			}
+			if s == nil || v == nil {
+				return
+			}
+
+			signed, err := s.Sign(topic, []byte(`{}`))
+			if err != nil {
+				t.Fatalf("Sign() = _, %v", err)
+			}
+			if _, err := v.Verify(topic, signed); err != nil {
+				t.Errorf("Verify(%s) = _, %v", signed, err)
+			}
+		})
+	}
+}
+
+func matches(err error, want *regexp.Regexp) bool {
+	if err == nil || want == nil {
+		return err == nil && want == nil
+	}
+	return want.MatchString(err.Error())
+}
@@ -0,0 +1,196 @@
+// Package webhook publishes cron jobs' results as HTTP pings instead of MQTT messages, for hosts that can't reach an MQTT broker.
+//
+// Pings are compatible with healthchecks.io (https://healthchecks.io/docs/http_api/) or Uptime Kuma's push monitors.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/JeffreyFalgout/cron2mqtt/logutil"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+// Format determines how pings are sent.
+type Format string
+
+const (
+	// Healthchecks POSTs to <url>/start when a cron job starts, and POSTs its results to <url> when it succeeds or <url>/fail when it fails.
+	Healthchecks Format = "healthchecks"
+	// UptimeKuma pings <url>?status=up or <url>?status=down when a cron job finishes. Uptime Kuma doesn't have start pings.
+	UptimeKuma Format = "uptime_kuma"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// Config configures the pings for a cron job.
+type Config struct {
+	// URL is the ping URL. It's a Go template that can use {{.ID}}, the ID of the cron job (e.g. https://hc-ping.com/<ping key>/{{.ID}}).
+	URL string `mapstructure:"url,omitempty"`
+	// URLs are the ping URLs of particular cron jobs, keyed by their IDs. They take precedence over URL.
+	URLs map[string]string `mapstructure:"urls,omitempty"`
+	// Format defaults to Healthchecks.
+	Format Format `mapstructure:"format,omitempty"`
+	// Timeout bounds each attempt to ping. It defaults to DefaultTimeout.
+	Timeout time.Duration `mapstructure:"timeout,omitempty"`
+	// Retry configures how pings are retried after network failures or server errors.
+	Retry mqtt.RetryConfig `mapstructure:"retry,omitempty"`
+}
+
+// Client pings a cron job's URL. It implements mqttcron.Client so that it can be used instead of an mqtt.Client: only the results are pinged, and everything else that's published is ignored.
+type Client struct {
+	conf Config
+	url  string
+	http *http.Client
+	// resultsTopic is where CorePlugin publishes the cron job's results.
+	resultsTopic string
+}
+
+var _ mqttcron.Client = (*Client)(nil)
+
+// NewClient creates a Client that pings the URL for the cron job id.
+func NewClient(conf Config, id string) (*Client, error) {
+	switch conf.Format {
+	case "":
+		conf.Format = Healthchecks
+	case Healthchecks, UptimeKuma:
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q (must be %s or %s)", conf.Format, Healthchecks, UptimeKuma)
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+
+	u, err := conf.urlFor(id)
+	if err != nil {
+		return nil, err
+	}
+	cj, err := mqttcron.ExistingCronJob(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	var core *mqttcron.CorePlugin
+	cj.Plugin(&core)
+	return &Client{conf: conf, url: u, http: &http.Client{}, resultsTopic: core.ResultsTopic}, nil
+}
+
+func (c Config) urlFor(id string) (string, error) {
+	// viper's keys are case insensitive.
+	for k, u := range c.URLs {
+		if strings.EqualFold(k, id) {
+			return u, nil
+		}
+	}
+	if c.URL == "" {
+		return "", fmt.Errorf("no webhook URL is configured for %s", id)
+	}
+
+	t, err := template.New("url").Option("missingkey=error").Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook URL template: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, struct{ ID string }{url.PathEscape(id)}); err != nil {
+		return "", fmt.Errorf("invalid webhook URL template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// Start pings that the cron job has started, if the format supports it.
+func (c *Client) Start(ctx context.Context) error {
+	if c.conf.Format != Healthchecks {
+		return nil
+	}
+	return c.ping(ctx, c.url+"/start", nil)
+}
+
+// Publish pings the cron job's results when they're published to its results topic. Other messages are ignored.
+func (c *Client) Publish(ctx context.Context, topic string, qos mqtt.QoS, retain mqtt.RetainMode, payload interface{}) error {
+	if topic != c.resultsTopic {
+		log.Trace().Str("topic", topic).Msg("Ignoring message that isn't a webhook ping")
+		return nil
+	}
+	b, err := mqtt.PayloadBytes(payload)
+	if err != nil {
+		return err
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return fmt.Errorf("could not unmarshal results: %w", err)
+	}
+	exitCode, _ := res[mqttcron.ExitCodeAttributeName].(float64)
+	duration, _ := res[mqttcron.DurationAttributeName].(float64)
+
+	switch c.conf.Format {
+	case UptimeKuma:
+		q := url.Values{"status": {"up"}, "msg": {"OK"}, "ping": {strconv.FormatFloat(duration, 'f', -1, 64)}}
+		if exitCode != 0 {
+			q.Set("status", "down")
+			q.Set("msg", fmt.Sprintf("exit code %v", exitCode))
+		}
+		return c.ping(ctx, c.url+"?"+q.Encode(), b)
+	default:
+		u := c.url
+		if exitCode != 0 {
+			u += "/fail"
+		}
+		return c.ping(ctx, u, b)
+	}
+}
+
+// Subscribe always fails, since webhooks can only be published to.
+func (c *Client) Subscribe(ctx context.Context, topic string, qos mqtt.QoS, messages chan<- mqtt.Message) error {
+	close(messages)
+	return errors.New("webhooks can't be subscribed to")
+}
+
+// errRetryable marks failures that might succeed if they're retried.
+var errRetryable = errors.New("retryable")
+
+func (c *Client) ping(ctx context.Context, u string, body []byte) error {
+	log := log.With().Str("url", u).Logger()
+	defer logutil.StartTimerLogger(log, zerolog.DebugLevel, "Pinging webhook").Stop()
+	return c.conf.Retry.Retry(ctx, log, func(err error) bool { return errors.Is(err, errRetryable) }, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, c.conf.Timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("%w: %v", errRetryable, err)
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+		switch {
+		case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+			return fmt.Errorf("%w: webhook responded with %s: %s", errRetryable, resp.Status, bytes.TrimSpace(msg))
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("webhook responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt"
+	"github.com/JeffreyFalgout/cron2mqtt/mqtt/mqttcron"
+)
+
+// ping is a request that was received by a fakeServer.
+type ping struct {
+	Method string
+	// URL is the request's path and query.
+	URL  string
+	Body string
+}
+
+type fakeServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	pings  []ping
+	status []int
+}
+
+// newFakeServer starts a server that responds with each of the statuses in turn, and then with http.StatusOK.
+func newFakeServer(t *testing.T, status ...int) *fakeServer {
+	s := &fakeServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Could not read request body: %s", err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.pings = append(s.pings, ping{r.Method, r.URL.RequestURI(), string(b)})
+		if len(s.status) > 0 {
+			w.WriteHeader(s.status[0])
+			s.status = s.status[1:]
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) Pings() []ping {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ping(nil), s.pings...)
+}
+
+var fastRetries = mqtt.RetryConfig{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func TestPublish(t *testing.T) {
+	const (
+		succeeded = `{"duration_ms":1500,"exit_code":0}`
+		failed    = `{"duration_ms":20,"exit_code":2}`
+	)
+
+	d, err := mqttcron.CurrentDevice()
+	if err != nil {
+		t.Fatalf("CurrentDevice failed: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+
+		conf   Config
+		status []int
+		// topic is relative to the device's topic prefix.
+		topic   string
+		payload interface{}
+
+		want    []ping
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:    "healthchecks success",
+			topic:   "id/results",
+			payload: succeeded,
+			want:    []ping{{"POST", "/ping/id", succeeded}},
+		},
+		{
+			name:    "healthchecks failure",
+			topic:   "id/results",
+			payload: []byte(failed),
+			want:    []ping{{"POST", "/ping/id/fail", failed}},
+		},
+		{
+			name:    "properties",
+			topic:   "id/results",
+			payload: mqtt.WithProperties([]byte(succeeded), mqtt.Properties{ContentType: "application/json"}),
+			want:    []ping{{"POST", "/ping/id", succeeded}},
+		},
+		{
+			name:    "uptime kuma up",
+			conf:    Config{Format: UptimeKuma},
+			topic:   "id/results",
+			payload: succeeded,
+			want:    []ping{{"POST", "/ping/id?msg=OK&ping=1500&status=up", succeeded}},
+		},
+		{
+			name:    "uptime kuma down",
+			conf:    Config{Format: UptimeKuma},
+			topic:   "id/results",
+			payload: failed,
+			want:    []ping{{"POST", "/ping/id?msg=exit+code+2&ping=20&status=down", failed}},
+		},
+		{
+			name:    "other topic",
+			topic:   "id/last_success",
+			payload: succeeded,
+		},
+		{
+			name:    "other cron job",
+			topic:   "other/results",
+			payload: succeeded,
+		},
+		{
+			name:    "URL for the cron job",
+			conf:    Config{URLs: map[string]string{"ID": "/other"}},
+			topic:   "id/results",
+			payload: succeeded,
+			want:    []ping{{"POST", "/other", succeeded}},
+		},
+		{
+			name:    "retried server error",
+			status:  []int{http.StatusBadGateway, http.StatusTooManyRequests},
+			topic:   "id/results",
+			payload: succeeded,
+			want:    []ping{{"POST", "/ping/id", succeeded}, {"POST", "/ping/id", succeeded}, {"POST", "/ping/id", succeeded}},
+		},
+		{
+			name:    "too many server errors",
+			status:  []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			topic:   "id/results",
+			payload: succeeded,
+			want:    []ping{{"POST", "/ping/id", succeeded}, {"POST", "/ping/id", succeeded}, {"POST", "/ping/id", succeeded}},
+			wantErr: regexp.MustCompile("500 Internal Server Error"),
+		},
+		{
+			name:    "client error isn't retried",
+			status:  []int{http.StatusNotFound},
+			topic:   "id/results",
+			payload: succeeded,
+			want:    []ping{{"POST", "/ping/id", succeeded}},
+			wantErr: regexp.MustCompile("404 Not Found"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newFakeServer(t, tc.status...)
+			conf := tc.conf
+			conf.URL = s.URL + "/ping/{{.ID}}"
+			if tc.conf.URLs != nil {
+				// The URLs are relative to the server, which doesn't exist yet when the test cases are declared.
+				conf.URLs = make(map[string]string)
+				for id, u := range tc.conf.URLs {
+					conf.URLs[id] = s.URL + u
+				}
+			}
+			conf.Retry = fastRetries
+			c, err := NewClient(conf, "id")
+			if err != nil {
+				t.Fatalf("NewClient failed: %s", err)
+			}
+
+			err = c.Publish(context.Background(), d.TopicPrefix()+"/"+tc.topic, mqtt.QoSExactlyOnce, mqtt.DoNotRetain, tc.payload)
+			if tc.wantErr == nil && err != nil {
+				t.Errorf("Publish failed: %s", err)
+			} else if tc.wantErr != nil && (err == nil || !tc.wantErr.MatchString(err.Error())) {
+				t.Errorf("Publish = %v, want error matching %v", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.want, s.Pings()); diff != "" {
+				t.Errorf("Unexpected pings (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		want   []ping
+	}{
+		{Healthchecks, []ping{{"POST", "/ping/id/start", ""}}},
+		{UptimeKuma, nil},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			s := newFakeServer(t)
+			c, err := NewClient(Config{URL: s.URL + "/ping/{{.ID}}", Format: tc.format}, "id")
+			if err != nil {
+				t.Fatalf("NewClient failed: %s", err)
+			}
+			if err := c.Start(context.Background()); err != nil {
+				t.Errorf("Start failed: %s", err)
+			}
+			if diff := cmp.Diff(tc.want, s.Pings()); diff != "" {
+				t.Errorf("Unexpected pings (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer s.Close()
+	defer close(done)
+
+	c, err := NewClient(Config{URL: s.URL, Timeout: 10 * time.Millisecond, Retry: fastRetries}, "id")
+	if err != nil {
+		t.Fatalf("NewClient failed: %s", err)
+	}
+	if err := c.Start(context.Background()); err == nil {
+		t.Errorf("Start succeeded, want timeout")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		conf    Config
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:    "no URL",
+			conf:    Config{URLs: map[string]string{"other": "https://example.com"}},
+			wantErr: regexp.MustCompile("no webhook URL is configured for id"),
+		},
+		{
+			name:    "unknown format",
+			conf:    Config{URL: "https://example.com", Format: "nagios"},
+			wantErr: regexp.MustCompile(`unknown webhook format "nagios"`),
+		},
+		{
+			name:    "unknown template field",
+			conf:    Config{URL: "https://example.com/{{.Name}}"},
+			wantErr: regexp.MustCompile("invalid webhook URL template"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClient(tc.conf, "id"); err == nil || !tc.wantErr.MatchString(err.Error()) {
+				t.Errorf("NewClient = _, %v, want error matching %v", err, tc.wantErr)
+			}
+		})
+	}
+}